@@ -38,10 +38,58 @@ func IsAuthorizedForOrgID(ctx *gin.Context, id int64) bool {
 	return false
 }
 
+// Get the ID of the organization that owns the API key of the current request, or -1 if there is none
+func AuthorizedOrgID(ctx *gin.Context) int64 {
+	authorizedOrgID, exists := ctx.Get("authorizedOrgID")
+	if !exists {
+		return -1
+	}
+	return authorizedOrgID.(int64)
+}
+
+// Set the organization that owns the API key of the current request. Keys of platform organizations are authorized
+// for every organization
+func SetAuthorizedOrganization(ctx *gin.Context, orgID int64, platform bool) {
+	ctx.Set("authorizedOrgID", orgID)
+	ctx.Set("authorizedPlatform", platform)
+}
+
+// Set the application that owns the API key of the current request. Application keys are authorized for their
+// organization, but only to send events for the application
+func SetAuthorizedProject(ctx *gin.Context, orgID int64, projectID int64) {
+	SetAuthorizedOrganization(ctx, orgID, false)
+	ctx.Set("authorizedProjectID", projectID)
+}
+
+// Get the ID of the application that owns the API key of the current request, or -1 if it is an organization key
+func AuthorizedProjectID(ctx *gin.Context) int64 {
+	projectID, exists := ctx.Get("authorizedProjectID")
+	if !exists {
+		return -1
+	}
+	return projectID.(int64)
+}
+
+// Returns true if the API key of the current request belongs to a platform organization
 func IsAuthorizedForGlobal(ctx *gin.Context) bool {
-	authorizedGlobal, exists := ctx.Get("authorizedGlobal")
-	if exists && authorizedGlobal.(bool) {
+	platform, exists := ctx.Get("authorizedPlatform")
+	if exists && platform.(bool) {
 		return true
 	}
 	return false
 }
+
+// Permissions held by the API key of a request. Organization keys hold every permission except global management,
+// which is reserved for keys of platform organizations
+type apiKeyPermissions struct {
+	global bool
+}
+
+func (k apiKeyPermissions) HasPermission(p Permission) bool {
+	return p != PermissionManageGlobal || k.global
+}
+
+// Get the permissions held by the API key of the current request
+func APIKeyPermissions(ctx *gin.Context) Grantor {
+	return apiKeyPermissions{global: IsAuthorizedForGlobal(ctx)}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// A single action a user can be granted through their role
+type Permission string
+
+const (
+	PermissionViewEvents         Permission = "events.view"
+	PermissionEditEvents         Permission = "events.edit"
+	PermissionManageUsers        Permission = "users.manage"
+	PermissionManageRoles        Permission = "roles.manage"
+	PermissionManageOrganization Permission = "organization.manage"
+	PermissionViewAuditLog       Permission = "audit.view"
+	PermissionManageGlobal       Permission = "global.manage"
+)
+
+// All permissions that can be granted
+var AllPermissions = []Permission{
+	PermissionViewEvents,
+	PermissionEditEvents,
+	PermissionManageUsers,
+	PermissionManageRoles,
+	PermissionManageOrganization,
+	PermissionViewAuditLog,
+	PermissionManageGlobal,
+}
+
+// Anything that can answer whether a permission has been granted, eg. a role
+type Grantor interface {
+	HasPermission(p Permission) bool
+}
+
+// Returns true if `p` is a known permission
+func ValidPermission(p Permission) bool {
+	for _, known := range AllPermissions {
+		if known == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the permissions of the logged in user on the request context, so they can be checked by `HasPermission`
+func SetPermissions(ctx *gin.Context, g Grantor) {
+	ctx.Set("permissions", g)
+}
+
+// Returns true if the logged in user has been granted the supplied permission
+func HasPermission(ctx *gin.Context, p Permission) bool {
+	g, exists := ctx.Get("permissions")
+	if !exists || g == nil {
+		return false
+	}
+	return g.(Grantor).HasPermission(p)
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// How long cached settings are used before they are reloaded. Changes saved on this server are seen straight away,
+// since they invalidate the cache, but changes saved on other servers can take this long to apply
+const DefaultTTL = 30 * time.Second
+
+type entry[V any] struct {
+	value    V
+	loadedAt time.Time
+}
+
+// In memory cache of values loaded from the db, which are reloaded once they are older than the TTL. If reloading a
+// value fails the stale value is kept and used, so a brief db outage doesn't reach callers. Safe for concurrent use
+type Cache[K comparable, V any] struct {
+	name    string // for logging
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[K]entry[V]
+	// incremented by Invalidate, so values loaded before a key was invalidated aren't cached
+	generation uint64
+}
+
+func New[K comparable, V any](name string, ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{
+		name:    name,
+		ttl:     ttl,
+		entries: make(map[K]entry[V]),
+	}
+}
+
+// Get the value of `key`, calling `load` to load it if it isn't cached or has expired. The error of `load` is only
+// returned if there is no stale value to fall back to
+func (c *Cache[K, V]) Get(key K, load func() (V, error)) (V, error) {
+	c.mu.Lock()
+	cached, exists := c.entries[key]
+	generation := c.generation
+	c.mu.Unlock()
+
+	if exists && time.Since(cached.loadedAt) < c.ttl {
+		return cached.value, nil
+	}
+
+	// the lock isn't held while loading, so a slow db doesn't hold up other keys. Concurrent misses may load twice
+	value, err := load()
+	if err != nil {
+		if !exists {
+			return value, err
+		}
+		// keep using the stale value for another TTL, rather than trying the db again on every call
+		log.Printf("[%s] Failed to reload, using cached value: %s", c.name, err.Error())
+		value = cached.value
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.entries[key] = entry[V]{value: value, loadedAt: time.Now()}
+	}
+	c.mu.Unlock()
+
+	return value, nil
+}
+
+// Remove the value of `key`, so it is loaded again the next time it is used
+func (c *Cache[K, V]) Invalidate(key K) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.generation++
+	c.mu.Unlock()
+}
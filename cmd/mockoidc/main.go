@@ -0,0 +1,32 @@
+// Runs a mock OpenID Connect provider for testing single sign-on locally. Configure an organization's identity provider
+// with the issuer URL printed on startup and the client ID and secret set below
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/slimnate/laser-beam/oidc/mockprovider"
+)
+
+func main() {
+	addr := os.Getenv("MOCK_OIDC_ADDR")
+	if addr == "" {
+		addr = "localhost:9090"
+	}
+
+	clientID := os.Getenv("MOCK_OIDC_CLIENT_ID")
+	clientSecret := os.Getenv("MOCK_OIDC_CLIENT_SECRET")
+	if clientID == "" {
+		clientID, clientSecret = "laserbeam", "mock-secret"
+	}
+
+	p, err := mockprovider.New("http://"+addr, clientID, clientSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Mock OIDC provider - issuer: %s | client ID: %s | client secret: %s", p.Issuer, p.ClientID, p.ClientSecret)
+	log.Fatal(http.ListenAndServe(addr, p))
+}
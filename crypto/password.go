@@ -4,8 +4,15 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// bcrypt cost used for password hashes
+const PasswordCost = 15
+
+// A valid hash with the same cost as real password hashes, used to make failed logins for unknown users take as long as
+// failed logins for real ones
+const dummyHash = "$2a$15$dRgGBE56DiFg/I2sarfnKOYk6GMHSo/A5U38OIDpjKeePBGlLFqKe"
+
 func HashPassword(p string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(p), 15)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(p), PasswordCost)
 	if err != nil {
 		return "", err
 	}
@@ -19,3 +26,10 @@ func TestMatch(p string, h string) bool {
 	}
 	return true
 }
+
+// Do the same work as TestMatch without a real hash to compare against, so response times don't reveal whether a
+// username exists. Always returns false
+func SimulateMatch(p string) bool {
+	TestMatch(p, dummyHash)
+	return false
+}
@@ -0,0 +1,26 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Number of random bytes in a generated token. Tokens are hex encoded, so their string length is double this
+const TokenBytes = 32
+
+// Generate a random token suitable for use in links and cookies, using a cryptographically secure random source
+func GenerateToken() (string, error) {
+	b := make([]byte, TokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Hash a token for storage, so a leaked database can't be used to recover valid tokens. Tokens are long and random,
+// so a fast unsalted hash is sufficient, unlike for passwords
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
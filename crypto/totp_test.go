@@ -0,0 +1,131 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Base32 encoding of the SHA-1 secret of RFC 6238 Appendix B, "12345678901234567890"
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 Appendix B SHA-1 test vectors. The RFC uses 8 digit codes, so the expected codes are their last 6 digits
+var rfc6238Vectors = []struct {
+	unix int64
+	step int64
+	code string
+}{
+	{59, 0x1, "287082"},
+	{1111111109, 0x23523EC, "081804"},
+	{1111111111, 0x23523ED, "050471"},
+	{1234567890, 0x273EF07, "005924"},
+	{2000000000, 0x3F940AA, "279037"},
+	{20000000000, 0x27BC86AA, "353130"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		at := time.Unix(tt.unix, 0)
+		if step := TOTPStep(at); step != tt.step {
+			t.Errorf("TOTPStep(%d) = %d, expected %d", tt.unix, step, tt.step)
+		}
+
+		code, err := TOTPCode(rfc6238Secret, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("TOTPCode at %d = %s, expected %s", tt.unix, code, tt.code)
+		}
+
+		// secrets are decoded regardless of case
+		if code, _ := TOTPCode(strings.ToLower(rfc6238Secret), tt.step); code != tt.code {
+			t.Errorf("TOTPCode with a lower case secret at %d = %s, expected %s", tt.unix, code, tt.code)
+		}
+	}
+
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("expected an invalid secret to fail")
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	// 1111111111 is in step 0x23523ED, which has the code 050471
+	const step = 0x23523ED
+	at := time.Unix(1111111111, 0)
+	period := int64(TOTPPeriod / time.Second)
+
+	code := func(step int64) string {
+		c, err := TOTPCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		code  string
+		at    time.Time
+		step  int64
+		match bool
+	}{
+		{"current step", "050471", at, step, true},
+		{"with spaces", " 050 471 ", at, step, true},
+		{"previous step", code(step - 1), at, step - 1, true},
+		{"next step", code(step + 1), at, step + 1, true},
+		{"two steps behind", code(step - 2), at, 0, false},
+		{"two steps ahead", code(step + 2), at, 0, false},
+		{"checked a step later", "050471", time.Unix(1111111111+period, 0), step, true},
+		{"checked two steps later", "050471", time.Unix(1111111111+2*period, 0), 0, false},
+		{"wrong code", "123456", at, 0, false},
+		{"too short", "05047", at, 0, false},
+		{"empty", "", at, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := MatchTOTP(rfc6238Secret, tt.code, tt.at)
+			if ok != tt.match {
+				t.Fatalf("MatchTOTP(%q) matched %t, expected %t", tt.code, ok, tt.match)
+			}
+			if ok && step != tt.step {
+				t.Errorf("MatchTOTP(%q) matched step %d, expected %d", tt.code, step, tt.step)
+			}
+		})
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	// normalized to lower case without dashes, then hashed with HashToken (SHA-256)
+	if hash := HashRecoveryCode("ABCD-1234"); hash != "e9cee71ab932fde863338d08be4de9dfe39ea049bdafb342ce659ec5450b69ae" {
+		t.Errorf("unexpected hash %s", hash)
+	}
+
+	codes, err := GenerateRecoveryCodes(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codes[0] == codes[1] {
+		t.Error("expected generated recovery codes to differ")
+	}
+
+	code := codes[0]
+	hash := HashRecoveryCode(code)
+	for _, variant := range []string{
+		strings.ToUpper(code),
+		strings.ReplaceAll(code, "-", ""),
+		strings.ReplaceAll(code, "-", " "),
+		"  " + code + "\n",
+	} {
+		if HashRecoveryCode(variant) != hash {
+			t.Errorf("expected %q to hash the same as %q", variant, code)
+		}
+	}
+
+	if HashRecoveryCode(codes[1]) == hash {
+		t.Error("expected different recovery codes to hash differently")
+	}
+	if hash == code || strings.Contains(hash, strings.ReplaceAll(code, "-", "")) {
+		t.Error("expected the hash not to contain the code")
+	}
+}
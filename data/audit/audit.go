@@ -0,0 +1,212 @@
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/auth"
+)
+
+// Types of object an action can be performed on
+const (
+	TargetUser             = "user"
+	TargetEvent            = "event"
+	TargetRole             = "role"
+	TargetInvitation       = "invitation"
+	TargetSession          = "session"
+	TargetOrganization     = "organization"
+	TargetIdentityProvider = "identity_provider"
+	TargetProject          = "project"
+	TargetRule             = "rule"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionLogin                   = "auth.login"
+	ActionLoginFailed             = "auth.login_failed"
+	ActionLogout                  = "auth.logout"
+	ActionPasswordChanged         = "account.password_changed"
+	ActionPasswordReset           = "account.password_reset"
+	ActionProfileUpdated          = "account.profile_updated"
+	ActionEmailChanged            = "account.email_changed"
+	ActionTwoFactorEnabled        = "account.two_factor_enabled"
+	ActionTwoFactorDisabled       = "account.two_factor_disabled"
+	ActionRecoveryCodesGenerated  = "account.recovery_codes_generated"
+	ActionReauthenticationFailure = "account.reauthentication_failed"
+	ActionSessionRevoked          = "account.session_revoked"
+	ActionUserCreated             = "user.created"
+	ActionUserUpdated             = "user.updated"
+	ActionUserActivated           = "user.activated"
+	ActionUserDeactivated         = "user.deactivated"
+	ActionUserDeleted             = "user.deleted"
+	ActionUserInvited             = "user.invited"
+	ActionInvitationRevoked       = "user.invitation_revoked"
+	ActionMemberAdded             = "user.member_added"
+	ActionMemberRemoved           = "user.member_removed"
+	ActionEventUpdated            = "event.updated"
+	ActionRoleCreated             = "role.created"
+	ActionRoleUpdated             = "role.updated"
+	ActionRoleDeleted             = "role.deleted"
+	ActionOrganizationCreated     = "organization.created"
+	ActionOrganizationUpdated     = "organization.updated"
+	ActionOrganizationDeleted     = "organization.deleted"
+	ActionLimitsUpdated           = "organization.limits_updated"
+	ActionPasswordPolicyUpdated   = "organization.password_policy_updated"
+	ActionScrubbingUpdated        = "organization.scrubbing_updated"
+	ActionAPIKeyChanged           = "organization.api_key_changed"
+	ActionProjectCreated          = "project.created"
+	ActionProjectUpdated          = "project.updated"
+	ActionProjectDeleted          = "project.deleted"
+	ActionProjectKeyChanged       = "project.api_key_changed"
+	ActionRuleCreated             = "rule.created"
+	ActionRuleDeleted             = "rule.deleted"
+	ActionUserProvisioned         = "sso.user_provisioned"
+	ActionIdentityLinked          = "sso.identity_linked"
+	ActionIdentityProviderUpdated = "sso.provider_updated"
+)
+
+// All actions, in the order they are listed when filtering the audit log
+var AllActions = []string{
+	ActionLogin,
+	ActionLoginFailed,
+	ActionLogout,
+	ActionPasswordChanged,
+	ActionPasswordReset,
+	ActionProfileUpdated,
+	ActionEmailChanged,
+	ActionTwoFactorEnabled,
+	ActionTwoFactorDisabled,
+	ActionRecoveryCodesGenerated,
+	ActionReauthenticationFailure,
+	ActionSessionRevoked,
+	ActionUserCreated,
+	ActionUserUpdated,
+	ActionUserActivated,
+	ActionUserDeactivated,
+	ActionUserDeleted,
+	ActionUserInvited,
+	ActionInvitationRevoked,
+	ActionMemberAdded,
+	ActionMemberRemoved,
+	ActionEventUpdated,
+	ActionRoleCreated,
+	ActionRoleUpdated,
+	ActionRoleDeleted,
+	ActionOrganizationCreated,
+	ActionOrganizationUpdated,
+	ActionOrganizationDeleted,
+	ActionLimitsUpdated,
+	ActionPasswordPolicyUpdated,
+	ActionScrubbingUpdated,
+	ActionAPIKeyChanged,
+	ActionProjectCreated,
+	ActionProjectUpdated,
+	ActionProjectDeleted,
+	ActionProjectKeyChanged,
+	ActionRuleCreated,
+	ActionRuleDeleted,
+	ActionUserProvisioned,
+	ActionIdentityLinked,
+	ActionIdentityProviderUpdated,
+}
+
+// All target types, in the order they are listed when filtering the audit log
+var AllTargetTypes = []string{
+	TargetUser,
+	TargetEvent,
+	TargetRole,
+	TargetInvitation,
+	TargetSession,
+	TargetOrganization,
+	TargetIdentityProvider,
+	TargetProject,
+	TargetRule,
+}
+
+type Entry struct {
+	ID             int64
+	OrganizationID int64
+	// user that performed the action, nil for actions made with an API key or by the system
+	ActorID *int64
+	// name of the actor at the time of the action, kept so the entry still makes sense if the actor is deleted
+	ActorName  string
+	Action     string
+	TargetType string
+	TargetID   *int64
+	IP         string
+	// free text description of the action
+	Details string
+	// fields changed by the action, for updates
+	Changes []Change
+	Time    time.Time
+}
+
+// A single field changed by an action
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Start a new entry for an action in an organization. Use the `By` and `On` methods to add the actor and target
+func New(orgID int64, action string, ip string) Entry {
+	return Entry{
+		OrganizationID: orgID,
+		Action:         action,
+		IP:             ip,
+		ActorName:      "system",
+	}
+}
+
+// Start a new entry for an action made through the API in an organization, with the request's API key as the actor
+func FromAPIKey(ctx *gin.Context, orgID int64, action string) Entry {
+	return New(orgID, action, ctx.ClientIP()).ByAPIKey(auth.AuthorizedOrgID(ctx))
+}
+
+// Set the user that performed the action
+func (e Entry) By(userID int64, username string) Entry {
+	e.ActorID = &userID
+	e.ActorName = username
+	return e
+}
+
+// Set the actor to the API key of an organization, for actions made through the API
+func (e Entry) ByAPIKey(keyOrgID int64) Entry {
+	e.ActorID = nil
+	e.ActorName = fmt.Sprintf("API key (organization %d)", keyOrgID)
+	return e
+}
+
+// Set the object the action was performed on
+func (e Entry) On(targetType string, id int64) Entry {
+	e.TargetType = targetType
+	e.TargetID = &id
+	return e
+}
+
+func (e Entry) WithDetails(details string) Entry {
+	e.Details = details
+	return e
+}
+
+func (e Entry) WithChanges(changes []Change) Entry {
+	e.Changes = changes
+	return e
+}
+
+func (e *Entry) FormattedTime() string {
+	return e.Time.Format("2006/01/02 15:04:05")
+}
+
+// Describes the target of the entry for display, eg. `user #12`
+func (e *Entry) Target() string {
+	if e.TargetID == nil {
+		return e.TargetType
+	}
+	return fmt.Sprintf("%s #%d", e.TargetType, *e.TargetID)
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New)
+}
@@ -0,0 +1,123 @@
+package audit
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/data"
+)
+
+type AuditController struct {
+	repo *AuditRepository
+}
+
+func NewAuditController(repo *AuditRepository) *AuditController {
+	return &AuditController{
+		repo: repo,
+	}
+}
+
+// Handler for GET /org/:org_id/audit
+func (c *AuditController) List(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	f, err := ParseFilter(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	pag, err := data.ParsePaginationRequestOptions(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, err := c.repo.AllForOrganization(orgID, f, pag)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, entries)
+}
+
+// Handler for GET /org/:org_id/audit/export. Returns up to MaxExportEntries entries matching the filter as a CSV file
+func (c *AuditController) Export(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	f, err := ParseFilter(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, err := c.repo.Export(orgID, f)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-Type", "text/csv")
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-log-%d-%s.csv"`, orgID, time.Now().Format(DateFormat)))
+	ctx.Status(200)
+
+	if err := WriteCSV(ctx.Writer, entries); err != nil {
+		ctx.Error(err)
+	}
+}
+
+// Write entries as CSV, with a header row. Changes are written as `field: old -> new` separated by semicolons
+func WriteCSV(out io.Writer, entries []Entry) error {
+	w := csv.NewWriter(out)
+	if err := w.Write([]string{"id", "time", "action", "actor_id", "actor", "target_type", "target_id", "ip_address", "details", "changes"}); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		changes := make([]string, len(e.Changes))
+		for i, ch := range e.Changes {
+			changes[i] = ch.String()
+		}
+
+		record := []string{
+			strconv.FormatInt(e.ID, 10),
+			e.Time.Format(time.RFC3339),
+			e.Action,
+			formatID(e.ActorID),
+			e.ActorName,
+			e.TargetType,
+			formatID(e.TargetID),
+			e.IP,
+			e.Details,
+			strings.Join(changes, "; "),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+func formatID(id *int64) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatInt(*id, 10)
+}
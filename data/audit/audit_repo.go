@@ -0,0 +1,215 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/slimnate/laser-beam/data"
+)
+
+// Maximum number of entries returned by a single export
+const MaxExportEntries = 10000
+
+type AuditRepository struct {
+	db *sql.DB
+}
+
+func NewAuditRepository(db *sql.DB) *AuditRepository {
+	return &AuditRepository{
+		db: db,
+	}
+}
+
+func (r *AuditRepository) Migrate() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS audit_log(
+			id SERIAL PRIMARY KEY,
+			organization_id INTEGER NOT NULL,
+			actor_id INTEGER,
+			action VARCHAR(64) NOT NULL,
+			ip_address VARCHAR(45) NOT NULL,
+			details TEXT NOT NULL,
+			time TIMESTAMP NOT NULL,
+			FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
+			FOREIGN KEY(actor_id) REFERENCES users(id) ON DELETE SET NULL
+		)`,
+		// add columns introduced after the table was first created
+		"ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS actor_name VARCHAR(100) NOT NULL DEFAULT ''",
+		"ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS target_type VARCHAR(32) NOT NULL DEFAULT ''",
+		"ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS target_id INTEGER",
+		"ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS changes JSONB NOT NULL DEFAULT '[]'",
+		"CREATE INDEX IF NOT EXISTS audit_log_organization_id_idx ON audit_log(organization_id, time)",
+	}
+
+	for _, q := range queries {
+		if _, err := r.db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return r.migrateTargetUser()
+}
+
+// Move targets from the target_user_id column used before entries could target anything other than users
+func (r *AuditRepository) migrateTargetUser() error {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT FROM information_schema.columns
+		WHERE table_name = 'audit_log' AND column_name = 'target_user_id')`).Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		"UPDATE audit_log SET target_type = 'user', target_id = target_user_id WHERE target_user_id IS NOT NULL",
+		"UPDATE audit_log a SET actor_name = u.username FROM users u WHERE u.id = a.actor_id AND a.actor_name = ''",
+		"ALTER TABLE audit_log DROP COLUMN target_user_id",
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Add an entry to the audit log. The entry time is set to the current time
+func (r *AuditRepository) Record(e Entry) (*Entry, error) {
+	e.Time = time.Now()
+	if e.Changes == nil {
+		e.Changes = []Change{}
+	}
+
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO audit_log(organization_id, actor_id, actor_name, action, target_type, target_id, ip_address, details, changes, time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
+	err = r.db.QueryRow(query, e.OrganizationID, e.ActorID, e.ActorName, e.Action, e.TargetType, e.TargetID, e.IP, e.Details, changes, e.Time).Scan(&e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// Add an entry to the audit log, logging failures instead of returning them. For use after the audited change has
+// already been made, when failing the request would misreport the change as not having happened
+func (r *AuditRepository) TryRecord(e Entry) {
+	if _, err := r.Record(e); err != nil {
+		log.Printf("Unable to record audit entry '%s': %s", e.Action, err.Error())
+	}
+}
+
+// Build the where clause (without the WHERE keyword) and matching query args for the entries of an organization
+// matching the filter
+func whereClause(orgID int64, f Filter) (string, []any) {
+	clauses := []string{"organization_id = $1"}
+	args := []any{orgID}
+
+	add := func(clause string, arg any) {
+		args = append(args, arg)
+		clauses = append(clauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.ActorID != 0 {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != 0 {
+		add("target_id = $%d", f.TargetID)
+	}
+	if !f.From.IsZero() {
+		add("time >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("time < $%d", f.To.AddDate(0, 0, 1))
+	}
+
+	return strings.Join(clauses, " AND "), args
+}
+
+// Query entries, newest first, scanning each row into an Entry
+func (r *AuditRepository) query(where string, args []any, limit int64, offset int64) ([]Entry, error) {
+	query := fmt.Sprintf(`SELECT id, organization_id, actor_id, actor_name, action, target_type, target_id, ip_address, details, changes, time
+		FROM audit_log WHERE %s ORDER BY time DESC, id DESC LIMIT $%d OFFSET $%d`, where, len(args)+1, len(args)+2)
+
+	rows, err := r.db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		var actorID, targetID sql.NullInt64
+		var changes []byte
+		if err := rows.Scan(&e.ID, &e.OrganizationID, &actorID, &e.ActorName, &e.Action, &e.TargetType, &targetID, &e.IP, &e.Details, &changes, &e.Time); err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			e.ActorID = &actorID.Int64
+		}
+		if targetID.Valid {
+			e.TargetID = &targetID.Int64
+		}
+		if err := json.Unmarshal(changes, &e.Changes); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Get a page of an organization's entries matching the filter, newest first. The order of the pagination options is ignored
+func (r *AuditRepository) AllForOrganization(orgID int64, f Filter, pag *data.PaginationRequestOptions) (*data.PaginationResponseData[[]Entry], error) {
+	if pag == nil {
+		pag = data.DefaultPaginationRequestOptions()
+	}
+
+	where, args := whereClause(orgID, f)
+
+	entries, err := r.query(where, args, pag.Limit, pag.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM audit_log WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	return &data.PaginationResponseData[[]Entry]{
+		Data:         entries,
+		Request:      pag,
+		PreviousPage: pag.Previous(total),
+		NextPage:     pag.Next(total),
+		Total:        total,
+		Start:        min(pag.Offset+1, total),
+		End:          min(pag.Offset+pag.Limit, total),
+	}, nil
+}
+
+// Get up to MaxExportEntries of an organization's entries matching the filter, newest first
+func (r *AuditRepository) Export(orgID int64, f Filter) ([]Entry, error) {
+	where, args := whereClause(orgID, f)
+	return r.query(where, args, MaxExportEntries, 0)
+}
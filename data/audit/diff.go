@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Value recorded in place of sensitive fields, so secrets never end up in the audit log
+const Redacted = "[redacted]"
+
+// Fields that are recorded as changed without their values
+var sensitiveFields = []string{"password", "secret", "key", "token"}
+
+func isSensitive(field string) bool {
+	lower := strings.ToLower(field)
+	for _, s := range sensitiveFields {
+		if strings.Contains(lower, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare two values of the same struct type, returning the exported fields that differ. Embedded structs are
+// compared field by field, while pointers, slices, maps and other nested structs are skipped, except for times
+func Diff(before any, after any) []Change {
+	b := reflect.Indirect(reflect.ValueOf(before))
+	a := reflect.Indirect(reflect.ValueOf(after))
+	if !b.IsValid() || !a.IsValid() || b.Type() != a.Type() || b.Kind() != reflect.Struct {
+		return nil
+	}
+
+	return diffStruct(b, a)
+}
+
+func diffStruct(b reflect.Value, a reflect.Value) []Change {
+	var changes []Change
+
+	for i := 0; i < b.NumField(); i++ {
+		field := b.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		bf, af := b.Field(i), a.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			changes = append(changes, diffStruct(bf, af)...)
+			continue
+		}
+
+		old, okOld := formatValue(bf)
+		new, okNew := formatValue(af)
+		if !okOld || !okNew || old == new {
+			continue
+		}
+
+		if isSensitive(field.Name) {
+			old, new = Redacted, Redacted
+		}
+		changes = append(changes, Change{Field: field.Name, Old: old, New: new})
+	}
+
+	return changes
+}
+
+// Format a field value for the audit log. Returns false for values that aren't compared
+func formatValue(v reflect.Value) (string, bool) {
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339), true
+	}
+
+	switch v.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface()), true
+	default:
+		return "", false
+	}
+}
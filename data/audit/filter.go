@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Date format used by the `from` and `to` filter params
+const DateFormat = "2006-01-02"
+
+// Criteria for listing audit log entries. Zero values match every entry
+type Filter struct {
+	Action     string
+	ActorID    int64
+	TargetType string
+	TargetID   int64
+	// entries on or after the start of this day
+	From time.Time
+	// entries before the end of this day
+	To time.Time
+}
+
+// Parse a filter from the `action`, `actor_id`, `target_type`, `target_id`, `from` and `to` query params of a request
+func ParseFilter(ctx *gin.Context) (Filter, error) {
+	f := Filter{
+		Action:     ctx.Query("action"),
+		TargetType: ctx.Query("target_type"),
+	}
+
+	var err error
+	if s := ctx.Query("actor_id"); s != "" {
+		if f.ActorID, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return f, fmt.Errorf("invalid actor_id '%s'", s)
+		}
+	}
+	if s := ctx.Query("target_id"); s != "" {
+		if f.TargetID, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return f, fmt.Errorf("invalid target_id '%s'", s)
+		}
+	}
+	if s := ctx.Query("from"); s != "" {
+		if f.From, err = time.Parse(DateFormat, s); err != nil {
+			return f, fmt.Errorf("invalid from date '%s', must be in the format YYYY-MM-DD", s)
+		}
+	}
+	if s := ctx.Query("to"); s != "" {
+		if f.To, err = time.Parse(DateFormat, s); err != nil {
+			return f, fmt.Errorf("invalid to date '%s', must be in the format YYYY-MM-DD", s)
+		}
+	}
+
+	return f, nil
+}
+
+// Returns the filter as query params, for building links that keep the current filter
+func (f Filter) QueryParams() string {
+	q := url.Values{}
+	if f.Action != "" {
+		q.Set("action", f.Action)
+	}
+	if f.ActorID != 0 {
+		q.Set("actor_id", strconv.FormatInt(f.ActorID, 10))
+	}
+	if f.TargetType != "" {
+		q.Set("target_type", f.TargetType)
+	}
+	if f.TargetID != 0 {
+		q.Set("target_id", strconv.FormatInt(f.TargetID, 10))
+	}
+	if !f.From.IsZero() {
+		q.Set("from", f.From.Format(DateFormat))
+	}
+	if !f.To.IsZero() {
+		q.Set("to", f.To.Format(DateFormat))
+	}
+	return q.Encode()
+}
+
+// Formatted dates for filling in the date inputs of the filter form
+func (f Filter) FromDate() string {
+	if f.From.IsZero() {
+		return ""
+	}
+	return f.From.Format(DateFormat)
+}
+
+func (f Filter) ToDate() string {
+	if f.To.IsZero() {
+		return ""
+	}
+	return f.To.Format(DateFormat)
+}
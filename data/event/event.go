@@ -1,15 +1,88 @@
 package event
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slimnate/laser-beam/data"
+)
+
+// How often events past their organization's retention period are removed from the db
+const RetentionCleanupInterval = time.Hour
+
+// Columns the event list can be filtered by
+var FilterColumns = data.FilterColumns
 
 type Event struct {
-	ID             int64
-	Type           string
-	Application    string
-	Name           string
-	Message        string
+	ID          int64
+	Type        string
+	Application string
+	// project of the application the event was sent for, nil for events sent without an application
+	ProjectID *int64
+	Name      string
+	Message   string
+	// where the application was running, eg. "production" or "staging"
+	Environment string
+	// version of the application that sent the event, eg. "1.4.2"
+	Release        string
 	Time           time.Time
 	OrganizationID int64
+	// sensitive data removed from the event before it was saved
+	Redactions []Redaction
+}
+
+// Matches of one kind of sensitive data that were removed from a field of an event
+type Redaction struct {
+	Field    string
+	Detector string
+	Count    int
+}
+
+// What happened to an event sent to the API
+type Outcome string
+
+const (
+	OutcomeKept    Outcome = "kept"
+	OutcomeDropped Outcome = "dropped" // removed by a drop rule
+	OutcomeSampled Outcome = "sampled" // not kept by a sample rule
+	OutcomeQueued  Outcome = "queued"  // accepted, and waiting in the ingestion queue to be saved
+)
+
+// Removes sensitive data from events before they are saved, recording what was removed in their Redactions.
+// Implemented by the scrubbing package
+type Scrubber interface {
+	ScrubEvent(orgID int64, e *Event) error
+}
+
+// Decides whether an event is kept before it is saved, and may rewrite its fields. Implemented by the rule package
+type IngestionFilter interface {
+	FilterEvent(orgID int64, e *Event) (Outcome, error)
+}
+
+// An event along with the surrounding context needed to render its detail page
+type EventDetails struct {
+	Event
+	Related  []Event
+	Previous *Event
+	Next     *Event
+	Request  *data.PaginationRequestOptions // the list request the event was opened from, used to build navigation links
+}
+
+// Add a redaction to the list, combining it with any for the same field and detector
+func AddRedaction(redactions []Redaction, r Redaction) []Redaction {
+	for i := range redactions {
+		if redactions[i].Field == r.Field && redactions[i].Detector == r.Detector {
+			redactions[i].Count += r.Count
+			return redactions
+		}
+	}
+	return append(redactions, r)
+}
+
+// Describe a redaction for display, eg. "message: 2 email"
+func (r Redaction) String() string {
+	return fmt.Sprintf("%s: %d %s", r.Field, r.Count, strings.ReplaceAll(r.Detector, "_", " "))
 }
 
 func (e *Event) FormattedTime() string {
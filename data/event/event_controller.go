@@ -1,23 +1,87 @@
 package event
 
 import (
+	"errors"
+	"fmt"
+	"slices"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/slimnate/laser-beam/auth"
 	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/project"
+	"github.com/slimnate/laser-beam/data/release"
+	"github.com/slimnate/laser-beam/data/usage"
 )
 
+// Validates events sent to the API before they are saved
+type Validator interface {
+	ValidateEvent(e *Event) (valid bool, errors map[string]string)
+}
+
+// Seconds clients are asked to wait before retrying when the ingestion queue is full
+const queueFullRetryAfter = 1
+
 type EventController struct {
-	repo *EventRepository
+	repo        *EventRepository
+	queue       *IngestionQueue
+	projectRepo *project.ProjectRepository
+	releaseRepo *release.ReleaseRepository
+	usageRepo   *usage.UsageRepository
+	filter      IngestionFilter
+	scrubber    Scrubber
+	validator   Validator
+	auditRepo   *audit.AuditRepository
 }
 
-func NewEventController(repo *EventRepository) *EventController {
+func NewEventController(repo *EventRepository, queue *IngestionQueue, projectRepo *project.ProjectRepository, releaseRepo *release.ReleaseRepository, usageRepo *usage.UsageRepository, filter IngestionFilter, scrubber Scrubber, validator Validator, auditRepo *audit.AuditRepository) *EventController {
 	return &EventController{
-		repo: repo,
+		repo:        repo,
+		queue:       queue,
+		projectRepo: projectRepo,
+		releaseRepo: releaseRepo,
+		usageRepo:   usageRepo,
+		filter:      filter,
+		scrubber:    scrubber,
+		validator:   validator,
+		auditRepo:   auditRepo,
+	}
+}
+
+// Track the release of a saved event. Only events of an application have releases
+func (c *EventController) recordRelease(e *Event) {
+	if e.ProjectID != nil && e.Release != "" {
+		c.releaseRepo.TryRecord(*e.ProjectID, e.Release, e.Time)
 	}
 }
 
+// Link an event to the project of its application. Events sent with an application key always belong to that
+// application, otherwise the application is looked up by the slug or name sent with the event. Returns a nil project
+// for events without an application, and the status to respond with if the application can't be used
+func (c *EventController) linkProject(ctx *gin.Context, orgID int64, e *Event) (*project.Project, int, error) {
+	var p *project.Project
+	var err error
+	if projectID := auth.AuthorizedProjectID(ctx); projectID != -1 {
+		p, err = c.projectRepo.GetCachedByID(projectID, orgID)
+	} else if e.Application != "" {
+		p, err = c.projectRepo.GetCachedByApplication(orgID, e.Application)
+		if errors.Is(err, data.ErrNotExists) {
+			return nil, 400, fmt.Errorf("unknown application '%s'", e.Application)
+		}
+	} else {
+		e.ProjectID = nil
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 500, err
+	}
+
+	e.ProjectID = &p.ID
+	e.Application = p.Name
+	return p, 0, nil
+}
+
 func (c *EventController) ListGlobal(ctx *gin.Context) {
 	if !auth.IsAuthorizedForGlobal(ctx) {
 		ctx.AbortWithStatusJSON(401, gin.H{"error": "not authorized"})
@@ -98,18 +162,57 @@ func (c *EventController) Create(ctx *gin.Context) {
 		return
 	}
 
-	created, err := c.repo.Create(e, orgID)
+	p, status, err := c.linkProject(ctx, orgID, &e)
+	if err != nil {
+		ctx.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if p != nil && !p.Enabled {
+		ctx.AbortWithStatusJSON(403, gin.H{"error": fmt.Sprintf("application '%s' is disabled", p.Name)})
+		return
+	}
+
+	// the organization's ingestion rules can rewrite the event, or stop it from being saved
+	outcome, err := c.filter.FilterEvent(orgID, &e)
 	if err != nil {
 		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	switch outcome {
+	case OutcomeDropped:
+		c.usageRepo.TryIncrement(orgID, usage.Counts{Dropped: 1})
+		ctx.JSON(202, gin.H{"outcome": outcome})
+		return
+	case OutcomeSampled:
+		c.usageRepo.TryIncrement(orgID, usage.Counts{Sampled: 1})
+		ctx.JSON(202, gin.H{"outcome": outcome})
+		return
+	}
 
-	ctx.JSON(200, created)
+	// sensitive data is removed last, so it also covers values set by rewrite rules
+	if err := c.scrubber.ScrubEvent(orgID, &e); err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if valid, errs := c.validator.ValidateEvent(&e); !valid {
+		ctx.AbortWithStatusJSON(400, gin.H{"errors": errs})
+		return
+	}
+
+	// the event is saved by the ingestion queue's workers, which also count it in the organization's usage
+	e.OrganizationID = orgID
+	if err := c.queue.Enqueue(e); err != nil {
+		ctx.Header("Retry-After", strconv.Itoa(queueFullRetryAfter))
+		ctx.AbortWithStatusJSON(503, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(202, gin.H{"outcome": OutcomeQueued})
 }
 
 func (c *EventController) Update(ctx *gin.Context) {
-	// validate org ID, but we don't need it for the request
-	_, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
 	if err != nil {
 		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
 		return
@@ -122,6 +225,13 @@ func (c *EventController) Update(ctx *gin.Context) {
 		return
 	}
 
+	// make sure the event belongs to the organization, and keep its current values for the audit log
+	existing, err := c.repo.GetByIDAndOrg(id, orgID)
+	if err != nil {
+		ctx.AbortWithStatusJSON(404, gin.H{"error": "event not found"})
+		return
+	}
+
 	// read updated event from body
 	var e Event
 	if err := ctx.ShouldBindJSON(&e); err != nil {
@@ -129,11 +239,50 @@ func (c *EventController) Update(ctx *gin.Context) {
 		return
 	}
 
+	if _, status, err := c.linkProject(ctx, orgID, &e); err != nil {
+		ctx.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.scrubber.ScrubEvent(orgID, &e); err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the saved text was scrubbed when the event was sent, so scrubbing it again finds nothing. Keep the record of what
+	// was removed then, along with anything removed from the update
+	redactions := slices.Clone(existing.Redactions)
+	for _, r := range e.Redactions {
+		redactions = AddRedaction(redactions, r)
+	}
+	e.Redactions = redactions
+
+	if valid, errs := c.validator.ValidateEvent(&e); !valid {
+		ctx.AbortWithStatusJSON(400, gin.H{"errors": errs})
+		return
+	}
+
 	updated, err := c.repo.Update(id, e)
 	if err != nil {
 		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.recordRelease(updated)
+
+	if changes := audit.Diff(*existing, *updated); len(changes) > 0 {
+		c.auditRepo.TryRecord(audit.FromAPIKey(ctx, orgID, audit.ActionEventUpdated).On(audit.TargetEvent, id).WithChanges(changes))
+	}
+
 	ctx.JSON(200, updated)
 }
+
+// Handler for /ingestion/stats, reporting the depth of the ingestion queue and how many events it has handled
+func (c *EventController) QueueStats(ctx *gin.Context) {
+	if !auth.IsAuthorizedForGlobal(ctx) {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": "not authorized"})
+		return
+	}
+
+	ctx.JSON(200, c.queue.Stats())
+}
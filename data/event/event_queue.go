@@ -0,0 +1,298 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/slimnate/laser-beam/data/release"
+	"github.com/slimnate/laser-beam/data/usage"
+)
+
+const (
+	DefaultQueueSize     = 10000
+	DefaultQueueWorkers  = 4
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = time.Second // longest an event waits in a partly filled batch before it is written
+
+	// Attempts to write a batch while the db is unavailable before its events are given up on, waiting longer after
+	// each failure
+	batchAttempts     = 3
+	batchRetryBackoff = time.Second
+)
+
+var (
+	ErrQueueFull   = errors.New("ingestion queue is full")
+	ErrQueueClosed = errors.New("ingestion queue is shutting down")
+)
+
+type QueueConfig struct {
+	Size          int // events that can wait to be written before new ones are rejected
+	Workers       int
+	BatchSize     int // most events each worker writes in one transaction
+	FlushInterval time.Duration
+}
+
+// Read the ingestion queue config from the INGEST_QUEUE_SIZE, INGEST_WORKERS, INGEST_BATCH_SIZE and
+// INGEST_FLUSH_INTERVAL env variables, using the defaults for any that aren't set
+func QueueConfigFromEnv() (QueueConfig, error) {
+	cfg := QueueConfig{
+		Size:          DefaultQueueSize,
+		Workers:       DefaultQueueWorkers,
+		BatchSize:     DefaultBatchSize,
+		FlushInterval: DefaultFlushInterval,
+	}
+
+	ints := map[string]*int{
+		"INGEST_QUEUE_SIZE": &cfg.Size,
+		"INGEST_WORKERS":    &cfg.Workers,
+		"INGEST_BATCH_SIZE": &cfg.BatchSize,
+	}
+	for name, dest := range ints {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return cfg, fmt.Errorf("invalid %s: %s", name, v)
+			}
+			*dest = n
+		}
+	}
+
+	if v := os.Getenv("INGEST_FLUSH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid INGEST_FLUSH_INTERVAL: %s", v)
+		}
+		cfg.FlushInterval = d
+	}
+
+	return cfg, nil
+}
+
+// Snapshot of the ingestion queue, for monitoring
+type QueueStats struct {
+	Depth    int // events waiting to be written
+	Capacity int
+	Workers  int
+	// totals since the server started
+	Enqueued int64
+	Written  int64
+	Failed   int64 // events that couldn't be saved, or were given up on while the db was unavailable
+	Rejected int64 // events turned away because the queue was full
+}
+
+// Bounded queue of events sent to the API, written to the db in batches by a pool of workers so clients don't wait
+// on the insert. Saved events are added to their organization's usage and their application's releases
+type IngestionQueue struct {
+	repo        *EventRepository
+	releaseRepo *release.ReleaseRepository
+	usageRepo   *usage.UsageRepository
+	cfg         QueueConfig
+
+	events chan Event
+	// held for reading while enqueueing, so the channel isn't closed during a send
+	mu      sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+
+	enqueued atomic.Int64
+	written  atomic.Int64
+	failed   atomic.Int64
+	rejected atomic.Int64
+}
+
+func NewIngestionQueue(repo *EventRepository, releaseRepo *release.ReleaseRepository, usageRepo *usage.UsageRepository, cfg QueueConfig) *IngestionQueue {
+	return &IngestionQueue{
+		repo:        repo,
+		releaseRepo: releaseRepo,
+		usageRepo:   usageRepo,
+		cfg:         cfg,
+		events:      make(chan Event, cfg.Size),
+	}
+}
+
+// Start the workers that write queued events
+func (q *IngestionQueue) Start() {
+	for i := 0; i < q.cfg.Workers; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+}
+
+// Add an event to the queue without waiting. Returns ErrQueueFull if there is no room for it, or ErrQueueClosed once
+// the queue has started draining
+func (q *IngestionQueue) Enqueue(e Event) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	select {
+	case q.events <- e:
+		q.enqueued.Add(1)
+		return nil
+	default:
+		q.rejected.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// Stop accepting events, and wait for the workers to write the ones already queued. Returns the context's error if it
+// is done first, in which case the remaining events are lost
+func (q *IngestionQueue) Drain(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d queued events not written: %w", len(q.events), ctx.Err())
+	}
+}
+
+// Percentage of the queue's capacity in use, for display
+func (s QueueStats) PercentFull() int {
+	if s.Capacity == 0 {
+		return 0
+	}
+	return int(float64(s.Depth) / float64(s.Capacity) * 100)
+}
+
+func (q *IngestionQueue) Stats() QueueStats {
+	return QueueStats{
+		Depth:    len(q.events),
+		Capacity: cap(q.events),
+		Workers:  q.cfg.Workers,
+		Enqueued: q.enqueued.Load(),
+		Written:  q.written.Load(),
+		Failed:   q.failed.Load(),
+		Rejected: q.rejected.Load(),
+	}
+}
+
+// Collect queued events into batches, writing each batch once it is full or has waited for the flush interval. Exits
+// after writing the last batch once the queue is closed
+func (q *IngestionQueue) work() {
+	defer q.workers.Done()
+
+	batch := make([]Event, 0, q.cfg.BatchSize)
+	ticker := time.NewTicker(q.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-q.events:
+			if !ok {
+				q.flush(batch)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= q.cfg.BatchSize {
+				q.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			q.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// Write a batch of events, then record usage and releases for the ones saved. If the batch is rejected because of
+// one of its events rather than a connection problem, the events are written one at a time so only the bad ones are
+// lost, since a batch holds events from many organizations
+func (q *IngestionQueue) flush(batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+
+	saved := batch
+	if err := q.write(batch); err != nil {
+		if isTransient(err) {
+			q.failed.Add(int64(len(batch)))
+			return
+		}
+
+		saved = make([]Event, 0, len(batch))
+		for _, e := range batch {
+			if err := q.write([]Event{e}); err != nil {
+				log.Printf("[ingestion] Dropped event %q of organization %d: %s", e.Name, e.OrganizationID, err.Error())
+				q.failed.Add(1)
+				continue
+			}
+			saved = append(saved, e)
+		}
+	}
+	q.written.Add(int64(len(saved)))
+
+	perOrg := make(map[int64]int64)
+	for _, e := range saved {
+		perOrg[e.OrganizationID]++
+		if e.ProjectID != nil && e.Release != "" {
+			q.releaseRepo.TryRecord(*e.ProjectID, e.Release, e.Time)
+		}
+	}
+	for orgID, n := range perOrg {
+		q.usageRepo.TryIncrement(orgID, usage.Counts{Events: n})
+	}
+}
+
+// Save events in one transaction, retrying with a backoff while the db is unavailable. Other errors are returned
+// straight away, since retrying the same events won't help
+func (q *IngestionQueue) write(events []Event) error {
+	var err error
+	for attempt := 1; attempt <= batchAttempts; attempt++ {
+		if err = q.repo.CreateBatch(events); err == nil || !isTransient(err) {
+			return err
+		}
+		log.Printf("[ingestion] Failed to write %d events (attempt %d of %d): %s", len(events), attempt, batchAttempts, err.Error())
+		if attempt < batchAttempts {
+			time.Sleep(time.Duration(attempt) * batchRetryBackoff)
+		}
+	}
+	return err
+}
+
+// Check whether a db error is caused by the connection or the server's state rather than the query, so running the
+// same query again may succeed
+func isTransient(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", // connection exception
+			"40", // transaction rollback, eg. deadlock or serialization failure
+			"53", // insufficient resources
+			"57": // operator intervention, eg. the server is shutting down
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
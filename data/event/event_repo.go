@@ -2,11 +2,13 @@ package event
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/slimnate/laser-beam/data"
 )
@@ -43,7 +45,7 @@ func (r *EventRepository) Migrate() error {
 
 	// add search vector column
 	query = `ALTER TABLE events
-		ADD COLUMN search_tsv tsvector
+		ADD COLUMN IF NOT EXISTS search_tsv tsvector
 		GENERATED ALWAYS AS (
 			to_tsvector('english', type || ' ' || name || ' ' || coalesce(application, '') || ' ' || coalesce(message, ''))
 		)
@@ -55,79 +57,184 @@ func (r *EventRepository) Migrate() error {
 	}
 
 	// create search vector index
-	query = "CREATE INDEX search_idx ON events USING GIN (search_tsv)"
+	query = "CREATE INDEX IF NOT EXISTS search_idx ON events USING GIN (search_tsv)"
 	_, err = r.db.Exec(query)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if err := r.migrateProjects(); err != nil {
+		return err
+	}
+
+	// add columns introduced after the table was first created
+	queries := []string{
+		"ALTER TABLE events ADD COLUMN IF NOT EXISTS environment VARCHAR(50) NOT NULL DEFAULT ''",
+		"ALTER TABLE events ADD COLUMN IF NOT EXISTS release VARCHAR(100) NOT NULL DEFAULT ''",
+		"CREATE INDEX IF NOT EXISTS events_project_release_idx ON events(project_id, release)",
+		"ALTER TABLE events ADD COLUMN IF NOT EXISTS redactions JSONB NOT NULL DEFAULT '[]'",
+	}
+	for _, q := range queries {
+		if _, err := r.db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (r *EventRepository) Create(event Event, orgID int64) (*Event, error) {
-	var lastInsertId int64
-	query := "INSERT INTO events(type, name, application, message, time, organization_id) values($1, $2, $3, $4, $5, $6) RETURNING id"
-	err := r.db.QueryRow(query, event.Type, event.Name, event.Application, event.Message, event.Time, orgID).Scan(&lastInsertId)
+// Link events to the application they were sent for. Every application name already used by an organization's
+// events becomes one of its projects, with a newly generated API key
+func (r *EventRepository) migrateProjects() error {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT FROM information_schema.columns
+		WHERE table_name = 'events' AND column_name = 'project_id')`).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
 
+	tx, err := r.db.Begin()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		"ALTER TABLE events ADD COLUMN project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE",
+		"CREATE INDEX events_project_id_idx ON events(project_id)",
+		// names that only differ by punctuation or case share a slug, so only the first of them is created
+		`INSERT INTO projects(organization_id, name, slug, key)
+			SELECT organization_id, application,
+				trim(both '-' from regexp_replace(lower(application), '[^a-z0-9]+', '-', 'g')),
+				replace(gen_random_uuid()::text || gen_random_uuid()::text, '-', '')
+			FROM (SELECT DISTINCT organization_id, application FROM events WHERE coalesce(application, '') != '') a
+			ON CONFLICT DO NOTHING`,
+		`UPDATE events e SET project_id = p.id, application = p.name FROM projects p
+			WHERE p.organization_id = e.organization_id AND coalesce(e.application, '') != ''
+			AND p.slug = trim(both '-' from regexp_replace(lower(e.application), '[^a-z0-9]+', '-', 'g'))`,
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
 	}
 
-	event.ID = lastInsertId
-	event.OrganizationID = orgID
+	return tx.Commit()
+}
 
-	return &event, nil
+// Columns selected for every event query
+const eventColumns = "id, type, name, application, project_id, message, environment, release, time, organization_id, redactions"
+
+type scanner interface {
+	Scan(dest ...any) error
 }
 
-func (r *EventRepository) All(pag *data.PaginationRequestOptions) ([]Event, error) {
-	rows, err := r.db.Query("SELECT id, type, name, application, message, time, organization_id from events WHERE $1 = $2 ORDER BY $3 LIMIT $4 OFFSET $5", pag.Filter.Key, pag.Filter.Value, pag.OrderBy, pag.Limit, pag.Offset)
-	if err != nil {
+func scanEvent(row scanner) (*Event, error) {
+	var e Event
+	var redactions []byte
+	if err := row.Scan(&e.ID, &e.Type, &e.Name, &e.Application, &e.ProjectID, &e.Message, &e.Environment, &e.Release, &e.Time, &e.OrganizationID, &redactions); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(redactions, &e.Redactions); err != nil {
 		return nil, err
 	}
+	return &e, nil
+}
+
+// Encode the redactions of an event for the redactions column
+func marshalRedactions(e Event) ([]byte, error) {
+	if e.Redactions == nil {
+		e.Redactions = []Redaction{}
+	}
+	return json.Marshal(e.Redactions)
+}
+
+// Scan every row of an event query
+func scanEvents(rows *sql.Rows) ([]Event, error) {
 	defer rows.Close()
 
 	var all []Event
 	for rows.Next() {
-		var e Event
-		if err := rows.Scan(&e.ID, &e.Type, &e.Name, &e.Application, &e.Message, &e.Time, &e.OrganizationID); err != nil {
+		e, err := scanEvent(rows)
+		if err != nil {
 			return nil, err
 		}
-		all = append(all, e)
+		all = append(all, *e)
 	}
-	return all, nil
+	return all, rows.Err()
 }
 
-func (r *EventRepository) AllForOrganization(orgID int64, pag *data.PaginationRequestOptions) (*data.PaginationResponseData[[]Event], error) {
-	if pag == nil {
-		log.Printf("EventRepository.AllForOrganization: No pagination options supplied, using defaults")
-		pag = data.DefaultPaginationRequestOptions()
+func (r *EventRepository) Create(event Event, orgID int64) (*Event, error) {
+	redactions, err := marshalRedactions(event)
+	if err != nil {
+		return nil, err
 	}
 
-	var qArgs []any
-	qArgsIndex := 1
+	var lastInsertId int64
+	query := `INSERT INTO events(type, name, application, project_id, message, environment, release, time, organization_id, redactions)
+		values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
+	err = r.db.QueryRow(query, event.Type, event.Name, event.Application, event.ProjectID, event.Message, event.Environment, event.Release, event.Time, orgID, redactions).Scan(&lastInsertId)
 
-	// Add org id where clause to query
-	query := fmt.Sprintf("SELECT id, type, name, application, message, time, organization_id from events WHERE organization_id = $%d", qArgsIndex)
-	qArgs = append(qArgs, orgID)
-	qArgsIndex++
+	if err != nil {
+		return nil, err
+	}
+
+	event.ID = lastInsertId
+	event.OrganizationID = orgID
 
-	// add filter clause if it exists
-	if pag.Filter != nil {
-		query += fmt.Sprintf(" AND %s = $%d", pag.Filter.Key, qArgsIndex)
-		qArgs = append(qArgs, pag.Filter.Value)
-		qArgsIndex++
+	return &event, nil
+}
+
+// Save a batch of events in one transaction, so either all of them are saved or none are. Each event is saved for its
+// own OrganizationID
+func (r *EventRepository) CreateBatch(events []Event) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	// add search clause if it exists
-	if pag.Search != "" {
-		query += fmt.Sprintf(" AND search_tsv @@ to_tsquery($%d)", qArgsIndex)
-		terms := strings.Split(pag.Search, " ")
-		for i, t := range terms {
-			terms[i] = fmt.Sprintf("%s:*", t)
+	stmt, err := tx.Prepare(`INSERT INTO events(type, name, application, project_id, message, environment, release, time, organization_id, redactions)
+		values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range events {
+		redactions, err := marshalRedactions(e)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(e.Type, e.Name, e.Application, e.ProjectID, e.Message, e.Environment, e.Release, e.Time, e.OrganizationID, redactions); err != nil {
+			return err
 		}
-		qArgs = append(qArgs, strings.Join(terms, " & "))
-		qArgsIndex++
 	}
 
-	// add order by clause
-	query += fmt.Sprintf(" ORDER BY %s %s", pag.OrderBy.Column, pag.OrderBy.Direction)
+	return tx.Commit()
+}
+
+func (r *EventRepository) All(pag *data.PaginationRequestOptions) ([]Event, error) {
+	rows, err := r.db.Query("SELECT "+eventColumns+" from events WHERE $1 = $2 ORDER BY $3 LIMIT $4 OFFSET $5", pag.Filter.Key, pag.Filter.Value, pag.OrderBy, pag.Limit, pag.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+func (r *EventRepository) AllForOrganization(orgID int64, pag *data.PaginationRequestOptions) (*data.PaginationResponseData[[]Event], error) {
+	if pag == nil {
+		log.Printf("EventRepository.AllForOrganization: No pagination options supplied, using defaults")
+		pag = data.DefaultPaginationRequestOptions()
+	}
+
+	// Add org id, filter and search where clauses to query
+	where, qArgs := whereClauseForOrganization(orgID, pag)
+	qArgsIndex := len(qArgs) + 1
+	query := "SELECT " + eventColumns + " from events WHERE " + where
+
+	// add order by clause, using the id as a tie breaker so the order is stable between requests
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s", pag.OrderBy.Column, pag.OrderBy.Direction, pag.OrderBy.Direction)
 
 	// add limit
 	query += fmt.Sprintf(" LIMIT $%d", qArgsIndex)
@@ -143,100 +250,189 @@ func (r *EventRepository) AllForOrganization(orgID int64, pag *data.PaginationRe
 	log.Println(query)
 
 	rows, err := r.db.Query(query, qArgs...)
-
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var results []Event
-	for rows.Next() {
-		var e Event
-		if err := rows.Scan(&e.ID, &e.Type, &e.Name, &e.Application, &e.Message, &e.Time, &e.OrganizationID); err != nil {
-			return nil, err
-		}
-		results = append(results, e)
-	}
-
-	total, err := r.Count(pag.Search, &data.FilterOption{
-		Key:   "organization_id",
-		Value: fmt.Sprint(orgID),
-	}, pag.Filter)
 
+	results, err := scanEvents(rows)
 	if err != nil {
 		return nil, err
 	}
 
-	typeValues, err := r.GetDistinctValues("type")
-	if err != nil {
+	// count with the same where clause, without the limit and offset args
+	where, countArgs := whereClauseForOrganization(orgID, pag)
+	var total int64
+	if err := r.db.QueryRow("SELECT COUNT(id) FROM events WHERE "+where, countArgs...).Scan(&total); err != nil {
 		return nil, err
 	}
-	typeOptionsList := data.FilterOptionsList{
-		PropertyName: "type",
-		Values:       typeValues,
-	}
-	if pag.Filter != nil && pag.Filter.Key == "type" {
-		typeOptionsList.SelectedValue = pag.Filter.Value
+
+	var filterOptions []data.FilterOptionsList
+	for _, col := range FilterColumns {
+		values, err := r.GetDistinctValues(orgID, col)
+		if err != nil {
+			return nil, err
+		}
+		options := data.FilterOptionsList{
+			PropertyName: col,
+			Values:       values,
+		}
+		if pag.Filter != nil && pag.Filter.Key == col {
+			options.SelectedValue = pag.Filter.Value
+		}
+		filterOptions = append(filterOptions, options)
 	}
 
-	appValues, err := r.GetDistinctValues("application")
+	environments, err := r.GetDistinctValues(orgID, "environment")
 	if err != nil {
 		return nil, err
 	}
-	appOptionsList := data.FilterOptionsList{
-		PropertyName: "application",
-		Values:       appValues,
-	}
-	if pag.Filter != nil && pag.Filter.Key == "application" {
-		appOptionsList.SelectedValue = pag.Filter.Value
-	}
 
 	pagRes := &data.PaginationResponseData[[]Event]{
 		Data:          results,
 		Request:       pag,
 		PreviousPage:  pag.Previous(total),
 		NextPage:      pag.Next(total),
-		FilterOptions: []data.FilterOptionsList{typeOptionsList, appOptionsList},
-		Total:         total,
-		Start:         pag.Offset + 1,
-		End:           min(pag.Offset+pag.Limit, total),
+		FilterOptions: filterOptions,
+		EnvironmentOptions: &data.FilterOptionsList{
+			PropertyName:  "environment",
+			Values:        environments,
+			SelectedValue: pag.Environment,
+		},
+		Total: total,
+		Start: pag.Offset + 1,
+		End:   min(pag.Offset+pag.Limit, total),
 	}
 
 	return pagRes, nil
 }
 
-func (r *EventRepository) GetByID(id int64) (*Event, error) {
-	row := r.db.QueryRow("SELECT id, type, name, application, message, time, organization_id FROM events WHERE id = $1", id)
+// Build the where clause (without the WHERE keyword) and matching query args for selecting the events of an
+// organization, restricted by the filter and search of the supplied pagination options
+func whereClauseForOrganization(orgID int64, pag *data.PaginationRequestOptions) (string, []any) {
+	var args []any
+	clauses := []string{"organization_id = $1"}
+	args = append(args, orgID)
 
-	var e Event
-	if err := row.Scan(&e.ID, &e.Type, &e.Name, &e.Application, &e.Message, &e.Time, &e.OrganizationID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, data.ErrNotExists
-		}
-		return nil, err
+	// add filter clause if it exists. Applications are matched through their project, so events keep matching the
+	// filter by either the name or slug of the application
+	if pag.Filter != nil && pag.Filter.Key == "application" {
+		clauses = append(clauses, fmt.Sprintf("project_id IN (SELECT id FROM projects WHERE organization_id = $1 AND (name = $%d OR slug = $%d))", len(args)+1, len(args)+1))
+		args = append(args, pag.Filter.Value)
+	} else if pag.Filter != nil {
+		clauses = append(clauses, fmt.Sprintf("%s = $%d", pag.Filter.Key, len(args)+1))
+		args = append(args, pag.Filter.Value)
 	}
-	return &e, nil
+
+	// add environment clause if it exists
+	if pag.Environment != "" {
+		clauses = append(clauses, fmt.Sprintf("environment = $%d", len(args)+1))
+		args = append(args, pag.Environment)
+	}
+
+	// add search clause if it exists
+	if pag.Search != "" {
+		clauses = append(clauses, fmt.Sprintf("search_tsv @@ to_tsquery($%d)", len(args)+1))
+		args = append(args, searchQuery(pag.Search))
+	}
+
+	return strings.Join(clauses, " AND "), args
+}
+
+// Convert a space separated search string into a prefix matching tsquery, eg. "db fail" -> "db:* & fail:*"
+func searchQuery(search string) string {
+	terms := strings.Split(search, " ")
+	for i, t := range terms {
+		terms[i] = fmt.Sprintf("%s:*", t)
+	}
+	return strings.Join(terms, " & ")
+}
+
+func (r *EventRepository) GetByID(id int64) (*Event, error) {
+	e, err := scanEvent(r.db.QueryRow("SELECT "+eventColumns+" FROM events WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, data.ErrNotExists
+	}
+	return e, err
 }
 
 func (r *EventRepository) GetByIDAndOrg(id int64, orgID int64) (*Event, error) {
-	row := r.db.QueryRow("SELECT id, type, name, application, message, time, organization_id FROM events WHERE id = $1 AND organization_id = $2", id, orgID)
+	e, err := scanEvent(r.db.QueryRow("SELECT "+eventColumns+" FROM events WHERE id = $1 AND organization_id = $2", id, orgID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, data.ErrNotExists
+	}
+	return e, err
+}
 
-	var e Event
-	if err := row.Scan(&e.ID, &e.Type, &e.Name, &e.Application, &e.Message, &e.Time, &e.OrganizationID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, data.ErrNotExists
-		}
+// Get up to `limit` events from the same organization and application as the supplied event, that occurred within
+// `window` before or after it. The supplied event is excluded from the results
+func (r *EventRepository) Related(e *Event, window time.Duration, limit int64) ([]Event, error) {
+	query := `SELECT ` + eventColumns + ` FROM events
+		WHERE organization_id = $1 AND project_id IS NOT DISTINCT FROM $2 AND id != $3 AND time BETWEEN $4 AND $5
+		ORDER BY time ASC, id ASC LIMIT $6`
+
+	rows, err := r.db.Query(query, e.OrganizationID, e.ProjectID, e.ID, e.Time.Add(-window), e.Time.Add(window), limit)
+	if err != nil {
 		return nil, err
 	}
-	return &e, nil
+	return scanEvents(rows)
+}
+
+// Get the events immediately before and after the supplied event in the list described by the pagination options
+// (respecting the filter, search and order). Either value will be nil if there is no such event.
+func (r *EventRepository) Neighbors(e *Event, pag *data.PaginationRequestOptions) (previous *Event, next *Event, err error) {
+	if pag == nil {
+		pag = data.DefaultPaginationRequestOptions()
+	}
+
+	ascending := strings.ToLower(pag.OrderBy.Direction) != "desc"
+
+	// the next event in an ascending list has a greater (column, id) pair, and a smaller one in a descending list
+	previous, err = r.neighbor(e, pag, !ascending)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	next, err = r.neighbor(e, pag, ascending)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return previous, next, nil
+}
+
+// Get the closest event to `e` in the order column of the pagination options, searching upwards if `greater` is true
+func (r *EventRepository) neighbor(e *Event, pag *data.PaginationRequestOptions, greater bool) (*Event, error) {
+	where, args := whereClauseForOrganization(e.OrganizationID, pag)
+
+	comparison, direction := "<", "DESC"
+	if greater {
+		comparison, direction = ">", "ASC"
+	}
+
+	col := pag.OrderBy.Column
+	query := fmt.Sprintf(`SELECT %s FROM events
+		WHERE %s AND (%s, id) %s (SELECT %s, id FROM events WHERE id = $%d)
+		ORDER BY %s %s, id %s LIMIT 1`, eventColumns, where, col, comparison, col, len(args)+1, col, direction, direction)
+	args = append(args, e.ID)
+
+	n, err := scanEvent(r.db.QueryRow(query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return n, err
 }
 
 func (r *EventRepository) Update(id int64, newEvent Event) (*Event, error) {
 	if id == 0 {
 		return nil, errors.New("invalid ID to update")
 	}
-	query := "UPDATE events SET name = $1, type = $2, message = $3, application = $4 WHERE id = $5"
-	res, err := r.db.Exec(query, newEvent.Name, newEvent.Type, newEvent.Message, newEvent.Application, id)
+	redactions, err := marshalRedactions(newEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "UPDATE events SET name = $1, type = $2, message = $3, application = $4, project_id = $5, environment = $6, release = $7, redactions = $8 WHERE id = $9"
+	res, err := r.db.Exec(query, newEvent.Name, newEvent.Type, newEvent.Message, newEvent.Application, newEvent.ProjectID, newEvent.Environment, newEvent.Release, redactions, id)
 
 	if err != nil {
 		return nil, err
@@ -277,6 +473,44 @@ func (r *EventRepository) Delete(id int64) error {
 	return err
 }
 
+// Delete the events of every organization that are older than their retention period, which is the retention period of
+// their application if it has one, or otherwise of their organization. A retention period of 0 keeps events forever.
+// Returns the number of events deleted
+func (r *EventRepository) DeleteExpired() (int64, error) {
+	query := `DELETE FROM events WHERE id IN (
+		SELECT e.id FROM events e
+			JOIN organizations o ON o.id = e.organization_id
+			LEFT JOIN projects p ON p.id = e.project_id
+			CROSS JOIN LATERAL (SELECT coalesce(nullif(p.retention_days, 0), o.retention_days) AS days) retention
+		WHERE retention.days > 0 AND e.time < NOW() - retention.days * INTERVAL '1 day'
+	)`
+	res, err := r.db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Start a background goroutine that deletes events past their organization's retention period every `interval`
+func (r *EventRepository) StartRetentionCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			deleted, err := r.DeleteExpired()
+			if err != nil {
+				log.Println("[events] Retention cleanup error: " + err.Error())
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("[events] Cleaned up %d events past their retention period", deleted)
+			}
+		}
+	}()
+}
+
 func (r *EventRepository) Count(search string, filters ...*data.FilterOption) (int64, error) {
 	var output string
 	var whereClauses []string
@@ -325,15 +559,21 @@ func (r *EventRepository) Count(search string, filters ...*data.FilterOption) (i
 	return count, nil
 }
 
-func (r *EventRepository) GetDistinctValues(columnName string) ([]string, error) {
+// Get the values of a column used by the events of an organization, for filtering the event list. Application values
+// are the names of the organization's projects, rather than whatever was sent with each event
+func (r *EventRepository) GetDistinctValues(orgID int64, columnName string) ([]string, error) {
 	var values []string
 
-	q := fmt.Sprintf("SELECT DISTINCT %s FROM events", columnName)
+	q := fmt.Sprintf("SELECT DISTINCT %s FROM events WHERE organization_id = $1 AND %s != '' ORDER BY %s", columnName, columnName, columnName)
+	if columnName == "application" {
+		q = "SELECT name FROM projects WHERE organization_id = $1 ORDER BY name"
+	}
 
-	rows, err := r.db.Query(q)
+	rows, err := r.db.Query(q, orgID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var v string
@@ -344,5 +584,5 @@ func (r *EventRepository) GetDistinctValues(columnName string) ([]string, error)
 		values = append(values, v)
 	}
 
-	return values, nil
+	return values, rows.Err()
 }
@@ -0,0 +1,45 @@
+package identityprovider
+
+import "time"
+
+// How long a user has to complete a login at the identity provider after starting it
+const LoginTTL = 10 * time.Minute
+
+// OpenID Connect identity provider configured by an organization for single sign-on. Users log in through the provider
+// by entering an email address at `Domain`
+type IdentityProvider struct {
+	ID             int64
+	OrganizationID int64
+	Name           string
+	Issuer         string
+	ClientID       string
+	// stored in plain text, since it has to be sent to the provider
+	ClientSecret string
+	Domain       string
+	// create accounts for users that log in through the provider without one, with the default role
+	AutoProvision bool
+	DefaultRoleID int64
+	Enabled       bool
+	// admin who last saved the settings. Existing accounts are only linked to the provider by email address if this
+	// admin could assign their role, nil if the admin has been deleted
+	ConfiguredBy *int64
+}
+
+// Link between a user and their account at an identity provider, identified by the provider's subject claim
+type Identity struct {
+	ID         int64
+	ProviderID int64
+	Subject    string
+	UserID     int64
+}
+
+// A login that has been sent to the identity provider and not yet returned. The login's state token, sent back by the
+// provider, is stored hashed
+type Login struct {
+	ID         int64
+	ProviderID int64
+	Nonce      string
+	// PKCE code verifier, sent with the code exchange
+	Verifier  string
+	ExpiresAt time.Time
+}
@@ -0,0 +1,214 @@
+package identityprovider
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/slimnate/laser-beam/data"
+)
+
+type IdentityProviderRepository struct {
+	db *sql.DB
+}
+
+func NewIdentityProviderRepository(db *sql.DB) *IdentityProviderRepository {
+	return &IdentityProviderRepository{
+		db: db,
+	}
+}
+
+func (r *IdentityProviderRepository) Migrate() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS identity_providers(
+			id SERIAL PRIMARY KEY,
+			organization_id INTEGER NOT NULL UNIQUE,
+			name VARCHAR(64) NOT NULL,
+			issuer VARCHAR(255) NOT NULL,
+			client_id VARCHAR(255) NOT NULL,
+			client_secret VARCHAR(255) NOT NULL,
+			domain VARCHAR(255) NOT NULL UNIQUE,
+			auto_provision BOOLEAN NOT NULL DEFAULT false,
+			default_role_id INTEGER NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT true,
+			FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
+			FOREIGN KEY(default_role_id) REFERENCES roles(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_identities(
+			id SERIAL PRIMARY KEY,
+			provider_id INTEGER NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			user_id INTEGER NOT NULL,
+			UNIQUE(provider_id, subject),
+			FOREIGN KEY(provider_id) REFERENCES identity_providers(id) ON DELETE CASCADE,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS sso_logins(
+			id SERIAL PRIMARY KEY,
+			state_hash CHAR(64) NOT NULL UNIQUE,
+			provider_id INTEGER NOT NULL,
+			nonce VARCHAR(64) NOT NULL,
+			verifier VARCHAR(128) NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(provider_id) REFERENCES identity_providers(id) ON DELETE CASCADE
+		)`,
+		"ALTER TABLE identity_providers ADD COLUMN IF NOT EXISTS configured_by INTEGER REFERENCES users(id) ON DELETE SET NULL",
+	}
+
+	for _, q := range queries {
+		if _, err := r.db.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const providerColumns = "id, organization_id, name, issuer, client_id, client_secret, domain, auto_provision, default_role_id, enabled, configured_by"
+
+func scanProvider(row *sql.Row) (*IdentityProvider, error) {
+	var p IdentityProvider
+	err := row.Scan(&p.ID, &p.OrganizationID, &p.Name, &p.Issuer, &p.ClientID, &p.ClientSecret, &p.Domain, &p.AutoProvision, &p.DefaultRoleID, &p.Enabled, &p.ConfiguredBy)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrNotExists
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *IdentityProviderRepository) GetByID(id int64) (*IdentityProvider, error) {
+	return scanProvider(r.db.QueryRow("SELECT "+providerColumns+" FROM identity_providers WHERE id = $1", id))
+}
+
+// Get the identity provider configured by an organization. Returns data.ErrNotExists if it hasn't configured one
+func (r *IdentityProviderRepository) GetForOrganization(orgID int64) (*IdentityProvider, error) {
+	return scanProvider(r.db.QueryRow("SELECT "+providerColumns+" FROM identity_providers WHERE organization_id = $1", orgID))
+}
+
+// Get the enabled identity provider for an email domain. Returns data.ErrNotExists if there is none
+func (r *IdentityProviderRepository) GetEnabledByDomain(domain string) (*IdentityProvider, error) {
+	return scanProvider(r.db.QueryRow("SELECT "+providerColumns+" FROM identity_providers WHERE domain = $1 AND enabled", strings.ToLower(domain)))
+}
+
+// Create or replace the identity provider for an organization. Returns data.ErrDuplicate if another organization
+// already uses the domain. Changing the issuer or client ID removes the links of users to the provider, since the
+// subjects they were linked by belong to the old provider and could be reused by the new one
+func (r *IdentityProviderRepository) Save(p IdentityProvider) (*IdentityProvider, error) {
+	p.Domain = strings.ToLower(p.Domain)
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var existingID int64
+	var existingIssuer, existingClientID string
+	err = tx.QueryRow("SELECT id, issuer, client_id FROM identity_providers WHERE organization_id = $1 FOR UPDATE", p.OrganizationID).
+		Scan(&existingID, &existingIssuer, &existingClientID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err == nil && (existingIssuer != p.Issuer || existingClientID != p.ClientID) {
+		if _, err := tx.Exec("DELETE FROM user_identities WHERE provider_id = $1", existingID); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `INSERT INTO identity_providers(organization_id, name, issuer, client_id, client_secret, domain, auto_provision, default_role_id, enabled, configured_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (organization_id) DO UPDATE SET name = EXCLUDED.name, issuer = EXCLUDED.issuer,
+			client_id = EXCLUDED.client_id, client_secret = EXCLUDED.client_secret, domain = EXCLUDED.domain,
+			auto_provision = EXCLUDED.auto_provision, default_role_id = EXCLUDED.default_role_id, enabled = EXCLUDED.enabled,
+			configured_by = EXCLUDED.configured_by
+		RETURNING id`
+
+	err = tx.QueryRow(query, p.OrganizationID, p.Name, p.Issuer, p.ClientID, p.ClientSecret, p.Domain, p.AutoProvision, p.DefaultRoleID, p.Enabled, p.ConfiguredBy).Scan(&p.ID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+			return nil, data.ErrDuplicate
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// Get the ID of the user linked to a subject at an identity provider. Returns data.ErrNotExists if there is none
+func (r *IdentityProviderRepository) GetLinkedUserID(providerID int64, subject string) (int64, error) {
+	var userID int64
+	err := r.db.QueryRow("SELECT user_id FROM user_identities WHERE provider_id = $1 AND subject = $2", providerID, subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, data.ErrNotExists
+	}
+	return userID, err
+}
+
+// Link a user to a subject at an identity provider, so future logins as the subject log in as the user
+func (r *IdentityProviderRepository) Link(providerID int64, subject string, userID int64) (*Identity, error) {
+	identity := Identity{
+		ProviderID: providerID,
+		Subject:    subject,
+		UserID:     userID,
+	}
+
+	query := "INSERT INTO user_identities(provider_id, subject, user_id) VALUES ($1, $2, $3) RETURNING id"
+	if err := r.db.QueryRow(query, providerID, subject, userID).Scan(&identity.ID); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+			return nil, data.ErrDuplicate
+		}
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// Store a login that is being sent to an identity provider, identified by the hash of its state token
+func (r *IdentityProviderRepository) CreateLogin(providerID int64, stateHash string, nonce string, verifier string) (*Login, error) {
+	l := Login{
+		ProviderID: providerID,
+		Nonce:      nonce,
+		Verifier:   verifier,
+		ExpiresAt:  time.Now().Add(LoginTTL),
+	}
+
+	// abandoned logins are never completed, so clear them out as new ones are created
+	if _, err := r.db.Exec("DELETE FROM sso_logins WHERE expires_at < $1", time.Now()); err != nil {
+		return nil, err
+	}
+
+	query := "INSERT INTO sso_logins(state_hash, provider_id, nonce, verifier, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	if err := r.db.QueryRow(query, stateHash, l.ProviderID, l.Nonce, l.Verifier, l.ExpiresAt).Scan(&l.ID); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// Get and delete an unexpired login by the hash of its state token. Logins are single use, so this returns
+// data.ErrNotExists if the login was already claimed, even by a concurrent request
+func (r *IdentityProviderRepository) ClaimLogin(stateHash string) (*Login, error) {
+	query := "DELETE FROM sso_logins WHERE state_hash = $1 RETURNING id, provider_id, nonce, verifier, expires_at"
+
+	var l Login
+	if err := r.db.QueryRow(query, stateHash).Scan(&l.ID, &l.ProviderID, &l.Nonce, &l.Verifier, &l.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrNotExists
+		}
+		return nil, err
+	}
+
+	if time.Now().After(l.ExpiresAt) {
+		return nil, data.ErrNotExists
+	}
+	return &l, nil
+}
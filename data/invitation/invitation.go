@@ -0,0 +1,21 @@
+package invitation
+
+import "time"
+
+// How long an invitation link can be used for after it is sent
+const TTL = 72 * time.Hour
+
+type Invitation struct {
+	ID             int64
+	Email          string
+	RoleID         int64
+	RoleName       string
+	OrganizationID int64
+	InvitedByID    int64
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+}
+
+func (i *Invitation) FormattedExpiry() string {
+	return i.ExpiresAt.Format("2006/01/02 15:04:05")
+}
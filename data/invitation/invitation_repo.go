@@ -0,0 +1,154 @@
+package invitation
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/slimnate/laser-beam/data"
+)
+
+type InvitationRepository struct {
+	db *sql.DB
+}
+
+func NewInvitationRepository(db *sql.DB) *InvitationRepository {
+	return &InvitationRepository{
+		db: db,
+	}
+}
+
+func (r *InvitationRepository) Migrate() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS invitations(
+		id SERIAL PRIMARY KEY,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		email VARCHAR(128) NOT NULL,
+		role_id INTEGER NOT NULL,
+		organization_id INTEGER NOT NULL,
+		invited_by_id INTEGER,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		accepted_at TIMESTAMP,
+		FOREIGN KEY(role_id) REFERENCES roles(id) ON DELETE CASCADE,
+		FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
+		FOREIGN KEY(invited_by_id) REFERENCES users(id) ON DELETE SET NULL
+	)
+	`
+
+	_, err := r.db.Exec(query)
+	return err
+}
+
+// Store a new invitation, identified by the hash of the token that was sent to the invitee. Any pending invitations
+// to the same email address in the organization are replaced.
+func (r *InvitationRepository) Create(inv Invitation, tokenHash string) (*Invitation, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM invitations WHERE organization_id = $1 AND lower(email) = lower($2) AND accepted_at IS NULL", inv.OrganizationID, inv.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	inv.CreatedAt = time.Now()
+	inv.ExpiresAt = inv.CreatedAt.Add(TTL)
+
+	var lastInsertId int64
+	query := "INSERT INTO invitations(token_hash, email, role_id, organization_id, invited_by_id, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id"
+	err = tx.QueryRow(query, tokenHash, inv.Email, inv.RoleID, inv.OrganizationID, inv.InvitedByID, inv.CreatedAt, inv.ExpiresAt).Scan(&lastInsertId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	inv.ID = lastInsertId
+
+	return &inv, nil
+}
+
+// Get all invitations for an organization that have not been accepted and have not expired
+func (r *InvitationRepository) AllPendingForOrganization(orgID int64) ([]Invitation, error) {
+	query := `SELECT i.id, i.email, i.role_id, r.name, i.organization_id, coalesce(i.invited_by_id, 0), i.created_at, i.expires_at
+		FROM invitations i JOIN roles r ON r.id = i.role_id
+		WHERE i.organization_id = $1 AND i.accepted_at IS NULL AND i.expires_at > $2 ORDER BY i.created_at`
+	rows, err := r.db.Query(query, orgID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []Invitation
+	for rows.Next() {
+		var i Invitation
+		if err := rows.Scan(&i.ID, &i.Email, &i.RoleID, &i.RoleName, &i.OrganizationID, &i.InvitedByID, &i.CreatedAt, &i.ExpiresAt); err != nil {
+			return nil, err
+		}
+		all = append(all, i)
+	}
+	return all, nil
+}
+
+// Get a pending invitation by the hash of its token. Returns data.ErrNotExists if the invitation doesn't exist, has
+// already been accepted, or has expired
+func (r *InvitationRepository) GetPendingByTokenHash(tokenHash string) (*Invitation, error) {
+	query := `SELECT i.id, i.email, i.role_id, r.name, i.organization_id, coalesce(i.invited_by_id, 0), i.created_at, i.expires_at
+		FROM invitations i JOIN roles r ON r.id = i.role_id
+		WHERE i.token_hash = $1 AND i.accepted_at IS NULL AND i.expires_at > $2`
+	row := r.db.QueryRow(query, tokenHash, time.Now())
+
+	var i Invitation
+	if err := row.Scan(&i.ID, &i.Email, &i.RoleID, &i.RoleName, &i.OrganizationID, &i.InvitedByID, &i.CreatedAt, &i.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrNotExists
+		}
+		return nil, err
+	}
+	return &i, nil
+}
+
+// Mark an invitation as accepted so it can't be used again. Returns data.ErrUpdateFailed if it was already accepted
+// or has expired, so concurrent requests can't both use the same invitation
+func (r *InvitationRepository) MarkAccepted(id int64) error {
+	t := time.Now()
+	res, err := r.db.Exec("UPDATE invitations SET accepted_at = $1 WHERE id = $2 AND accepted_at IS NULL AND expires_at > $1", t, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrUpdateFailed
+	}
+
+	return nil
+}
+
+// Revoke an invitation belonging to an organization
+func (r *InvitationRepository) Delete(id int64, orgID int64) error {
+	res, err := r.db.Exec("DELETE FROM invitations WHERE id = $1 AND organization_id = $2", id, orgID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrDeleteFailed
+	}
+
+	return err
+}
@@ -0,0 +1,80 @@
+package loginattempt
+
+import "time"
+
+// How long login attempts are kept for, both for throttling and as a record of failed logins
+const Retention = 30 * 24 * time.Hour
+
+type LoginAttempt struct {
+	ID       int64
+	Username string
+	IP       string
+	Success  bool
+	Time     time.Time
+}
+
+// Throttling rules applied to failed logins for a single username or IP address
+type Policy struct {
+	// Only failures within this window are counted
+	Window time.Duration
+	// Number of failures allowed before delays start
+	FreeAttempts int
+	// Delay after the first failure past FreeAttempts, doubling with each further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Number of failures after which logins are locked for LockoutDuration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+// Failures for a single username. Delays apply to the account regardless of where attempts come from, and are reset by
+// a successful login
+var UsernamePolicy = Policy{
+	Window:           time.Hour,
+	FreeAttempts:     3,
+	BaseDelay:        2 * time.Second,
+	MaxDelay:         time.Minute,
+	LockoutThreshold: 10,
+	LockoutDuration:  15 * time.Minute,
+}
+
+// Failures from a single IP address across all usernames. Limits are higher since many users can share an IP
+var IPPolicy = Policy{
+	Window:           time.Hour,
+	FreeAttempts:     10,
+	BaseDelay:        time.Second,
+	MaxDelay:         30 * time.Second,
+	LockoutThreshold: 50,
+	LockoutDuration:  time.Hour,
+}
+
+// Recent failed logins for a username or IP address
+type Failures struct {
+	Count int
+	Last  time.Time
+}
+
+// How long after the last failure the next attempt has to wait
+func (p Policy) Wait(failures int) time.Duration {
+	if failures >= p.LockoutThreshold {
+		return p.LockoutDuration
+	}
+	if failures < p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// Time remaining before another attempt is allowed, or zero if an attempt can be made now
+func (p Policy) Remaining(f Failures, now time.Time) time.Duration {
+	wait := p.Wait(f.Count)
+	if wait == 0 {
+		return 0
+	}
+	return max(f.Last.Add(wait).Sub(now), 0)
+}
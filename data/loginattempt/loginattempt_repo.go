@@ -0,0 +1,97 @@
+package loginattempt
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+type LoginAttemptRepository struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptRepository(db *sql.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{
+		db: db,
+	}
+}
+
+func (r *LoginAttemptRepository) Migrate() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS login_attempts(
+			id SERIAL PRIMARY KEY,
+			username VARCHAR(100) NOT NULL,
+			ip_address VARCHAR(45) NOT NULL,
+			success BOOLEAN NOT NULL,
+			time TIMESTAMP NOT NULL
+		)`,
+		"CREATE INDEX IF NOT EXISTS login_attempts_username_idx ON login_attempts(username, time)",
+		"CREATE INDEX IF NOT EXISTS login_attempts_ip_address_idx ON login_attempts(ip_address, time)",
+	}
+
+	for _, q := range queries {
+		if _, err := r.db.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record a login attempt. Usernames are client supplied, so they are truncated to fit the column
+func (r *LoginAttemptRepository) Record(username string, ip string, success bool) error {
+	if len(username) > 100 {
+		username = username[:100]
+	}
+
+	_, err := r.db.Exec("INSERT INTO login_attempts(username, ip_address, success, time) VALUES ($1, $2, $3, $4)", username, ip, success, time.Now())
+	return err
+}
+
+// Count failed logins for a username within the policy window, since the last successful login
+func (r *LoginAttemptRepository) FailuresForUsername(username string, p Policy) (Failures, error) {
+	query := `SELECT COUNT(*), COALESCE(MAX(time), 'epoch') FROM login_attempts
+		WHERE username = $1 AND success = false AND time > $2
+		AND time > COALESCE((SELECT MAX(time) FROM login_attempts WHERE username = $1 AND success), '-infinity')`
+
+	var f Failures
+	err := r.db.QueryRow(query, username, time.Now().Add(-p.Window)).Scan(&f.Count, &f.Last)
+	return f, err
+}
+
+// Count failed logins from an IP address within the policy window
+func (r *LoginAttemptRepository) FailuresForIP(ip string, p Policy) (Failures, error) {
+	query := "SELECT COUNT(*), COALESCE(MAX(time), 'epoch') FROM login_attempts WHERE ip_address = $1 AND success = false AND time > $2"
+
+	var f Failures
+	err := r.db.QueryRow(query, ip, time.Now().Add(-p.Window)).Scan(&f.Count, &f.Last)
+	return f, err
+}
+
+// Delete attempts older than Retention. Returns the number of attempts deleted
+func (r *LoginAttemptRepository) DeleteExpired() (int64, error) {
+	res, err := r.db.Exec("DELETE FROM login_attempts WHERE time < $1", time.Now().Add(-Retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Start a background goroutine that deletes expired attempts every `interval`
+func (r *LoginAttemptRepository) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			deleted, err := r.DeleteExpired()
+			if err != nil {
+				log.Println("[login_attempts] Cleanup error: " + err.Error())
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("[login_attempts] Cleaned up %d expired login attempts", deleted)
+			}
+		}
+	}()
+}
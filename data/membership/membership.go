@@ -0,0 +1,17 @@
+package membership
+
+import (
+	"time"
+
+	"github.com/slimnate/laser-beam/data/role"
+)
+
+// Access of a user to an organization, with the role they hold in it. Every user is a member of the organization
+// their account was created in, and can be invited to others
+type Membership struct {
+	UserID           int64
+	OrganizationID   int64
+	OrganizationName string
+	Role             *role.Role
+	CreatedAt        time.Time
+}
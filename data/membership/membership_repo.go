@@ -0,0 +1,142 @@
+package membership
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/role"
+)
+
+type MembershipRepository struct {
+	db *sql.DB
+}
+
+func NewMembershipRepository(db *sql.DB) *MembershipRepository {
+	return &MembershipRepository{
+		db: db,
+	}
+}
+
+func (r *MembershipRepository) Migrate() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS memberships(
+			user_id INTEGER NOT NULL,
+			organization_id INTEGER NOT NULL,
+			role_id INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT now(),
+			PRIMARY KEY(user_id, organization_id),
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
+			FOREIGN KEY(role_id) REFERENCES roles(id)
+		)`,
+		"CREATE INDEX IF NOT EXISTS memberships_organization_id_idx ON memberships(organization_id)",
+		// users created before memberships were introduced are members of their own organization
+		`INSERT INTO memberships(user_id, organization_id, role_id)
+			SELECT id, organization_id, role_id FROM users
+			ON CONFLICT DO NOTHING`,
+	}
+	for _, q := range queries {
+		if _, err := r.db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Columns selected for every membership query, joined with the organization and role
+const membershipColumns = "m.user_id, m.organization_id, o.name, m.created_at, r.id, r.name, r.permissions, r.organization_id"
+const membershipTables = "memberships m JOIN organizations o ON o.id = m.organization_id JOIN roles r ON r.id = m.role_id"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMembership(row scanner) (*Membership, error) {
+	var m Membership
+	var permissions []string
+	var roleOrgID sql.NullInt64
+	m.Role = &role.Role{}
+
+	err := row.Scan(&m.UserID, &m.OrganizationID, &m.OrganizationName, &m.CreatedAt, &m.Role.ID, &m.Role.Name, pq.Array(&permissions), &roleOrgID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrNotExists
+		}
+		return nil, err
+	}
+
+	for _, p := range permissions {
+		m.Role.Permissions = append(m.Role.Permissions, auth.Permission(p))
+	}
+	if roleOrgID.Valid {
+		m.Role.OrganizationID = &roleOrgID.Int64
+	}
+
+	return &m, nil
+}
+
+// Add a user to an organization with the supplied role. Returns data.ErrDuplicate if they are already a member
+func (r *MembershipRepository) Add(userID int64, orgID int64, roleID int64) (*Membership, error) {
+	query := "INSERT INTO memberships(user_id, organization_id, role_id, created_at) VALUES ($1, $2, $3, $4)"
+	if _, err := r.db.Exec(query, userID, orgID, roleID, time.Now()); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+			return nil, data.ErrDuplicate
+		}
+		return nil, err
+	}
+
+	return r.Get(userID, orgID)
+}
+
+// Get the membership of a user in an organization
+func (r *MembershipRepository) Get(userID int64, orgID int64) (*Membership, error) {
+	row := r.db.QueryRow("SELECT "+membershipColumns+" FROM "+membershipTables+" WHERE m.user_id = $1 AND m.organization_id = $2", userID, orgID)
+	return scanMembership(row)
+}
+
+// Get every organization a user is a member of, ordered by organization name
+func (r *MembershipRepository) AllForUser(userID int64) ([]Membership, error) {
+	rows, err := r.db.Query("SELECT "+membershipColumns+" FROM "+membershipTables+" WHERE m.user_id = $1 ORDER BY o.name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []Membership
+	for rows.Next() {
+		m, err := scanMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, *m)
+	}
+	return all, nil
+}
+
+// Remove a user from an organization. Users can't be removed from the organization their account belongs to, they
+// have to be deleted instead
+func (r *MembershipRepository) Remove(userID int64, orgID int64) error {
+	query := `DELETE FROM memberships m USING users u
+		WHERE u.id = m.user_id AND m.user_id = $1 AND m.organization_id = $2 AND u.organization_id <> m.organization_id`
+	res, err := r.db.Exec(query, userID, orgID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrDeleteFailed
+	}
+
+	return nil
+}
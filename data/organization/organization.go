@@ -1,11 +1,92 @@
 package organization
 
+import (
+	"time"
+
+	"github.com/slimnate/laser-beam/data"
+)
+
+// Settings new organizations start with
+const (
+	DefaultTimezone = "UTC"
+	DefaultPageSize = 10
+)
+
 type Organization struct {
 	ID   int64
 	Name string
+	// Platform organizations operate the service. Their API keys are authorized for every organization, and their
+	// users with the global.manage permission can use the platform admin console
+	Platform bool
+	// Users must enroll in two-factor authentication before they can use the site
+	RequireTwoFactor bool
+	// IANA time zone that event times are displayed in on the site
+	Timezone string
+	// Events older than this many days are deleted, 0 keeps events forever
+	RetentionDays int
+	// Filter applied to the event list when none is chosen, in the `key:value` format of the filter query param
+	DefaultFilter string
+	// Number of events shown on each page of the event list
+	DefaultPageSize int64
+	// Events per second each API key of the organization can send, 0 uses the server's rate limit
+	RateLimit int
+	// Events the organization can send each calendar month, 0 uses the server's quota
+	MonthlyQuota int64
 }
 
 type OrganizationSecret struct {
 	Organization
 	Key string
 }
+
+// An organization along with how much of the service it uses, for the platform admin console
+type OrganizationUsage struct {
+	Organization
+	Users           int64
+	Events          int64
+	EventsLastDay   int64
+	EventsLastMonth int64
+	// Time of the most recent event, nil if the organization has none
+	LastEvent *time.Time
+}
+
+// Format the time of the most recent event for display, or "Never" if there is none
+func (u OrganizationUsage) FormattedLastEvent() string {
+	if u.LastEvent == nil {
+		return "Never"
+	}
+	return u.LastEvent.Format("2006/01/02 15:04:05")
+}
+
+// Returns a copy of the organization with unset settings filled in with their defaults
+func (o Organization) WithDefaults() Organization {
+	if o.Timezone == "" {
+		o.Timezone = DefaultTimezone
+	}
+	if o.DefaultPageSize == 0 {
+		o.DefaultPageSize = DefaultPageSize
+	}
+	return o
+}
+
+// Get the location of the organization's time zone, falling back to UTC if it is invalid
+func (o *Organization) Location() *time.Location {
+	loc, err := time.LoadLocation(o.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Get the pagination options used for the event list when they aren't supplied in the request
+func (o *Organization) DefaultPagination() *data.PaginationRequestOptions {
+	pag := data.DefaultPaginationRequestOptions()
+	if o.DefaultPageSize > 0 {
+		pag.Limit = o.DefaultPageSize
+	}
+	if o.DefaultFilter != "" {
+		// invalid filters are rejected when the settings are saved, so can be ignored here
+		pag.Filter, _ = data.FilterOptionsFromString(o.DefaultFilter)
+	}
+	return pag
+}
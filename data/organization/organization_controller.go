@@ -1,54 +1,300 @@
 package organization
 
 import (
-	"log"
-	"strconv"
+	"errors"
+	"fmt"
 
 	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
 )
 
+// Validates organization data before it is saved
+type Validator interface {
+	ValidateOrganization(org *Organization) (valid bool, errors map[string]string)
+}
+
 type OrganizationController struct {
-	repo *OrganizationRepository
+	repo      *OrganizationRepository
+	auditRepo *audit.AuditRepository
+	validator Validator
 }
 
-func NewOrganizationController(repo *OrganizationRepository) *OrganizationController {
+func NewOrganizationController(repo *OrganizationRepository, auditRepo *audit.AuditRepository, validator Validator) *OrganizationController {
 	return &OrganizationController{
-		repo: repo,
+		repo:      repo,
+		auditRepo: auditRepo,
+		validator: validator,
 	}
 }
 
-func (c *OrganizationController) List(ctx *gin.Context) {
-	authorizedGlobal, exists := ctx.Get("authorizedGlobal")
+// Body of organization create and update requests
+type organizationRequest struct {
+	Name            string
+	Timezone        string
+	RetentionDays   int
+	DefaultFilter   string
+	DefaultPageSize int64
+}
+
+// Copy the request onto an organization
+func (r organizationRequest) apply(org Organization) Organization {
+	org.Name = r.Name
+	org.Timezone = r.Timezone
+	org.RetentionDays = r.RetentionDays
+	org.DefaultFilter = r.DefaultFilter
+	org.DefaultPageSize = r.DefaultPageSize
+	return org
+}
+
+// Body of organization limit update requests
+type limitsRequest struct {
+	RateLimit    int
+	MonthlyQuota int64
+}
 
-	if !exists || !authorizedGlobal.(bool) {
-		ctx.AbortWithStatus(401)
+// Handler for GET /org
+func (c *OrganizationController) List(ctx *gin.Context) {
+	if !auth.IsAuthorizedForGlobal(ctx) {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": "not authorized to list organizations"})
 		return
 	}
 
 	orgs, err := c.repo.All()
 	if err != nil {
-		ctx.AbortWithError(500, err)
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(200, orgs)
 }
 
+// Handler for GET /org/:org_id
 func (c *OrganizationController) Details(ctx *gin.Context) {
-	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	id, err := auth.GetAndAuthorizeOrgIDParam(ctx)
 	if err != nil {
-		ctx.AbortWithError(500, err)
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
 		return
 	}
 
-	// validate
-
 	org, err := c.repo.GetByID(id)
-	log.Println("Org:" + org.Name)
 	if err != nil {
-		ctx.AbortWithStatusJSON(404, gin.H{"error": err})
+		ctx.AbortWithStatusJSON(404, gin.H{"error": "organization not found"})
 		return
 	}
 
 	ctx.JSON(200, org)
 }
+
+// Handler for POST /org. Responds with the new organization and its API key, which is only returned here
+func (c *OrganizationController) Create(ctx *gin.Context) {
+	if !auth.IsAuthorizedForGlobal(ctx) {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": "not authorized to create organizations"})
+		return
+	}
+
+	var req organizationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	org := req.apply(Organization{})
+	if valid, errs := c.validator.ValidateOrganization(&org); !valid {
+		ctx.AbortWithStatusJSON(400, gin.H{"errors": errs})
+		return
+	}
+
+	key, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := c.repo.Create(org, key)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			ctx.AbortWithStatusJSON(409, gin.H{"errors": gin.H{"OrganizationName": "An organization with this name already exists"}})
+			return
+		}
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.auditRepo.TryRecord(audit.FromAPIKey(ctx, created.ID, audit.ActionOrganizationCreated).
+		On(audit.TargetOrganization, created.ID))
+
+	ctx.JSON(201, OrganizationSecret{Organization: *created, Key: key})
+}
+
+// Handler for PUT /org/:org_id. Fields missing from the request body keep their current values
+func (c *OrganizationController) Update(ctx *gin.Context) {
+	id, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := c.repo.GetByID(id)
+	if err != nil {
+		ctx.AbortWithStatusJSON(404, gin.H{"error": "organization not found"})
+		return
+	}
+
+	req := organizationRequest{
+		Name:            existing.Name,
+		Timezone:        existing.Timezone,
+		RetentionDays:   existing.RetentionDays,
+		DefaultFilter:   existing.DefaultFilter,
+		DefaultPageSize: existing.DefaultPageSize,
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	org := req.apply(*existing)
+	if valid, errs := c.validator.ValidateOrganization(&org); !valid {
+		ctx.AbortWithStatusJSON(400, gin.H{"errors": errs})
+		return
+	}
+
+	updated, err := c.repo.Update(id, org)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			ctx.AbortWithStatusJSON(409, gin.H{"errors": gin.H{"OrganizationName": "An organization with this name already exists"}})
+			return
+		}
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if changes := audit.Diff(*existing, *updated); len(changes) > 0 {
+		c.auditRepo.TryRecord(audit.FromAPIKey(ctx, id, audit.ActionOrganizationUpdated).
+			On(audit.TargetOrganization, id).
+			WithChanges(changes))
+	}
+
+	ctx.JSON(200, updated)
+}
+
+// Handler for PUT /org/:org_id/limits. Sets the rate limit and monthly quota of an organization, where 0 uses the
+// server's limits. Fields missing from the request body keep their current values
+func (c *OrganizationController) UpdateLimits(ctx *gin.Context) {
+	if !auth.IsAuthorizedForGlobal(ctx) {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": "not authorized to change organization limits"})
+		return
+	}
+
+	id, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := c.repo.GetByID(id)
+	if err != nil {
+		ctx.AbortWithStatusJSON(404, gin.H{"error": "organization not found"})
+		return
+	}
+
+	req := limitsRequest{
+		RateLimit:    existing.RateLimit,
+		MonthlyQuota: existing.MonthlyQuota,
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	errs := make(map[string]string)
+	if req.RateLimit < 0 {
+		errs["RateLimit"] = "Rate limit cannot be negative"
+	}
+	if req.MonthlyQuota < 0 {
+		errs["MonthlyQuota"] = "Monthly quota cannot be negative"
+	}
+	if len(errs) > 0 {
+		ctx.AbortWithStatusJSON(400, gin.H{"errors": errs})
+		return
+	}
+
+	updated, err := c.repo.SetLimits(id, req.RateLimit, req.MonthlyQuota)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if changes := audit.Diff(*existing, *updated); len(changes) > 0 {
+		c.auditRepo.TryRecord(audit.FromAPIKey(ctx, id, audit.ActionLimitsUpdated).
+			On(audit.TargetOrganization, id).
+			WithChanges(changes))
+	}
+
+	ctx.JSON(200, updated)
+}
+
+// Handler for POST /org/:org_id/key. Responds with the new API key, the old one stops working immediately
+func (c *OrganizationController) RotateKey(ctx *gin.Context) {
+	id, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.repo.SetKey(id, key); err != nil {
+		ctx.AbortWithStatusJSON(404, gin.H{"error": "organization not found"})
+		return
+	}
+
+	c.auditRepo.TryRecord(audit.FromAPIKey(ctx, id, audit.ActionAPIKeyChanged).
+		On(audit.TargetOrganization, id))
+
+	ctx.JSON(200, gin.H{"Key": key})
+}
+
+// Handler for DELETE /org/:org_id. Deletes the organization with all of its users, sessions and events
+func (c *OrganizationController) Delete(ctx *gin.Context) {
+	if !auth.IsAuthorizedForGlobal(ctx) {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": "not authorized to delete organizations"})
+		return
+	}
+
+	id, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	org, err := c.repo.GetByID(id)
+	if err != nil {
+		ctx.AbortWithStatusJSON(404, gin.H{"error": "organization not found"})
+		return
+	}
+
+	// platform organizations, including the one making the request, operate the service and can't be removed through the API
+	if org.Platform {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": "cannot delete a platform organization"})
+		return
+	}
+
+	if err := c.repo.Delete(id); err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the deleted organization's own audit log is removed with it, so the deletion is recorded against the organization that requested it
+	c.auditRepo.TryRecord(audit.FromAPIKey(ctx, auth.AuthorizedOrgID(ctx), audit.ActionOrganizationDeleted).
+		On(audit.TargetOrganization, id).
+		WithDetails(fmt.Sprintf("Deleted organization '%s'", org.Name)))
+
+	ctx.Status(204)
+}
@@ -4,17 +4,22 @@ import (
 	"database/sql"
 	"errors"
 
+	"github.com/lib/pq"
+	"github.com/slimnate/laser-beam/cache"
 	"github.com/slimnate/laser-beam/data"
 )
 
 // Repository
 type OrganizationRepository struct {
 	db *sql.DB
+	// organizations looked up while ingesting events, so their limits aren't loaded for every event
+	cache *cache.Cache[int64, Organization]
 }
 
 func NewOrganizationRepository(db *sql.DB) *OrganizationRepository {
 	return &OrganizationRepository{
-		db: db,
+		db:    db,
+		cache: cache.New[int64, Organization]("organizations", cache.DefaultTTL),
 	}
 }
 
@@ -28,16 +33,101 @@ func (r *OrganizationRepository) Migrate() error {
 	`
 
 	_, err := r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	if err := r.migratePlatform(); err != nil {
+		return err
+	}
+
+	// add columns introduced after the table was first created
+	queries := []string{
+		"ALTER TABLE organizations ADD COLUMN IF NOT EXISTS require_two_factor BOOLEAN NOT NULL DEFAULT false",
+		"ALTER TABLE organizations ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'UTC'",
+		"ALTER TABLE organizations ADD COLUMN IF NOT EXISTS retention_days INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE organizations ADD COLUMN IF NOT EXISTS default_filter VARCHAR(100) NOT NULL DEFAULT ''",
+		"ALTER TABLE organizations ADD COLUMN IF NOT EXISTS default_page_size INTEGER NOT NULL DEFAULT 10",
+		"ALTER TABLE organizations ADD COLUMN IF NOT EXISTS rate_limit INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE organizations ADD COLUMN IF NOT EXISTS monthly_quota BIGINT NOT NULL DEFAULT 0",
+	}
+	for _, q := range queries {
+		if _, err := r.db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Add the platform flag, given to the organization that was previously global because of its ID
+func (r *OrganizationRepository) migratePlatform() error {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT FROM information_schema.columns
+		WHERE table_name = 'organizations' AND column_name = 'platform')`).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		"ALTER TABLE organizations ADD COLUMN platform BOOLEAN NOT NULL DEFAULT false",
+		"UPDATE organizations SET platform = true WHERE id = 1",
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Columns selected for every organization query
+const organizationColumns = "id, name, platform, require_two_factor, timezone, retention_days, default_filter, default_page_size, rate_limit, monthly_quota"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// Scan a row of organizationColumns, followed by any `extra` columns
+func scanOrganization(row scanner, extra ...any) (*Organization, error) {
+	var org Organization
+	dest := []any{&org.ID, &org.Name, &org.Platform, &org.RequireTwoFactor, &org.Timezone, &org.RetentionDays, &org.DefaultFilter, &org.DefaultPageSize, &org.RateLimit, &org.MonthlyQuota}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrNotExists
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// Map unique constraint violations on the organization name to data.ErrDuplicate
+func mapDuplicate(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+		return data.ErrDuplicate
+	}
 	return err
 }
 
 func (r *OrganizationRepository) Create(org Organization, key string) (*Organization, error) {
+	org = org.WithDefaults()
+
 	var lastInsertId int64
-	query := "INSERT INTO organizations (name, key) values ($1, $2) RETURNING id"
-	err := r.db.QueryRow(query, org.Name, key).Scan(&lastInsertId)
+	query := `INSERT INTO organizations (name, key, platform, timezone, retention_days, default_filter, default_page_size)
+		values ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	err := r.db.QueryRow(query, org.Name, key, org.Platform, org.Timezone, org.RetentionDays, org.DefaultFilter, org.DefaultPageSize).Scan(&lastInsertId)
 
 	if err != nil {
-		return nil, err
+		return nil, mapDuplicate(err)
 	}
 
 	org.ID = lastInsertId
@@ -46,7 +136,7 @@ func (r *OrganizationRepository) Create(org Organization, key string) (*Organiza
 }
 
 func (r *OrganizationRepository) All() ([]Organization, error) {
-	rows, err := r.db.Query("SELECT id, name from organizations")
+	rows, err := r.db.Query("SELECT " + organizationColumns + " FROM organizations ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
@@ -54,48 +144,135 @@ func (r *OrganizationRepository) All() ([]Organization, error) {
 
 	var all []Organization
 	for rows.Next() {
-		var org Organization
-		if err := rows.Scan(&org.ID, &org.Name); err != nil {
+		org, err := scanOrganization(rows)
+		if err != nil {
 			return nil, err
 		}
-		all = append(all, org)
+		all = append(all, *org)
 	}
 	return all, nil
 }
 
+// Get every organization along with its user count and event volumes
+func (r *OrganizationRepository) AllWithUsage() ([]OrganizationUsage, error) {
+	query := `SELECT ` + organizationColumns + `,
+			(SELECT COUNT(*) FROM users WHERE users.organization_id = organizations.id),
+			e.total, e.last_day, e.last_month, e.last_event
+		FROM organizations, LATERAL (
+			SELECT COUNT(*) AS total,
+				COUNT(*) FILTER (WHERE time > NOW() - INTERVAL '1 day') AS last_day,
+				COUNT(*) FILTER (WHERE time > NOW() - INTERVAL '30 days') AS last_month,
+				MAX(time) AS last_event
+			FROM events WHERE events.organization_id = organizations.id
+		) e
+		ORDER BY id`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []OrganizationUsage
+	for rows.Next() {
+		var u OrganizationUsage
+		var lastEvent sql.NullTime
+		org, err := scanOrganization(rows, &u.Users, &u.Events, &u.EventsLastDay, &u.EventsLastMonth, &lastEvent)
+		if err != nil {
+			return nil, err
+		}
+		u.Organization = *org
+		if lastEvent.Valid {
+			u.LastEvent = &lastEvent.Time
+		}
+		all = append(all, u)
+	}
+	return all, rows.Err()
+}
+
 func (r *OrganizationRepository) GetByID(id int64) (*Organization, error) {
-	row := r.db.QueryRow("SELECT id, name FROM organizations WHERE id = $1", id)
+	return scanOrganization(r.db.QueryRow("SELECT "+organizationColumns+" FROM organizations WHERE id = $1", id))
+}
 
-	var org Organization
-	if err := row.Scan(&org.ID, &org.Name); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, data.ErrNotExists
+// Same as GetByID, but uses the cached organization, for ingesting events
+func (r *OrganizationRepository) GetCachedByID(id int64) (*Organization, error) {
+	org, err := r.cache.Get(id, func() (Organization, error) {
+		org, err := r.GetByID(id)
+		if err != nil {
+			return Organization{}, err
 		}
+		return *org, nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &org, nil
 }
 
 func (r *OrganizationRepository) GetByKey(key string) (*Organization, error) {
-	row := r.db.QueryRow("SELECT id, name FROM organizations WHERE key = $1", key)
+	return scanOrganization(r.db.QueryRow("SELECT "+organizationColumns+" FROM organizations WHERE key = $1", key))
+}
 
-	var org Organization
-	if err := row.Scan(&org.ID, &org.Name); err != nil {
+// Get the API key of an organization
+func (r *OrganizationRepository) GetKey(id int64) (string, error) {
+	var key string
+	if err := r.db.QueryRow("SELECT key FROM organizations WHERE id = $1", id).Scan(&key); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, data.ErrNotExists
+			return "", data.ErrNotExists
 		}
-		return nil, err
+		return "", err
 	}
-	return &org, nil
+	return key, nil
+}
+
+// Replace the API key of an organization. The old key stops working immediately
+func (r *OrganizationRepository) SetKey(id int64, key string) error {
+	res, err := r.db.Exec("UPDATE organizations SET key = $1 WHERE id = $2", key, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrUpdateFailed
+	}
+
+	return nil
 }
 
+// Update the name and settings of an organization
 func (r *OrganizationRepository) Update(id int64, updated Organization) (*Organization, error) {
 	if id == 0 {
 		return nil, errors.New("invalid ID to update")
 	}
-	query := "UPDATE organizations SET name = $1 WHERE id = $2"
-	res, err := r.db.Exec(query, updated.Name, updated.ID)
+	query := `UPDATE organizations SET name = $1, timezone = $2, retention_days = $3, default_filter = $4, default_page_size = $5
+		WHERE id = $6`
+	res, err := r.db.Exec(query, updated.Name, updated.Timezone, updated.RetentionDays, updated.DefaultFilter, updated.DefaultPageSize, id)
 
+	if err != nil {
+		return nil, mapDuplicate(err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, data.ErrUpdateFailed
+	}
+	r.cache.Invalidate(id)
+
+	return r.GetByID(id)
+}
+
+// Set the rate limit and monthly quota of an organization, where 0 uses the server's limits
+func (r *OrganizationRepository) SetLimits(id int64, rateLimit int, monthlyQuota int64) (*Organization, error) {
+	res, err := r.db.Exec("UPDATE organizations SET rate_limit = $1, monthly_quota = $2 WHERE id = $3", rateLimit, monthlyQuota, id)
 	if err != nil {
 		return nil, err
 	}
@@ -108,12 +285,57 @@ func (r *OrganizationRepository) Update(id int64, updated Organization) (*Organi
 	if rowsAffected == 0 {
 		return nil, data.ErrUpdateFailed
 	}
+	r.cache.Invalidate(id)
 
-	return &updated, nil
+	return r.GetByID(id)
 }
 
+// Set whether users of the organization must use two-factor authentication
+func (r *OrganizationRepository) SetRequireTwoFactor(id int64, require bool) error {
+	res, err := r.db.Exec("UPDATE organizations SET require_two_factor = $1 WHERE id = $2", require, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrUpdateFailed
+	}
+	r.cache.Invalidate(id)
+
+	return nil
+}
+
+// Delete an organization along with its users, their sessions, its events and custom roles. Other data belonging to
+// the organization is removed by the ON DELETE CASCADE of its foreign keys
 func (r *OrganizationRepository) Delete(id int64) error {
-	res, err := r.db.Exec("DELETE FROM organizations WHERE id = $1", id)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// children are deleted before the rows they reference
+	queries := []string{
+		"DELETE FROM sessions WHERE user_id IN (SELECT id FROM users WHERE organization_id = $1)",
+		"DELETE FROM events WHERE organization_id = $1",
+		// members from other organizations keep their accounts, but lose access to this one
+		"DELETE FROM memberships WHERE organization_id = $1",
+		"DELETE FROM users WHERE organization_id = $1",
+		"DELETE FROM identity_providers WHERE organization_id = $1",
+		"DELETE FROM roles WHERE organization_id = $1",
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q, id); err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.Exec("DELETE FROM organizations WHERE id = $1", id)
 	if err != nil {
 		return err
 	}
@@ -127,5 +349,10 @@ func (r *OrganizationRepository) Delete(id int64) error {
 		return data.ErrDeleteFailed
 	}
 
-	return err
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.cache.Invalidate(id)
+
+	return nil
 }
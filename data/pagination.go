@@ -4,18 +4,29 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Columns that lists can be filtered and ordered by. Filter and order params are checked against these when they are
+// parsed, since the column names are formatted into queries
+var (
+	FilterColumns = []string{"type", "application"}
+	OrderColumns  = []string{"id", "type", "application", "name", "message", "time"}
+)
+
 type PaginationRequestOptions struct {
 	Offset  int64
 	Limit   int64
 	Filter  *FilterOption
 	OrderBy *OrderOption
 	Search  string
+	// Only include items from this environment, eg. "production". Applied alongside the filter, empty includes every environment
+	Environment string
 }
 
 type FilterOption struct {
@@ -34,9 +45,11 @@ type PaginationResponseData[T any] struct {
 	PreviousPage  *PaginationRequestOptions
 	NextPage      *PaginationRequestOptions
 	FilterOptions []FilterOptionsList
-	Start         int64
-	End           int64
-	Total         int64
+	// environments the items can be limited to, with the selected one
+	EnvironmentOptions *FilterOptionsList
+	Start              int64
+	End                int64
+	Total              int64
 }
 
 type FilterOptionsList struct {
@@ -50,7 +63,7 @@ func (p *PaginationRequestOptions) ToString() string {
 	if p == nil {
 		return "nil"
 	}
-	return fmt.Sprintf("offset: %d Limit: %d Filter: %s OrderBy: %s", p.Offset, p.Limit, FilterOptionsToString(p.Filter), OrderOptionsToString(p.OrderBy))
+	return fmt.Sprintf("offset: %d Limit: %d Filter: %s OrderBy: %s Environment: %s", p.Offset, p.Limit, FilterOptionsToString(p.Filter), OrderOptionsToString(p.OrderBy), p.Environment)
 }
 
 // returns a set of query params that can be used to link to this PaginationRequestOptions object
@@ -58,19 +71,29 @@ func (p *PaginationRequestOptions) QueryParams() template.URL {
 	q := p.OffsetLimitQueryParams()
 	q = ApplyFilterOptionsToQueryParams(q, p.Filter)
 	q = ApplyOrderOptionsToQueryParams(q, p.OrderBy)
+	q = ApplyEnvironmentToQueryParams(q, p.Environment)
 	// q = ApplySearchToQueryParams(q, p.Search)
 	return template.URL(q)
 }
 
+// returns a link to `path` that preserves the offset, limit, filter, order, search and environment of this PaginationRequestOptions object
+func (p *PaginationRequestOptions) PathWithQueryParams(path string) template.URL {
+	q := p.OffsetLimitQueryParams()
+	q = ApplyFilterOptionsToQueryParams(q, p.Filter)
+	q = ApplyOrderOptionsToQueryParams(q, p.OrderBy)
+	q = ApplySearchToQueryParams(q, p.Search)
+	q = ApplyEnvironmentToQueryParams(q, p.Environment)
+	return template.URL(path + q)
+}
+
 func (p *PaginationRequestOptions) OffsetLimitQueryParams() string {
 	return fmt.Sprintf("?offset=%d&limit=%d&", p.Offset, p.Limit)
 }
 
+// Adds the filter to the query params. A nil filter is added as an empty `filter` param, so links without a filter
+// aren't given the default filter of the organization
 func ApplyFilterOptionsToQueryParams(q string, f *FilterOption) string {
-	if f != nil {
-		return fmt.Sprintf("%s&filter=%s", q, FilterOptionsToString(f))
-	}
-	return q
+	return fmt.Sprintf("%s&filter=%s", q, FilterOptionsToString(f))
 }
 
 func ApplyOrderOptionsToQueryParams(q string, o *OrderOption) string {
@@ -87,6 +110,13 @@ func ApplySearchToQueryParams(q string, s string) string {
 	return q
 }
 
+func ApplyEnvironmentToQueryParams(q string, env string) string {
+	if env != "" {
+		return fmt.Sprintf("%s&environment=%s", q, url.QueryEscape(env))
+	}
+	return q
+}
+
 // returns the PaginationRequestOptions object that would correspond to the next page of items
 func (p *PaginationRequestOptions) Next(total int64) *PaginationRequestOptions {
 	res := p.Copy()
@@ -118,10 +148,11 @@ func (p *PaginationRequestOptions) Previous(total int64) *PaginationRequestOptio
 // returns a pointer to a copy of the current PaginationRequestOptions struct
 func (p *PaginationRequestOptions) Copy() *PaginationRequestOptions {
 	return &PaginationRequestOptions{
-		Offset:  p.Offset,
-		Limit:   p.Limit,
-		Filter:  p.Filter,
-		OrderBy: p.OrderBy,
+		Offset:      p.Offset,
+		Limit:       p.Limit,
+		Filter:      p.Filter,
+		OrderBy:     p.OrderBy,
+		Environment: p.Environment,
 	}
 }
 
@@ -201,6 +232,7 @@ func ParsePaginationRequestOptionsCustomDefault(ctx *gin.Context, defaultOptions
 	filter, filterExists := ctx.GetQuery("filter")
 	order, orderExists := ctx.GetQuery("order_by")
 	search, _ := ctx.GetQuery("search")
+	environment, _ := ctx.GetQuery("environment")
 
 	// parse offset
 	if offsetExists {
@@ -226,12 +258,17 @@ func ParsePaginationRequestOptionsCustomDefault(ctx *gin.Context, defaultOptions
 		defaultOptions.Limit = sysDefaults.Limit
 	}
 
-	// parse filter
-	if filterExists {
+	// parse filter, where an empty filter param clears the default filter
+	if filterExists && filter == "" {
+		defaultOptions.Filter = nil
+	} else if filterExists {
 		filterOptions, err := FilterOptionsFromString(filter)
 		if err != nil {
 			return nil, err
 		}
+		if !slices.Contains(FilterColumns, filterOptions.Key) {
+			return nil, fmt.Errorf("invalid 'filter' column '%s'", filterOptions.Key)
+		}
 		defaultOptions.Filter = filterOptions
 	}
 	if defaultOptions.Filter == nil {
@@ -244,6 +281,13 @@ func ParsePaginationRequestOptionsCustomDefault(ctx *gin.Context, defaultOptions
 		if err != nil {
 			return nil, err
 		}
+		if !slices.Contains(OrderColumns, orderOptions.Column) {
+			return nil, fmt.Errorf("invalid 'order_by' column '%s'", orderOptions.Column)
+		}
+		orderOptions.Direction = strings.ToLower(orderOptions.Direction)
+		if orderOptions.Direction != "asc" && orderOptions.Direction != "desc" {
+			return nil, fmt.Errorf("invalid 'order_by' direction '%s'", orderOptions.Direction)
+		}
 		defaultOptions.OrderBy = orderOptions
 	}
 	if defaultOptions.OrderBy == nil {
@@ -253,6 +297,9 @@ func ParsePaginationRequestOptionsCustomDefault(ctx *gin.Context, defaultOptions
 	// parse search
 	defaultOptions.Search = search
 
+	// parse environment
+	defaultOptions.Environment = environment
+
 	return defaultOptions, nil
 }
 
@@ -260,6 +307,7 @@ func (p *PaginationRequestOptions) SortLinkForColumn(col string) template.URL {
 	q := p.OffsetLimitQueryParams()
 	q = ApplyFilterOptionsToQueryParams(q, p.Filter)
 	q = ApplySearchToQueryParams(q, p.Search)
+	q = ApplyEnvironmentToQueryParams(q, p.Environment)
 
 	newOrder := OrderOption{}
 	if p.OrderBy == nil {
@@ -288,6 +336,7 @@ func (p *PaginationRequestOptions) FilterLink(col string, value string) template
 	q := p.OffsetLimitQueryParams()
 	q = ApplyOrderOptionsToQueryParams(q, p.OrderBy)
 	q = ApplySearchToQueryParams(q, p.Search)
+	q = ApplyEnvironmentToQueryParams(q, p.Environment)
 
 	// if col and value both provided, init newfilter. Otherwise it wil be nil
 	// this is how we get a link to "unselect" a filter
@@ -307,6 +356,7 @@ func (p *PaginationRequestOptions) SearchLink(s string) template.URL {
 	q := p.OffsetLimitQueryParams()
 	q = ApplyFilterOptionsToQueryParams(q, p.Filter)
 	q = ApplyOrderOptionsToQueryParams(q, p.OrderBy)
+	q = ApplyEnvironmentToQueryParams(q, p.Environment)
 
 	q = ApplySearchToQueryParams(q, s)
 	return template.URL(q)
@@ -316,7 +366,19 @@ func (p *PaginationRequestOptions) SearchLinkStub(s string) template.URL {
 	q := p.OffsetLimitQueryParams()
 	q = ApplyFilterOptionsToQueryParams(q, p.Filter)
 	q = ApplyOrderOptionsToQueryParams(q, p.OrderBy)
+	q = ApplyEnvironmentToQueryParams(q, p.Environment)
 
 	q += "&search="
 	return template.URL(q)
 }
+
+// Get a link to the same list limited to the environment `env`, keeping the filter, order and search. An empty `env`
+// links to every environment
+func (p *PaginationRequestOptions) EnvironmentLink(env string) template.URL {
+	q := p.OffsetLimitQueryParams()
+	q = ApplyFilterOptionsToQueryParams(q, p.Filter)
+	q = ApplyOrderOptionsToQueryParams(q, p.OrderBy)
+	q = ApplySearchToQueryParams(q, p.Search)
+	q = ApplyEnvironmentToQueryParams(q, env)
+	return template.URL(q)
+}
@@ -0,0 +1,73 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"time"
+)
+
+// Limits on the values an organization can configure
+const (
+	MinLengthFloor   = 8
+	MaxHistoryCount  = 24
+	MaxPasswordAge   = 365 // days
+	MinLengthCeiling = 64
+)
+
+// Password requirements for the users of an organization
+type PasswordPolicy struct {
+	OrganizationID   int64
+	MinLength        int
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	// Reject passwords found in the embedded list of common and breached passwords
+	RejectCommon bool
+	// Number of previous passwords that can't be reused, 0 allows reuse
+	HistoryCount int
+	// Number of days before a password has to be changed, 0 never expires passwords
+	MaxAgeDays int
+}
+
+// Policy used by organizations that haven't configured their own
+func Default(orgID int64) PasswordPolicy {
+	return PasswordPolicy{
+		OrganizationID: orgID,
+		MinLength:      MinLengthFloor,
+		RejectCommon:   true,
+	}
+}
+
+// Human readable list of the policy's requirements, for display alongside password forms
+func (p PasswordPolicy) Requirements() []string {
+	reqs := []string{fmt.Sprintf("At least %d characters", p.MinLength)}
+	if p.RequireUppercase {
+		reqs = append(reqs, "An uppercase letter")
+	}
+	if p.RequireLowercase {
+		reqs = append(reqs, "A lowercase letter")
+	}
+	if p.RequireDigit {
+		reqs = append(reqs, "A number")
+	}
+	if p.RequireSymbol {
+		reqs = append(reqs, "A symbol")
+	}
+	if p.RejectCommon {
+		reqs = append(reqs, "Not a commonly used password")
+	}
+	if p.HistoryCount == 1 {
+		reqs = append(reqs, "Different from your previous password")
+	} else if p.HistoryCount > 1 {
+		reqs = append(reqs, fmt.Sprintf("Different from your previous %d passwords", p.HistoryCount))
+	}
+	return reqs
+}
+
+// Returns true if a password last changed at `changedAt` has passed the policy's maximum age
+func (p PasswordPolicy) Expired(changedAt time.Time, now time.Time) bool {
+	if p.MaxAgeDays <= 0 {
+		return false
+	}
+	return now.Sub(changedAt) > time.Duration(p.MaxAgeDays)*24*time.Hour
+}
@@ -0,0 +1,73 @@
+package passwordpolicy
+
+import (
+	"database/sql"
+	"errors"
+)
+
+type PasswordPolicyRepository struct {
+	db *sql.DB
+}
+
+func NewPasswordPolicyRepository(db *sql.DB) *PasswordPolicyRepository {
+	return &PasswordPolicyRepository{
+		db: db,
+	}
+}
+
+func (r *PasswordPolicyRepository) Migrate() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS password_policies(
+		organization_id INTEGER PRIMARY KEY,
+		min_length INTEGER NOT NULL,
+		require_uppercase BOOLEAN NOT NULL DEFAULT false,
+		require_lowercase BOOLEAN NOT NULL DEFAULT false,
+		require_digit BOOLEAN NOT NULL DEFAULT false,
+		require_symbol BOOLEAN NOT NULL DEFAULT false,
+		reject_common BOOLEAN NOT NULL DEFAULT true,
+		history_count INTEGER NOT NULL DEFAULT 0,
+		max_age_days INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE
+	)
+	`
+
+	_, err := r.db.Exec(query)
+	return err
+}
+
+// Get the password policy for an organization, or the default policy if it hasn't configured one
+func (r *PasswordPolicyRepository) GetForOrganization(orgID int64) (*PasswordPolicy, error) {
+	query := `SELECT organization_id, min_length, require_uppercase, require_lowercase, require_digit, require_symbol,
+		reject_common, history_count, max_age_days FROM password_policies WHERE organization_id = $1`
+
+	var p PasswordPolicy
+	err := r.db.QueryRow(query, orgID).Scan(&p.OrganizationID, &p.MinLength, &p.RequireUppercase, &p.RequireLowercase,
+		&p.RequireDigit, &p.RequireSymbol, &p.RejectCommon, &p.HistoryCount, &p.MaxAgeDays)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			p = Default(orgID)
+			return &p, nil
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Create or replace the password policy for an organization
+func (r *PasswordPolicyRepository) Save(p PasswordPolicy) (*PasswordPolicy, error) {
+	query := `INSERT INTO password_policies(organization_id, min_length, require_uppercase, require_lowercase, require_digit,
+			require_symbol, reject_common, history_count, max_age_days)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (organization_id) DO UPDATE SET min_length = EXCLUDED.min_length,
+			require_uppercase = EXCLUDED.require_uppercase, require_lowercase = EXCLUDED.require_lowercase,
+			require_digit = EXCLUDED.require_digit, require_symbol = EXCLUDED.require_symbol,
+			reject_common = EXCLUDED.reject_common, history_count = EXCLUDED.history_count, max_age_days = EXCLUDED.max_age_days`
+
+	_, err := r.db.Exec(query, p.OrganizationID, p.MinLength, p.RequireUppercase, p.RequireLowercase, p.RequireDigit,
+		p.RequireSymbol, p.RejectCommon, p.HistoryCount, p.MaxAgeDays)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,13 @@
+package passwordreset
+
+import "time"
+
+// How long a password reset link can be used for after it is sent
+const TTL = time.Hour
+
+type PasswordReset struct {
+	ID        int64
+	UserID    int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
@@ -0,0 +1,94 @@
+package passwordreset
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/slimnate/laser-beam/data"
+)
+
+type PasswordResetRepository struct {
+	db *sql.DB
+}
+
+func NewPasswordResetRepository(db *sql.DB) *PasswordResetRepository {
+	return &PasswordResetRepository{
+		db: db,
+	}
+}
+
+func (r *PasswordResetRepository) Migrate() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS password_resets(
+		id SERIAL PRIMARY KEY,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		user_id INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	)
+	`
+
+	_, err := r.db.Exec(query)
+	return err
+}
+
+// Store a new password reset for a user, identified by the hash of the token that was emailed to them
+func (r *PasswordResetRepository) Create(userID int64, tokenHash string) (*PasswordReset, error) {
+	reset := PasswordReset{
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	}
+	reset.ExpiresAt = reset.CreatedAt.Add(TTL)
+
+	query := "INSERT INTO password_resets(token_hash, user_id, created_at, expires_at) VALUES ($1, $2, $3, $4) RETURNING id"
+	err := r.db.QueryRow(query, tokenHash, reset.UserID, reset.CreatedAt, reset.ExpiresAt).Scan(&reset.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reset, nil
+}
+
+// Get an unused, unexpired password reset by the hash of its token. Returns data.ErrNotExists if there is none
+func (r *PasswordResetRepository) GetValidByTokenHash(tokenHash string) (*PasswordReset, error) {
+	row := r.db.QueryRow("SELECT id, user_id, created_at, expires_at FROM password_resets WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2", tokenHash, time.Now())
+
+	var reset PasswordReset
+	if err := row.Scan(&reset.ID, &reset.UserID, &reset.CreatedAt, &reset.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrNotExists
+		}
+		return nil, err
+	}
+	return &reset, nil
+}
+
+// Mark a password reset as used so it can't be used again. Returns data.ErrUpdateFailed if it was already used or
+// has expired, so concurrent requests can't both use the same token
+func (r *PasswordResetRepository) MarkUsed(id int64) error {
+	t := time.Now()
+	res, err := r.db.Exec("UPDATE password_resets SET used_at = $1 WHERE id = $2 AND used_at IS NULL AND expires_at > $1", t, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrUpdateFailed
+	}
+
+	return nil
+}
+
+// Delete all password resets for a user, so any other outstanding links stop working once the password is changed
+func (r *PasswordResetRepository) DeleteAllForUser(userID int64) error {
+	_, err := r.db.Exec("DELETE FROM password_resets WHERE user_id = $1", userID)
+	return err
+}
@@ -0,0 +1,55 @@
+package project
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Platforms an application can be built for
+var Platforms = []string{"web", "server", "mobile", "desktop", "other"}
+
+// Platform given to applications when none is chosen
+const DefaultPlatform = "other"
+
+// An application that sends events to an organization
+type Project struct {
+	ID             int64
+	OrganizationID int64
+	Name           string
+	// URL safe identifier, unique within the organization. Events can name their application by either slug or name
+	Slug     string
+	Platform string
+	// Events sent for a disabled application are rejected
+	Enabled bool
+	// Events older than this many days are deleted, 0 uses the retention period of the organization
+	RetentionDays int
+	CreatedAt     time.Time
+}
+
+type ProjectSecret struct {
+	Project
+	Key string
+}
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Generate a slug from an application name, eg. "My App!" -> "my-app"
+func Slugify(name string) string {
+	return strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// Returns a copy of the project with unset settings filled in with their defaults
+func (p Project) WithDefaults() Project {
+	if p.Slug == "" {
+		p.Slug = Slugify(p.Name)
+	}
+	if p.Platform == "" {
+		p.Platform = DefaultPlatform
+	}
+	return p
+}
+
+func (p *Project) FormattedCreatedAt() string {
+	return p.CreatedAt.Format("2006/01/02 15:04:05")
+}
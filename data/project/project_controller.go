@@ -0,0 +1,243 @@
+package project
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+)
+
+// Validates project data before it is saved
+type Validator interface {
+	ValidateProject(p *Project) (valid bool, errors map[string]string)
+}
+
+type ProjectController struct {
+	repo      *ProjectRepository
+	auditRepo *audit.AuditRepository
+	validator Validator
+}
+
+func NewProjectController(repo *ProjectRepository, auditRepo *audit.AuditRepository, validator Validator) *ProjectController {
+	return &ProjectController{
+		repo:      repo,
+		auditRepo: auditRepo,
+		validator: validator,
+	}
+}
+
+// Body of project create and update requests
+type projectRequest struct {
+	Name          string
+	Slug          string
+	Platform      string
+	Enabled       bool
+	RetentionDays int
+}
+
+// Copy the request onto a project
+func (r projectRequest) apply(p Project) Project {
+	p.Name = r.Name
+	p.Slug = r.Slug
+	p.Platform = r.Platform
+	p.Enabled = r.Enabled
+	p.RetentionDays = r.RetentionDays
+	return p
+}
+
+// Response for requests that fail because the name or slug is already used by another application
+var duplicateErrors = gin.H{"errors": gin.H{"Name": "An application with this name or slug already exists"}}
+
+// Get the project_id path param
+func projectIDParam(ctx *gin.Context) (int64, error) {
+	id, err := strconv.ParseInt(ctx.Param("project_id"), 10, 64)
+	if err != nil {
+		return -1, errors.New("invalid project_id")
+	}
+	return id, nil
+}
+
+// Handler for GET /org/:org_id/projects
+func (c *ProjectController) List(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	projects, err := c.repo.AllForOrganization(orgID)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, projects)
+}
+
+// Handler for POST /org/:org_id/projects. Responds with the new application and its API key, which is only returned here
+func (c *ProjectController) Create(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	req := projectRequest{Enabled: true}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	p := req.apply(Project{OrganizationID: orgID})
+	if valid, errs := c.validator.ValidateProject(&p); !valid {
+		ctx.AbortWithStatusJSON(400, gin.H{"errors": errs})
+		return
+	}
+
+	key, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := c.repo.Create(p, key)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			ctx.AbortWithStatusJSON(409, duplicateErrors)
+			return
+		}
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.auditRepo.TryRecord(audit.FromAPIKey(ctx, orgID, audit.ActionProjectCreated).
+		On(audit.TargetProject, created.ID).
+		WithDetails(created.Name))
+
+	ctx.JSON(201, ProjectSecret{Project: *created, Key: key})
+}
+
+// Handler for PUT /org/:org_id/projects/:project_id. Fields missing from the request body keep their current values
+func (c *ProjectController) Update(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := projectIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := c.repo.GetByIDForOrganization(id, orgID)
+	if err != nil {
+		ctx.AbortWithStatusJSON(404, gin.H{"error": "application not found"})
+		return
+	}
+
+	req := projectRequest{
+		Name:          existing.Name,
+		Slug:          existing.Slug,
+		Platform:      existing.Platform,
+		Enabled:       existing.Enabled,
+		RetentionDays: existing.RetentionDays,
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	p := req.apply(*existing)
+	if valid, errs := c.validator.ValidateProject(&p); !valid {
+		ctx.AbortWithStatusJSON(400, gin.H{"errors": errs})
+		return
+	}
+
+	updated, err := c.repo.Update(id, orgID, p)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			ctx.AbortWithStatusJSON(409, duplicateErrors)
+			return
+		}
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if changes := audit.Diff(*existing, *updated); len(changes) > 0 {
+		c.auditRepo.TryRecord(audit.FromAPIKey(ctx, orgID, audit.ActionProjectUpdated).
+			On(audit.TargetProject, id).
+			WithChanges(changes))
+	}
+
+	ctx.JSON(200, updated)
+}
+
+// Handler for POST /org/:org_id/projects/:project_id/key. Responds with the new API key, the old one stops working immediately
+func (c *ProjectController) RotateKey(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := projectIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.repo.SetKey(id, orgID, key); err != nil {
+		ctx.AbortWithStatusJSON(404, gin.H{"error": "application not found"})
+		return
+	}
+
+	c.auditRepo.TryRecord(audit.FromAPIKey(ctx, orgID, audit.ActionProjectKeyChanged).On(audit.TargetProject, id))
+
+	ctx.JSON(200, gin.H{"Key": key})
+}
+
+// Handler for DELETE /org/:org_id/projects/:project_id. Deletes the application along with its events
+func (c *ProjectController) Delete(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := projectIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, _ := c.repo.GetByIDForOrganization(id, orgID)
+
+	if err := c.repo.Delete(id, orgID); err != nil {
+		if errors.Is(err, data.ErrDeleteFailed) {
+			ctx.AbortWithStatusJSON(404, gin.H{"error": "application not found"})
+			return
+		}
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry := audit.FromAPIKey(ctx, orgID, audit.ActionProjectDeleted).On(audit.TargetProject, id)
+	if existing != nil {
+		entry = entry.WithDetails(existing.Name)
+	}
+	c.auditRepo.TryRecord(entry)
+
+	ctx.Status(204)
+}
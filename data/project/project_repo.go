@@ -0,0 +1,256 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/slimnate/laser-beam/cache"
+	"github.com/slimnate/laser-beam/data"
+)
+
+type ProjectRepository struct {
+	db *sql.DB
+	// applications of each organization used while ingesting events, so they aren't loaded for every event
+	cache *cache.Cache[int64, []Project]
+}
+
+func NewProjectRepository(db *sql.DB) *ProjectRepository {
+	return &ProjectRepository{
+		db:    db,
+		cache: cache.New[int64, []Project]("projects", cache.DefaultTTL),
+	}
+}
+
+func (r *ProjectRepository) Migrate() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS projects(
+			id SERIAL PRIMARY KEY,
+			organization_id INTEGER NOT NULL,
+			name VARCHAR(50) NOT NULL,
+			slug VARCHAR(50) NOT NULL,
+			platform VARCHAR(20) NOT NULL DEFAULT 'other',
+			key VARCHAR(100) NOT NULL UNIQUE,
+			enabled BOOLEAN NOT NULL DEFAULT true,
+			retention_days INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL DEFAULT now(),
+			UNIQUE(organization_id, name),
+			UNIQUE(organization_id, slug),
+			FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE
+		)`,
+	}
+	for _, q := range queries {
+		if _, err := r.db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Columns selected for every project query
+const projectColumns = "id, organization_id, name, slug, platform, enabled, retention_days, created_at"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanProject(row scanner) (*Project, error) {
+	var p Project
+	err := row.Scan(&p.ID, &p.OrganizationID, &p.Name, &p.Slug, &p.Platform, &p.Enabled, &p.RetentionDays, &p.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrNotExists
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Map unique constraint violations on the name, slug or key to data.ErrDuplicate
+func mapDuplicate(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+		return data.ErrDuplicate
+	}
+	return err
+}
+
+func (r *ProjectRepository) Create(p Project, key string) (*Project, error) {
+	p = p.WithDefaults()
+
+	query := `INSERT INTO projects(organization_id, name, slug, platform, key, enabled, retention_days)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
+	err := r.db.QueryRow(query, p.OrganizationID, p.Name, p.Slug, p.Platform, key, p.Enabled, p.RetentionDays).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		return nil, mapDuplicate(err)
+	}
+	r.cache.Invalidate(p.OrganizationID)
+
+	return &p, nil
+}
+
+// Get the applications of an organization, ordered by name
+func (r *ProjectRepository) AllForOrganization(orgID int64) ([]Project, error) {
+	rows, err := r.db.Query("SELECT "+projectColumns+" FROM projects WHERE organization_id = $1 ORDER BY name", orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []Project
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, *p)
+	}
+	return all, rows.Err()
+}
+
+func (r *ProjectRepository) GetByIDForOrganization(id int64, orgID int64) (*Project, error) {
+	return scanProject(r.db.QueryRow("SELECT "+projectColumns+" FROM projects WHERE id = $1 AND organization_id = $2", id, orgID))
+}
+
+// Get an application of an organization by its slug or name, which is how events identify their application
+func (r *ProjectRepository) GetByApplication(orgID int64, application string) (*Project, error) {
+	query := "SELECT " + projectColumns + " FROM projects WHERE organization_id = $1 AND (slug = $2 OR name = $2) ORDER BY slug = $2 DESC LIMIT 1"
+	return scanProject(r.db.QueryRow(query, orgID, application))
+}
+
+// Same as GetByIDForOrganization, but uses the cached applications of the organization, for ingesting events.
+// Applications that aren't cached are looked up in the db, in case they were just created on another server
+func (r *ProjectRepository) GetCachedByID(id int64, orgID int64) (*Project, error) {
+	p, err := r.findCached(orgID, func(p Project) bool { return p.ID == id })
+	if errors.Is(err, data.ErrNotExists) {
+		return r.GetByIDForOrganization(id, orgID)
+	}
+	return p, err
+}
+
+// Same as GetByApplication, but uses the cached applications of the organization like GetCachedByID
+func (r *ProjectRepository) GetCachedByApplication(orgID int64, application string) (*Project, error) {
+	// slugs are matched first, like GetByApplication
+	p, err := r.findCached(orgID, func(p Project) bool { return p.Slug == application })
+	if errors.Is(err, data.ErrNotExists) {
+		p, err = r.findCached(orgID, func(p Project) bool { return p.Name == application })
+	}
+	if errors.Is(err, data.ErrNotExists) {
+		return r.GetByApplication(orgID, application)
+	}
+	return p, err
+}
+
+// Get the first cached application of an organization that matches, or data.ErrNotExists
+func (r *ProjectRepository) findCached(orgID int64, match func(Project) bool) (*Project, error) {
+	all, err := r.cache.Get(orgID, func() ([]Project, error) {
+		return r.AllForOrganization(orgID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range all {
+		if match(p) {
+			return &p, nil
+		}
+	}
+	return nil, data.ErrNotExists
+}
+
+func (r *ProjectRepository) GetByKey(key string) (*Project, error) {
+	return scanProject(r.db.QueryRow("SELECT "+projectColumns+" FROM projects WHERE key = $1", key))
+}
+
+// Get the API key of an application
+func (r *ProjectRepository) GetKey(id int64, orgID int64) (string, error) {
+	var key string
+	if err := r.db.QueryRow("SELECT key FROM projects WHERE id = $1 AND organization_id = $2", id, orgID).Scan(&key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", data.ErrNotExists
+		}
+		return "", err
+	}
+	return key, nil
+}
+
+// Replace the API key of an application. The old key stops working immediately
+func (r *ProjectRepository) SetKey(id int64, orgID int64, key string) error {
+	res, err := r.db.Exec("UPDATE projects SET key = $1 WHERE id = $2 AND organization_id = $3", key, id, orgID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrUpdateFailed
+	}
+
+	return nil
+}
+
+// Update the name and settings of an application. Renaming an application also renames it on its events
+func (r *ProjectRepository) Update(id int64, orgID int64, updated Project) (*Project, error) {
+	if id == 0 {
+		return nil, errors.New("invalid ID to update")
+	}
+	updated = updated.WithDefaults()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE projects SET name = $1, slug = $2, platform = $3, enabled = $4, retention_days = $5
+		WHERE id = $6 AND organization_id = $7`
+	res, err := tx.Exec(query, updated.Name, updated.Slug, updated.Platform, updated.Enabled, updated.RetentionDays, id, orgID)
+	if err != nil {
+		return nil, mapDuplicate(err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, data.ErrUpdateFailed
+	}
+
+	if _, err := tx.Exec("UPDATE events SET application = $1 WHERE project_id = $2 AND application IS DISTINCT FROM $1", updated.Name, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	r.cache.Invalidate(orgID)
+
+	return r.GetByIDForOrganization(id, orgID)
+}
+
+// Delete an application. Its events are removed by the ON DELETE CASCADE of their foreign key
+func (r *ProjectRepository) Delete(id int64, orgID int64) error {
+	res, err := r.db.Exec("DELETE FROM projects WHERE id = $1 AND organization_id = $2", id, orgID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrDeleteFailed
+	}
+	r.cache.Invalidate(orgID)
+
+	return nil
+}
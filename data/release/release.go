@@ -0,0 +1,61 @@
+package release
+
+import (
+	"fmt"
+	"time"
+)
+
+// A version of an application, created when the first event is sent with it
+type Release struct {
+	ID        int64
+	ProjectID int64
+	Version   string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// number of events sent with the release, and how many of them were errors
+	Events int64
+	Errors int64
+	// change in the number of errors since the previous release, nil for the first release
+	ErrorsChange *int64
+}
+
+// Fill in the change in errors of each release, compared to the release before it. Releases must be ordered newest first
+func Compare(releases []Release) {
+	for i := 0; i < len(releases)-1; i++ {
+		change := releases[i].Errors - releases[i+1].Errors
+		releases[i].ErrorsChange = &change
+	}
+}
+
+// Percentage of the release's events that were errors
+func (r Release) ErrorRate() float64 {
+	if r.Events == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Events) * 100
+}
+
+func (r Release) FormattedErrorRate() string {
+	return fmt.Sprintf("%.1f%%", r.ErrorRate())
+}
+
+// Format the change in errors for display, eg. "+3", or "-" for the first release
+func (r Release) FormattedErrorsChange() string {
+	if r.ErrorsChange == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%+d", *r.ErrorsChange)
+}
+
+// Returns true if the release had more errors than the release before it
+func (r Release) ErrorsIncreased() bool {
+	return r.ErrorsChange != nil && *r.ErrorsChange > 0
+}
+
+func (r Release) FormattedFirstSeen() string {
+	return r.FirstSeen.Format("2006/01/02 15:04:05")
+}
+
+func (r Release) FormattedLastSeen() string {
+	return r.LastSeen.Format("2006/01/02 15:04:05")
+}
@@ -0,0 +1,50 @@
+package release
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/data/project"
+)
+
+type ReleaseController struct {
+	repo        *ReleaseRepository
+	projectRepo *project.ProjectRepository
+}
+
+func NewReleaseController(repo *ReleaseRepository, projectRepo *project.ProjectRepository) *ReleaseController {
+	return &ReleaseController{
+		repo:        repo,
+		projectRepo: projectRepo,
+	}
+}
+
+// Handler for GET /org/:org_id/projects/:project_id/releases. Event counts can be limited to an environment with the
+// `environment` query param
+func (c *ReleaseController) List(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	projectID, err := strconv.ParseInt(ctx.Param("project_id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": "invalid project_id"})
+		return
+	}
+
+	if _, err := c.projectRepo.GetByIDForOrganization(projectID, orgID); err != nil {
+		ctx.AbortWithStatusJSON(404, gin.H{"error": "application not found"})
+		return
+	}
+
+	releases, err := c.repo.AllForProject(projectID, ctx.Query("environment"))
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, releases)
+}
@@ -0,0 +1,109 @@
+package release
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+type ReleaseRepository struct {
+	db *sql.DB
+}
+
+func NewReleaseRepository(db *sql.DB) *ReleaseRepository {
+	return &ReleaseRepository{
+		db: db,
+	}
+}
+
+// Create the releases table, filling it in from the events sent before releases were tracked. The events are only
+// scanned when the table is first created
+func (r *ReleaseRepository) Migrate() error {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'releases')`).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		`CREATE TABLE releases(
+			id SERIAL PRIMARY KEY,
+			project_id INTEGER NOT NULL,
+			version VARCHAR(100) NOT NULL,
+			first_seen TIMESTAMP NOT NULL,
+			last_seen TIMESTAMP NOT NULL,
+			UNIQUE(project_id, version),
+			FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
+		)`,
+		`INSERT INTO releases(project_id, version, first_seen, last_seen)
+			SELECT project_id, release, MIN(time), MAX(time) FROM events
+			WHERE project_id IS NOT NULL AND release != ''
+			GROUP BY project_id, release
+			ON CONFLICT DO NOTHING`,
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Record an event sent with a release at time `t`, creating the release if it is new and widening the times it has
+// been seen between
+func (r *ReleaseRepository) Record(projectID int64, version string, t time.Time) error {
+	query := `INSERT INTO releases(project_id, version, first_seen, last_seen) VALUES ($1, $2, $3, $3)
+		ON CONFLICT (project_id, version) DO UPDATE SET
+			first_seen = LEAST(releases.first_seen, EXCLUDED.first_seen),
+			last_seen = GREATEST(releases.last_seen, EXCLUDED.last_seen)`
+	_, err := r.db.Exec(query, projectID, version, t)
+	return err
+}
+
+// Same as Record, but failures are logged rather than returned, for callers that have already saved the event
+func (r *ReleaseRepository) TryRecord(projectID int64, version string, t time.Time) {
+	if err := r.Record(projectID, version, t); err != nil {
+		log.Println("[releases] Failed to record release: " + err.Error())
+	}
+}
+
+// Get the releases of an application, newest first, with the change in errors between each of them. Event counts only
+// include events from `environment`, or every environment if it is empty
+func (r *ReleaseRepository) AllForProject(projectID int64, environment string) ([]Release, error) {
+	query := `SELECT r.id, r.project_id, r.version, r.first_seen, r.last_seen, c.events, c.errors
+		FROM releases r, LATERAL (
+			SELECT COUNT(*) AS events, COUNT(*) FILTER (WHERE type = 'error') AS errors
+			FROM events e WHERE e.project_id = r.project_id AND e.release = r.version AND ($2 = '' OR e.environment = $2)
+		) c
+		WHERE r.project_id = $1
+		ORDER BY r.first_seen DESC, r.id DESC`
+
+	rows, err := r.db.Query(query, projectID, environment)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []Release
+	for rows.Next() {
+		var rel Release
+		if err := rows.Scan(&rel.ID, &rel.ProjectID, &rel.Version, &rel.FirstSeen, &rel.LastSeen, &rel.Events, &rel.Errors); err != nil {
+			return nil, err
+		}
+		all = append(all, rel)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	Compare(all)
+
+	return all, nil
+}
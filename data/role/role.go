@@ -0,0 +1,65 @@
+package role
+
+import "github.com/slimnate/laser-beam/auth"
+
+// Names of the built-in roles that are available to every organization
+const (
+	Viewer      = "Viewer"
+	Member      = "Member"
+	OrgAdmin    = "Org Admin"
+	GlobalAdmin = "Global Admin"
+)
+
+// Permissions granted to each of the built-in roles
+var BuiltInRoles = map[string][]auth.Permission{
+	Viewer: {
+		auth.PermissionViewEvents,
+	},
+	Member: {
+		auth.PermissionViewEvents,
+		auth.PermissionEditEvents,
+	},
+	OrgAdmin: {
+		auth.PermissionViewEvents,
+		auth.PermissionEditEvents,
+		auth.PermissionManageUsers,
+		auth.PermissionManageRoles,
+		auth.PermissionManageOrganization,
+		auth.PermissionViewAuditLog,
+	},
+	GlobalAdmin: auth.AllPermissions,
+}
+
+type Role struct {
+	ID             int64
+	Name           string
+	Permissions    []auth.Permission
+	OrganizationID *int64 // nil for built-in roles, set for custom roles belonging to a single organization
+}
+
+func (r *Role) BuiltIn() bool {
+	return r.OrganizationID == nil
+}
+
+func (r *Role) HasPermission(p auth.Permission) bool {
+	if r == nil {
+		return false
+	}
+	for _, granted := range r.Permissions {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the role can be assigned by someone holding the permissions of `g`. A role can only be assigned by
+// someone who holds every permission the role grants, so users can't grant more access than they have themselves.
+func (r *Role) AssignableBy(g auth.Grantor) bool {
+	for _, p := range r.Permissions {
+		if !g.HasPermission(p) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package role
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+)
+
+type RoleController struct {
+	repo      *RoleRepository
+	auditRepo *audit.AuditRepository
+}
+
+func NewRoleController(repo *RoleRepository, auditRepo *audit.AuditRepository) *RoleController {
+	return &RoleController{
+		repo:      repo,
+		auditRepo: auditRepo,
+	}
+}
+
+// Validate the name and permissions of a custom role
+func validateCustomRole(r *Role) error {
+	if r.Name == "" {
+		return errors.New("role name is required")
+	}
+	if _, builtIn := BuiltInRoles[r.Name]; builtIn {
+		return fmt.Errorf("'%s' is the name of a built-in role", r.Name)
+	}
+	for _, p := range r.Permissions {
+		if !auth.ValidPermission(p) {
+			return fmt.Errorf("unknown permission '%s'", p)
+		}
+		if p == auth.PermissionManageGlobal {
+			return fmt.Errorf("permission '%s' cannot be granted to custom roles", p)
+		}
+	}
+	return nil
+}
+
+// Changes made by a role update for the audit log, including permissions which aren't compared by audit.Diff
+func roleChanges(before *Role, after *Role) []audit.Change {
+	changes := audit.Diff(*before, *after)
+
+	old := strings.Join(permissionStrings(before.Permissions), ", ")
+	new := strings.Join(permissionStrings(after.Permissions), ", ")
+	if old != new {
+		changes = append(changes, audit.Change{Field: "Permissions", Old: old, New: new})
+	}
+
+	return changes
+}
+
+// Handler for GET /org/:org_id/roles
+func (c *RoleController) List(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	roles, err := c.repo.AllForOrganization(orgID)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, roles)
+}
+
+// Handler for POST /org/:org_id/roles
+func (c *RoleController) Create(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	var r Role
+	if err := ctx.ShouldBindJSON(&r); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateCustomRole(&r); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	r.OrganizationID = &orgID
+	created, err := c.repo.Create(r)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			ctx.AbortWithStatusJSON(409, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.auditRepo.TryRecord(audit.FromAPIKey(ctx, orgID, audit.ActionRoleCreated).On(audit.TargetRole, created.ID).
+		WithDetails(fmt.Sprintf("%s: %s", created.Name, strings.Join(permissionStrings(created.Permissions), ", "))))
+
+	ctx.JSON(200, created)
+}
+
+// Handler for PUT /org/:org_id/roles/:role_id
+func (c *RoleController) Update(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := strconv.ParseInt(ctx.Param("role_id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": "invalid role_id"})
+		return
+	}
+
+	var r Role
+	if err := ctx.ShouldBindJSON(&r); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateCustomRole(&r); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// missing roles are reported by the update below, so the lookup error can be ignored here
+	existing, _ := c.repo.GetByIDForOrganization(id, orgID)
+
+	updated, err := c.repo.Update(id, orgID, r)
+	if err != nil {
+		if errors.Is(err, data.ErrUpdateFailed) {
+			ctx.AbortWithStatusJSON(404, gin.H{"error": "custom role not found"})
+			return
+		}
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if existing != nil {
+		if changes := roleChanges(existing, updated); len(changes) > 0 {
+			c.auditRepo.TryRecord(audit.FromAPIKey(ctx, orgID, audit.ActionRoleUpdated).On(audit.TargetRole, id).WithChanges(changes))
+		}
+	}
+
+	ctx.JSON(200, updated)
+}
+
+// Handler for DELETE /org/:org_id/roles/:role_id
+func (c *RoleController) Delete(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := strconv.ParseInt(ctx.Param("role_id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": "invalid role_id"})
+		return
+	}
+
+	existing, _ := c.repo.GetByIDForOrganization(id, orgID)
+
+	if err := c.repo.Delete(id, orgID); err != nil {
+		if errors.Is(err, data.ErrForeignKey) {
+			ctx.AbortWithStatusJSON(409, gin.H{"error": "role is still assigned to users"})
+			return
+		}
+		if errors.Is(err, data.ErrDeleteFailed) {
+			ctx.AbortWithStatusJSON(404, gin.H{"error": "custom role not found"})
+			return
+		}
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry := audit.FromAPIKey(ctx, orgID, audit.ActionRoleDeleted).On(audit.TargetRole, id)
+	if existing != nil {
+		entry = entry.WithDetails(existing.Name)
+	}
+	c.auditRepo.TryRecord(entry)
+
+	ctx.Status(204)
+}
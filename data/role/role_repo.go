@@ -0,0 +1,190 @@
+package role
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/data"
+)
+
+type RoleRepository struct {
+	db *sql.DB
+}
+
+func NewRoleRepository(db *sql.DB) *RoleRepository {
+	return &RoleRepository{
+		db: db,
+	}
+}
+
+func (r *RoleRepository) Migrate() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS roles(
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(50) NOT NULL,
+		permissions TEXT[] NOT NULL DEFAULT '{}',
+		organization_id INTEGER,
+		UNIQUE(organization_id, name),
+		FOREIGN KEY(organization_id) REFERENCES organizations(id)
+	)
+	`
+
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	// built-in roles have no organization, so they need their own unique index since NULLs are never equal
+	query = "CREATE UNIQUE INDEX IF NOT EXISTS roles_built_in_name_idx ON roles(name) WHERE organization_id IS NULL"
+	_, err = r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	// create or update the built-in roles so their permissions always match the current code
+	query = `INSERT INTO roles(name, permissions) VALUES ($1, $2)
+		ON CONFLICT (name) WHERE organization_id IS NULL DO UPDATE SET permissions = EXCLUDED.permissions`
+	for name, permissions := range BuiltInRoles {
+		_, err = r.db.Exec(query, name, pq.Array(permissionStrings(permissions)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *RoleRepository) Create(role Role) (*Role, error) {
+	var lastInsertId int64
+	query := "INSERT INTO roles(name, permissions, organization_id) VALUES ($1, $2, $3) RETURNING id"
+	err := r.db.QueryRow(query, role.Name, pq.Array(permissionStrings(role.Permissions)), role.OrganizationID).Scan(&lastInsertId)
+
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+			return nil, data.ErrDuplicate
+		}
+		return nil, err
+	}
+
+	role.ID = lastInsertId
+
+	return &role, nil
+}
+
+// Get all roles that can be assigned to users of an organization - the built-in roles, followed by the organization's custom roles
+func (r *RoleRepository) AllForOrganization(orgID int64) ([]Role, error) {
+	rows, err := r.db.Query("SELECT id, name, permissions, organization_id FROM roles WHERE organization_id IS NULL OR organization_id = $1 ORDER BY organization_id NULLS FIRST, id", orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []Role
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, *role)
+	}
+	return all, nil
+}
+
+func (r *RoleRepository) GetByID(id int64) (*Role, error) {
+	row := r.db.QueryRow("SELECT id, name, permissions, organization_id FROM roles WHERE id = $1", id)
+	return scanRole(row)
+}
+
+// Get a role by ID, only if it can be assigned to users of the supplied organization
+func (r *RoleRepository) GetByIDForOrganization(id int64, orgID int64) (*Role, error) {
+	row := r.db.QueryRow("SELECT id, name, permissions, organization_id FROM roles WHERE id = $1 AND (organization_id IS NULL OR organization_id = $2)", id, orgID)
+	return scanRole(row)
+}
+
+func (r *RoleRepository) GetBuiltIn(name string) (*Role, error) {
+	row := r.db.QueryRow("SELECT id, name, permissions, organization_id FROM roles WHERE name = $1 AND organization_id IS NULL", name)
+	return scanRole(row)
+}
+
+// Update the name and permissions of a custom role. Built-in roles cannot be updated
+func (r *RoleRepository) Update(id int64, orgID int64, updated Role) (*Role, error) {
+	if id == 0 {
+		return nil, errors.New("invalid ID to update")
+	}
+	query := "UPDATE roles SET name = $1, permissions = $2 WHERE id = $3 AND organization_id = $4"
+	res, err := r.db.Exec(query, updated.Name, pq.Array(permissionStrings(updated.Permissions)), id, orgID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, data.ErrUpdateFailed
+	}
+
+	return r.GetByID(id)
+}
+
+// Delete a custom role. Built-in roles and roles that are still assigned to users cannot be deleted
+func (r *RoleRepository) Delete(id int64, orgID int64) error {
+	res, err := r.db.Exec("DELETE FROM roles WHERE id = $1 AND organization_id = $2", id, orgID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+			return data.ErrForeignKey
+		}
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrDeleteFailed
+	}
+
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRole(row scanner) (*Role, error) {
+	var role Role
+	var permissions []string
+	var orgID sql.NullInt64
+	if err := row.Scan(&role.ID, &role.Name, pq.Array(&permissions), &orgID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrNotExists
+		}
+		return nil, err
+	}
+
+	for _, p := range permissions {
+		role.Permissions = append(role.Permissions, auth.Permission(p))
+	}
+	if orgID.Valid {
+		role.OrganizationID = &orgID.Int64
+	}
+
+	return &role, nil
+}
+
+func permissionStrings(permissions []auth.Permission) []string {
+	s := make([]string, len(permissions))
+	for i, p := range permissions {
+		s[i] = string(p)
+	}
+	return s
+}
@@ -0,0 +1,184 @@
+package rule
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/slimnate/laser-beam/data/event"
+)
+
+// What a rule does to the events it matches
+const (
+	ActionSample  = "sample"
+	ActionDrop    = "drop"
+	ActionRewrite = "rewrite"
+)
+
+var Actions = []string{ActionSample, ActionDrop, ActionRewrite}
+
+// Event fields that rewrite rules can set. The application can't be rewritten, since it decides which project the
+// event belongs to
+var RewriteFields = []string{"type", "name", "message", "environment", "release"}
+
+// Event fields that tag conditions can match, in the `key:value` format of the filter query param
+var TagKeys = []string{"environment", "release"}
+
+// Ingestion rule of an organization, applied to events sent to the API before they are saved. Conditions that are
+// empty match every event, so a rule without any conditions applies to all of the organization's events
+type Rule struct {
+	ID             int64
+	OrganizationID int64
+	// 1-based order the rule is applied in, set when listing an organization's rules
+	Position int
+	Type     string
+	// application the rule is limited to, nil for all applications
+	ProjectID *int64
+	// name of ProjectID, for display
+	Application string
+	// event names the rule matches, where `*` matches any characters
+	NamePattern string
+	// NamePattern compiled when the rule is loaded, see nameRegexp
+	namePattern *regexp.Regexp
+	// `key:value` of a tag the event must have, where the key is one of TagKeys
+	Tag    string
+	Action string
+	// percentage of matching events kept by sample rules
+	SampleRate int
+	// event field rewrite rules set, and the value it is set to
+	Field     string
+	Value     string
+	CreatedAt time.Time
+}
+
+// Check whether an event meets all of the rule's conditions
+func (r Rule) Matches(e *event.Event) bool {
+	if r.Type != "" && r.Type != e.Type {
+		return false
+	}
+	if r.ProjectID != nil && (e.ProjectID == nil || *r.ProjectID != *e.ProjectID) {
+		return false
+	}
+	if r.NamePattern != "" && !r.nameRegexp().MatchString(e.Name) {
+		return false
+	}
+	if r.Tag != "" {
+		key, value, _ := strings.Cut(r.Tag, ":")
+		if tagValue(e, key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the compiled name pattern of the rule. Rules loaded from the db have it compiled already, so it isn't compiled
+// again for every event
+func (r Rule) nameRegexp() *regexp.Regexp {
+	if r.namePattern != nil {
+		return r.namePattern
+	}
+	return globToRegexp(r.NamePattern)
+}
+
+// Check whether the rule is limited to the application of `projectID`
+func (r Rule) IsForProject(projectID int64) bool {
+	return r.ProjectID != nil && *r.ProjectID == projectID
+}
+
+// Set the rule's field on an event
+func (r Rule) rewrite(e *event.Event) {
+	switch r.Field {
+	case "type":
+		e.Type = r.Value
+	case "name":
+		e.Name = r.Value
+	case "message":
+		e.Message = r.Value
+	case "environment":
+		e.Environment = r.Value
+	case "release":
+		e.Release = r.Value
+	}
+}
+
+// Describe the rule's conditions for display
+func (r Rule) FormattedConditions() string {
+	var conditions []string
+	if r.Type != "" {
+		conditions = append(conditions, "type is "+r.Type)
+	}
+	if r.ProjectID != nil {
+		conditions = append(conditions, "application is "+r.Application)
+	}
+	if r.NamePattern != "" {
+		conditions = append(conditions, "name matches "+r.NamePattern)
+	}
+	if r.Tag != "" {
+		conditions = append(conditions, "tagged "+r.Tag)
+	}
+	if len(conditions) == 0 {
+		return "All events"
+	}
+	return strings.Join(conditions, ", ")
+}
+
+// Describe what the rule does to matching events for display
+func (r Rule) FormattedAction() string {
+	switch r.Action {
+	case ActionSample:
+		return fmt.Sprintf("Keep %d%%", r.SampleRate)
+	case ActionDrop:
+		return "Drop"
+	case ActionRewrite:
+		return fmt.Sprintf("Set %s to '%s'", r.Field, r.Value)
+	}
+	return r.Action
+}
+
+func (r Rule) FormattedCreatedAt() string {
+	return r.CreatedAt.Format("2006/01/02 15:04:05")
+}
+
+// Get the value of an event's tag
+func tagValue(e *event.Event, key string) string {
+	switch key {
+	case "environment":
+		return e.Environment
+	case "release":
+		return e.Release
+	}
+	return ""
+}
+
+// Convert a name pattern, where `*` matches any characters, to a regular expression matching the whole name
+func globToRegexp(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// Apply rules to an event in order. Rewrite rules change the event and carry on to the next rule, so later rules
+// match the rewritten event. Matching drop rules, and sample rules that don't keep the event, stop there
+func Apply(rules []Rule, e *event.Event) event.Outcome {
+	for _, r := range rules {
+		if !r.Matches(e) {
+			continue
+		}
+
+		switch r.Action {
+		case ActionDrop:
+			return event.OutcomeDropped
+		case ActionSample:
+			if rand.Intn(100) >= r.SampleRate {
+				return event.OutcomeSampled
+			}
+		case ActionRewrite:
+			r.rewrite(e)
+		}
+	}
+	return event.OutcomeKept
+}
@@ -0,0 +1,146 @@
+package rule
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/slimnate/laser-beam/cache"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/event"
+)
+
+type RuleRepository struct {
+	db *sql.DB
+	// rules of each organization used by FilterEvent, so they aren't loaded for every event
+	cache *cache.Cache[int64, []Rule]
+}
+
+func NewRuleRepository(db *sql.DB) *RuleRepository {
+	return &RuleRepository{
+		db:    db,
+		cache: cache.New[int64, []Rule]("ingestion_rules", cache.DefaultTTL),
+	}
+}
+
+func (r *RuleRepository) Migrate() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS ingestion_rules(
+			id SERIAL PRIMARY KEY,
+			organization_id INTEGER NOT NULL,
+			type VARCHAR(50) NOT NULL DEFAULT '',
+			project_id INTEGER,
+			name_pattern VARCHAR(250) NOT NULL DEFAULT '',
+			tag VARCHAR(150) NOT NULL DEFAULT '',
+			action VARCHAR(20) NOT NULL,
+			sample_rate INTEGER NOT NULL DEFAULT 0,
+			field VARCHAR(20) NOT NULL DEFAULT '',
+			value VARCHAR(1000) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT now(),
+			FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
+			FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
+		)`,
+		"CREATE INDEX IF NOT EXISTS ingestion_rules_organization_id ON ingestion_rules(organization_id)",
+	}
+	for _, q := range queries {
+		if _, err := r.db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Columns selected for every rule query, along with the name of the rule's application
+const ruleColumns = `r.id, r.organization_id, r.type, r.project_id, coalesce(p.name, ''), r.name_pattern, r.tag, r.action,
+	r.sample_rate, r.field, r.value, r.created_at`
+
+const ruleFrom = "ingestion_rules r LEFT JOIN projects p ON p.id = r.project_id"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRule(row scanner) (*Rule, error) {
+	var rule Rule
+	err := row.Scan(&rule.ID, &rule.OrganizationID, &rule.Type, &rule.ProjectID, &rule.Application, &rule.NamePattern,
+		&rule.Tag, &rule.Action, &rule.SampleRate, &rule.Field, &rule.Value, &rule.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrNotExists
+		}
+		return nil, err
+	}
+	if rule.NamePattern != "" {
+		rule.namePattern = globToRegexp(rule.NamePattern)
+	}
+	return &rule, nil
+}
+
+func (r *RuleRepository) Create(rule Rule) (*Rule, error) {
+	query := `INSERT INTO ingestion_rules(organization_id, type, project_id, name_pattern, tag, action, sample_rate, field, value)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
+	var id int64
+	err := r.db.QueryRow(query, rule.OrganizationID, rule.Type, rule.ProjectID, rule.NamePattern, rule.Tag, rule.Action,
+		rule.SampleRate, rule.Field, rule.Value).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.Invalidate(rule.OrganizationID)
+
+	return r.GetByIDForOrganization(id, rule.OrganizationID)
+}
+
+// Get the rules of an organization, in the order they are applied
+func (r *RuleRepository) AllForOrganization(orgID int64) ([]Rule, error) {
+	rows, err := r.db.Query("SELECT "+ruleColumns+" FROM "+ruleFrom+" WHERE r.organization_id = $1 ORDER BY r.id", orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []Rule
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rule.Position = len(all) + 1
+		all = append(all, *rule)
+	}
+	return all, rows.Err()
+}
+
+func (r *RuleRepository) GetByIDForOrganization(id int64, orgID int64) (*Rule, error) {
+	return scanRule(r.db.QueryRow("SELECT "+ruleColumns+" FROM "+ruleFrom+" WHERE r.id = $1 AND r.organization_id = $2", id, orgID))
+}
+
+func (r *RuleRepository) Delete(id int64, orgID int64) error {
+	res, err := r.db.Exec("DELETE FROM ingestion_rules WHERE id = $1 AND organization_id = $2", id, orgID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrDeleteFailed
+	}
+	r.cache.Invalidate(orgID)
+
+	return nil
+}
+
+// Apply the organization's rules to an event before it is saved, using the cached rules. Implements
+// event.IngestionFilter
+func (r *RuleRepository) FilterEvent(orgID int64, e *event.Event) (event.Outcome, error) {
+	rules, err := r.cache.Get(orgID, func() ([]Rule, error) {
+		return r.AllForOrganization(orgID)
+	})
+	if err != nil {
+		return "", err
+	}
+	return Apply(rules, e), nil
+}
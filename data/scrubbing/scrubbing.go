@@ -0,0 +1,186 @@
+package scrubbing
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/slimnate/laser-beam/data/event"
+)
+
+// Limits on the custom patterns an organization can configure
+const (
+	MaxCustomPatterns      = 10
+	MaxCustomPatternLength = 200
+)
+
+// Names of the detectors, recorded in the redactions of scrubbed events
+const (
+	DetectorEmail       = "email"
+	DetectorCreditCard  = "credit_card"
+	DetectorIPAddress   = "ip_address"
+	DetectorBearerToken = "bearer_token"
+	DetectorCustom      = "custom"
+)
+
+// Event fields sensitive data is removed from. The remaining fields are chosen by the client rather than copied from
+// user input, eg. the type or release
+var ScrubbedFields = []string{"name", "message"}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// 13 to 19 digits starting with one of the major card network prefixes, optionally separated by spaces or dashes.
+	// Matches are checked with the Luhn algorithm
+	creditCardPattern = regexp.MustCompile(`\b[3-6](?:[ \-]?\d){12,18}\b`)
+	// IPv4 and IPv6 addresses. Matches are checked by ipValid, so eg. times aren't mistaken for IPv6 addresses
+	ipPattern          = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}`)
+	bearerTokenPattern = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`)
+)
+
+// Sensitive data removal settings of an organization
+type ScrubbingPolicy struct {
+	OrganizationID int64
+	Email          bool
+	CreditCard     bool
+	IPAddress      bool
+	BearerToken    bool
+	// regular expressions of other data to remove, in RE2 syntax
+	CustomPatterns []string
+	// CustomPatterns compiled when the policy is loaded, so they aren't compiled for every event
+	customRegexps []*regexp.Regexp
+}
+
+// Policy used by organizations that haven't configured their own, which removes everything the built in detectors find
+func Default(orgID int64) ScrubbingPolicy {
+	return ScrubbingPolicy{
+		OrganizationID: orgID,
+		Email:          true,
+		CreditCard:     true,
+		IPAddress:      true,
+		BearerToken:    true,
+	}
+}
+
+// Custom patterns one per line, for the settings form
+func (p ScrubbingPolicy) FormattedCustomPatterns() string {
+	return strings.Join(p.CustomPatterns, "\n")
+}
+
+// Finds one kind of sensitive data in text
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	// checks matches of the pattern, nil accepts every match
+	check func(match string) bool
+}
+
+// Compile custom patterns, skipping those that don't compile since they are checked when the policy is saved
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if re, err := regexp.Compile(pattern); err == nil {
+			compiled = append(compiled, re)
+		}
+	}
+	return compiled
+}
+
+// Get the compiled custom patterns, compiling them if the policy wasn't loaded from the db
+func (p ScrubbingPolicy) customPatternRegexps() []*regexp.Regexp {
+	if p.customRegexps != nil {
+		return p.customRegexps
+	}
+	return compilePatterns(p.CustomPatterns)
+}
+
+// Get the detectors enabled by the policy
+func (p ScrubbingPolicy) detectors() []detector {
+	var detectors []detector
+	if p.BearerToken {
+		detectors = append(detectors, detector{name: DetectorBearerToken, pattern: bearerTokenPattern})
+	}
+	if p.Email {
+		detectors = append(detectors, detector{name: DetectorEmail, pattern: emailPattern})
+	}
+	if p.CreditCard {
+		detectors = append(detectors, detector{name: DetectorCreditCard, pattern: creditCardPattern, check: luhnValid})
+	}
+	if p.IPAddress {
+		detectors = append(detectors, detector{name: DetectorIPAddress, pattern: ipPattern, check: ipValid})
+	}
+	for _, re := range p.customPatternRegexps() {
+		detectors = append(detectors, detector{name: DetectorCustom, pattern: re})
+	}
+	return detectors
+}
+
+// Replace the matches of the detector in `text`, returning the scrubbed text and the number of matches replaced
+func (d detector) scrub(text string) (string, int) {
+	count := 0
+	scrubbed := d.pattern.ReplaceAllStringFunc(text, func(match string) string {
+		if match == "" || (d.check != nil && !d.check(match)) {
+			return match
+		}
+		count++
+		return "[" + d.name + "]"
+	})
+	return scrubbed, count
+}
+
+// Remove the sensitive data the policy detects from the scrubbed fields of an event. The event's redactions are
+// replaced with a record of what was removed
+func (p ScrubbingPolicy) Scrub(e *event.Event) {
+	e.Redactions = nil
+	fields := map[string]*string{"name": &e.Name, "message": &e.Message}
+
+	for _, d := range p.detectors() {
+		for _, field := range ScrubbedFields {
+			var count int
+			*fields[field], count = d.scrub(*fields[field])
+			if count > 0 {
+				// several custom patterns share a detector name, so their matches are combined
+				e.Redactions = event.AddRedaction(e.Redactions, event.Redaction{Field: field, Detector: d.name, Count: count})
+			}
+		}
+	}
+}
+
+// Check a match of ipPattern is an address. IPv6 addresses need at least 3 groups of digits, so eg. the `::` of C++
+// names or the loopback address `::1` aren't removed
+func ipValid(match string) bool {
+	ip := net.ParseIP(match)
+	if ip == nil {
+		return false
+	}
+	if ip.To4() != nil && !strings.Contains(match, ":") {
+		return true
+	}
+
+	groups := 0
+	for _, g := range strings.Split(match, ":") {
+		if g != "" {
+			groups++
+		}
+	}
+	return groups >= 3
+}
+
+// Check a sequence of digits, which may be separated by spaces or dashes, with the Luhn algorithm used by card numbers
+func luhnValid(number string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(number)
+
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
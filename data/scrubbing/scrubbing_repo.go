@@ -0,0 +1,95 @@
+package scrubbing
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/slimnate/laser-beam/cache"
+	"github.com/slimnate/laser-beam/data/event"
+)
+
+type ScrubbingRepository struct {
+	db *sql.DB
+	// policy of each organization used by ScrubEvent, so it isn't loaded for every event
+	cache *cache.Cache[int64, ScrubbingPolicy]
+}
+
+func NewScrubbingRepository(db *sql.DB) *ScrubbingRepository {
+	return &ScrubbingRepository{
+		db:    db,
+		cache: cache.New[int64, ScrubbingPolicy]("scrubbing_policies", cache.DefaultTTL),
+	}
+}
+
+func (r *ScrubbingRepository) Migrate() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS scrubbing_policies(
+		organization_id INTEGER PRIMARY KEY,
+		email BOOLEAN NOT NULL DEFAULT true,
+		credit_card BOOLEAN NOT NULL DEFAULT true,
+		ip_address BOOLEAN NOT NULL DEFAULT true,
+		bearer_token BOOLEAN NOT NULL DEFAULT true,
+		custom_patterns TEXT[] NOT NULL DEFAULT '{}',
+		FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE
+	)
+	`
+
+	_, err := r.db.Exec(query)
+	return err
+}
+
+// Get the scrubbing policy for an organization, or the default policy if it hasn't configured one
+func (r *ScrubbingRepository) GetForOrganization(orgID int64) (*ScrubbingPolicy, error) {
+	query := `SELECT organization_id, email, credit_card, ip_address, bearer_token, custom_patterns
+		FROM scrubbing_policies WHERE organization_id = $1`
+
+	var p ScrubbingPolicy
+	err := r.db.QueryRow(query, orgID).Scan(&p.OrganizationID, &p.Email, &p.CreditCard, &p.IPAddress, &p.BearerToken,
+		pq.Array(&p.CustomPatterns))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			p = Default(orgID)
+			return &p, nil
+		}
+		return nil, err
+	}
+	p.customRegexps = compilePatterns(p.CustomPatterns)
+	return &p, nil
+}
+
+// Create or replace the scrubbing policy for an organization
+func (r *ScrubbingRepository) Save(p ScrubbingPolicy) (*ScrubbingPolicy, error) {
+	if p.CustomPatterns == nil {
+		p.CustomPatterns = []string{}
+	}
+
+	query := `INSERT INTO scrubbing_policies(organization_id, email, credit_card, ip_address, bearer_token, custom_patterns)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (organization_id) DO UPDATE SET email = EXCLUDED.email, credit_card = EXCLUDED.credit_card,
+			ip_address = EXCLUDED.ip_address, bearer_token = EXCLUDED.bearer_token, custom_patterns = EXCLUDED.custom_patterns`
+
+	_, err := r.db.Exec(query, p.OrganizationID, p.Email, p.CreditCard, p.IPAddress, p.BearerToken, pq.Array(p.CustomPatterns))
+	if err != nil {
+		return nil, err
+	}
+	r.cache.Invalidate(p.OrganizationID)
+
+	return &p, nil
+}
+
+// Remove sensitive data from an event using its organization's cached policy. Implements event.Scrubber
+func (r *ScrubbingRepository) ScrubEvent(orgID int64, e *event.Event) error {
+	p, err := r.cache.Get(orgID, func() (ScrubbingPolicy, error) {
+		p, err := r.GetForOrganization(orgID)
+		if err != nil {
+			return ScrubbingPolicy{}, err
+		}
+		return *p, nil
+	})
+	if err != nil {
+		return err
+	}
+	p.Scrub(e)
+	return nil
+}
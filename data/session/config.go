@@ -0,0 +1,81 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	CookieName = "session_key"
+
+	DefaultAbsoluteTimeout = 30 * 24 * time.Hour
+	DefaultIdleTimeout     = 24 * time.Hour
+	LastSeenInterval       = time.Minute // minimum time between last seen updates, so every request doesn't write to the db
+	CleanupInterval        = time.Hour   // how often expired sessions are removed from the db
+)
+
+type Config struct {
+	AbsoluteTimeout  time.Duration // max session length, regardless of activity
+	IdleTimeout      time.Duration // max time between requests before the session expires
+	LastSeenInterval time.Duration
+	SecureCookie     bool // only send the session cookie over https
+}
+
+// Read the session config from the SESSION_ABSOLUTE_TIMEOUT, SESSION_IDLE_TIMEOUT and SESSION_COOKIE_SECURE env
+// variables, using the defaults for any that aren't set
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		AbsoluteTimeout:  DefaultAbsoluteTimeout,
+		IdleTimeout:      DefaultIdleTimeout,
+		LastSeenInterval: LastSeenInterval,
+		SecureCookie:     os.Getenv("SESSION_COOKIE_SECURE") != "false",
+	}
+
+	if v := os.Getenv("SESSION_ABSOLUTE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid SESSION_ABSOLUTE_TIMEOUT: %w", err)
+		}
+		cfg.AbsoluteTimeout = d
+	}
+
+	if v := os.Getenv("SESSION_IDLE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid SESSION_IDLE_TIMEOUT: %w", err)
+		}
+		cfg.IdleTimeout = d
+	}
+
+	return cfg, nil
+}
+
+// Set the session cookie, expiring when the session would
+func SetCookie(ctx *gin.Context, cfg Config, key string, maxAge time.Duration) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     CookieName,
+		Value:    key,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   cfg.SecureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Remove the session cookie from the browser
+func ClearCookie(ctx *gin.Context, cfg Config) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   cfg.SecureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -1,13 +1,103 @@
 package session
 
 import (
+	"strings"
 	"time"
+
+	"github.com/gin-gonic/gin"
 )
 
 type Session struct {
-	ID        int64
-	Key       string
+	ID int64
+	// SHA-256 of the session token. The token itself is only ever stored in the user's cookie
+	KeyHash   string
 	UserID    int64
 	LoginTime time.Time
 	LastSeen  time.Time
+	UserAgent string
+	IP        string
+	// Token that must be sent with state changing requests made with this session, to prevent cross-site request forgery
+	CSRFToken string
+	// Organization the user switched to, nil while they are working in their own organization
+	OrganizationID *int64
+}
+
+// Get the ID of the organization the user is working in with this session, which is their own organization unless
+// they have switched to another
+func (s *Session) ActiveOrganizationID(userOrgID int64) int64 {
+	if s.OrganizationID != nil {
+		return *s.OrganizationID
+	}
+	return userOrgID
+}
+
+// Get the session of the logged in user from the request context, or nil if there is none (eg. when using auto-login)
+func FromContext(ctx *gin.Context) *Session {
+	s, exists := ctx.Get("session")
+	if !exists {
+		return nil
+	}
+	return s.(*Session)
+}
+
+func (s *Session) FormattedLoginTime() string {
+	return s.LoginTime.Format("2006/01/02 15:04:05")
+}
+
+func (s *Session) FormattedLastSeen() string {
+	return s.LastSeen.Format("2006/01/02 15:04:05")
+}
+
+// Short human readable description of the browser and OS from the user agent, eg. "Firefox on Windows"
+func (s *Session) Device() string {
+	ua := s.UserAgent
+	browser := "Unknown browser"
+	// order matters, since eg. Edge and Chrome user agents also contain "Safari"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	os := "unknown OS"
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			os = o.name
+			break
+		}
+	}
+
+	return browser + " on " + os
+}
+
+// Returns true if the session has passed either its absolute or idle timeout
+func (s *Session) Expired(cfg Config, now time.Time) bool {
+	return now.Sub(s.LoginTime) > cfg.AbsoluteTimeout || now.Sub(s.LastSeen) > cfg.IdleTimeout
+}
+
+// Returns true if enough time has passed since the last seen time was recorded that it should be updated again
+func (s *Session) NeedsTouch(cfg Config, now time.Time) bool {
+	return now.Sub(s.LastSeen) > cfg.LastSeenInterval
+}
+
+// Time remaining until the session expires, whichever of the absolute or idle timeout comes first
+func (s *Session) Remaining(cfg Config, now time.Time) time.Duration {
+	absolute := s.LoginTime.Add(cfg.AbsoluteTimeout).Sub(now)
+	idle := s.LastSeen.Add(cfg.IdleTimeout).Sub(now)
+	return min(absolute, idle)
 }
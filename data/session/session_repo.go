@@ -3,8 +3,10 @@ package session
 import (
 	"database/sql"
 	"errors"
+	"log"
 	"time"
 
+	"github.com/slimnate/laser-beam/crypto"
 	"github.com/slimnate/laser-beam/data"
 )
 
@@ -22,7 +24,7 @@ func (r *SessionRepository) Migrate() error {
 	query := `
 		CREATE TABLE IF NOT EXISTS sessions(
 			id SERIAL PRIMARY KEY,
-			key CHAR(64) NOT NULL,
+			key_hash CHAR(64) NOT NULL,
 			login_time TIMESTAMP NOT NULL,
 			last_seen_time TIMESTAMP NOT NULL,
 			user_id INTEGER NOT NULL,
@@ -31,16 +33,85 @@ func (r *SessionRepository) Migrate() error {
 	`
 
 	_, err := r.db.Exec(query)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if err := r.migrateKeyHash(); err != nil {
+		return err
+	}
+
+	// add columns introduced after the table was first created
+	queries := []string{
+		"ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent VARCHAR(512) NOT NULL DEFAULT ''",
+		"ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip_address VARCHAR(45) NOT NULL DEFAULT ''",
+		"ALTER TABLE sessions ADD COLUMN IF NOT EXISTS csrf_token VARCHAR(64) NOT NULL DEFAULT ''",
+		"ALTER TABLE sessions ADD COLUMN IF NOT EXISTS organization_id INTEGER REFERENCES organizations(id) ON DELETE SET NULL",
+		"CREATE UNIQUE INDEX IF NOT EXISTS sessions_key_hash_idx ON sessions(key_hash)",
+	}
+	for _, q := range queries {
+		if _, err := r.db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (r *SessionRepository) Create(key string, userID int64) (*Session, error) {
+// Replace the plaintext `key` column used by older versions with `key_hash`. Existing sessions are hashed in place, so
+// users stay logged in since their cookies still hold the original tokens
+func (r *SessionRepository) migrateKeyHash() error {
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'sessions' AND column_name = 'key')"
+	if err := r.db.QueryRow(query).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// must match crypto.HashToken: hex encoded sha256 of the token
+	queries := []string{
+		"ALTER TABLE sessions ADD COLUMN IF NOT EXISTS key_hash CHAR(64)",
+		"UPDATE sessions SET key_hash = encode(sha256(convert_to(key, 'UTF8')), 'hex')",
+		"ALTER TABLE sessions ALTER COLUMN key_hash SET NOT NULL",
+		"ALTER TABLE sessions DROP COLUMN key",
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Create a session for the user, identified by `token`. Only a hash of the token is stored, so the token itself must be
+// kept by the caller (in the session cookie) to look the session up again
+func (r *SessionRepository) Create(token string, userID int64, userAgent string, ip string) (*Session, error) {
 	var lastInsertId int64
 	t := time.Now()
 
-	query := "INSERT INTO sessions(key, login_time, last_seen_time, user_id) values($1, $2, $3, $4) RETURNING id"
+	// user agents are client supplied and unbounded, so truncate to fit the column
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
 
-	err := r.db.QueryRow(query, key, t, t, userID).Scan(&lastInsertId)
+	csrfToken, err := crypto.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	keyHash := crypto.HashToken(token)
+	query := "INSERT INTO sessions(key_hash, login_time, last_seen_time, user_id, user_agent, ip_address, csrf_token) values($1, $2, $3, $4, $5, $6, $7) RETURNING id"
+
+	err = r.db.QueryRow(query, keyHash, t, t, userID, userAgent, ip, csrfToken).Scan(&lastInsertId)
 
 	if err != nil {
 		return nil, err
@@ -48,22 +119,26 @@ func (r *SessionRepository) Create(key string, userID int64) (*Session, error) {
 
 	session := Session{
 		ID:        lastInsertId,
-		Key:       key,
+		KeyHash:   keyHash,
 		LoginTime: t,
 		LastSeen:  t,
 		UserID:    userID,
+		UserAgent: userAgent,
+		IP:        ip,
+		CSRFToken: csrfToken,
 	}
 
 	return &session, nil
 }
 
-func (r *SessionRepository) GetByKey(key string) (*Session, error) {
-	query := "SELECT id, key, login_time, last_seen_time, user_id FROM sessions WHERE key = $1"
+// Get the session identified by the token from a session cookie
+func (r *SessionRepository) GetByToken(token string) (*Session, error) {
+	query := "SELECT id, key_hash, login_time, last_seen_time, user_id, user_agent, ip_address, csrf_token, organization_id FROM sessions WHERE key_hash = $1"
 
-	row := r.db.QueryRow(query, key)
+	row := r.db.QueryRow(query, crypto.HashToken(token))
 
 	var s Session
-	if err := row.Scan(&s.ID, &s.Key, &s.LoginTime, &s.LastSeen, &s.UserID); err != nil {
+	if err := row.Scan(&s.ID, &s.KeyHash, &s.LoginTime, &s.LastSeen, &s.UserID, &s.UserAgent, &s.IP, &s.CSRFToken, &s.OrganizationID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, data.ErrNotExists
 		}
@@ -73,10 +148,11 @@ func (r *SessionRepository) GetByKey(key string) (*Session, error) {
 	return &s, nil
 }
 
-func (r *SessionRepository) DeleteByKey(key string) error {
-	query := "DELETE from sessions where key = $1"
+// Delete the session identified by the token from a session cookie
+func (r *SessionRepository) DeleteByToken(token string) error {
+	query := "DELETE from sessions where key_hash = $1"
 
-	res, err := r.db.Exec(query, key)
+	res, err := r.db.Exec(query, crypto.HashToken(token))
 	if err != nil {
 		return err
 	}
@@ -93,3 +169,102 @@ func (r *SessionRepository) DeleteByKey(key string) error {
 	}
 	return nil
 }
+
+// Get all sessions for a user that haven't expired, most recently used first
+func (r *SessionRepository) AllActiveForUser(userID int64, cfg Config) ([]Session, error) {
+	now := time.Now()
+	query := `SELECT id, key_hash, login_time, last_seen_time, user_id, user_agent, ip_address, csrf_token, organization_id FROM sessions
+		WHERE user_id = $1 AND login_time >= $2 AND last_seen_time >= $3 ORDER BY last_seen_time DESC`
+
+	rows, err := r.db.Query(query, userID, now.Add(-cfg.AbsoluteTimeout), now.Add(-cfg.IdleTimeout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.KeyHash, &s.LoginTime, &s.LastSeen, &s.UserID, &s.UserAgent, &s.IP, &s.CSRFToken, &s.OrganizationID); err != nil {
+			return nil, err
+		}
+		all = append(all, s)
+	}
+	return all, nil
+}
+
+// Delete a single session, only if it belongs to the supplied user
+func (r *SessionRepository) DeleteByIDForUser(id int64, userID int64) error {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return data.ErrDeleteFailed
+	}
+	return nil
+}
+
+// Delete all sessions for a user, logging them out everywhere. Returns the number of sessions deleted
+func (r *SessionRepository) DeleteAllForUser(userID int64) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE user_id = $1", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Set the CSRF token for a session created before CSRF tokens were introduced
+func (r *SessionRepository) SetCSRFToken(id int64, csrfToken string) error {
+	_, err := r.db.Exec("UPDATE sessions SET csrf_token = $1 WHERE id = $2", csrfToken, id)
+	return err
+}
+
+// Set the organization the user is working in with this session, or nil to return to their own organization
+func (r *SessionRepository) SetOrganization(id int64, orgID *int64) error {
+	_, err := r.db.Exec("UPDATE sessions SET organization_id = $1 WHERE id = $2", orgID, id)
+	return err
+}
+
+// Record that the session was used at time `t`
+func (r *SessionRepository) Touch(id int64, t time.Time) error {
+	_, err := r.db.Exec("UPDATE sessions SET last_seen_time = $1 WHERE id = $2", t, id)
+	return err
+}
+
+// Delete all sessions that have passed their absolute or idle timeout. Returns the number of sessions deleted
+func (r *SessionRepository) DeleteExpired(cfg Config) (int64, error) {
+	now := time.Now()
+	res, err := r.db.Exec("DELETE FROM sessions WHERE login_time < $1 OR last_seen_time < $2", now.Add(-cfg.AbsoluteTimeout), now.Add(-cfg.IdleTimeout))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Start a background goroutine that deletes expired sessions every CleanupInterval
+func (r *SessionRepository) StartCleanup(cfg Config) {
+	go func() {
+		ticker := time.NewTicker(CleanupInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			deleted, err := r.DeleteExpired(cfg)
+			if err != nil {
+				log.Println("[sessions] Cleanup error: " + err.Error())
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("[sessions] Cleaned up %d expired sessions", deleted)
+			}
+		}
+	}()
+}
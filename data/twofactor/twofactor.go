@@ -0,0 +1,33 @@
+package twofactor
+
+import "time"
+
+const (
+	// Name shown for accounts in authenticator apps
+	Issuer = "LaserBeam"
+	// Number of recovery codes generated when two-factor authentication is enabled
+	RecoveryCodeCount = 10
+	// How long a user has to enter their code after entering a correct password
+	ChallengeTTL = 5 * time.Minute
+	// Number of incorrect codes allowed before the user has to enter their password again
+	MaxChallengeAttempts = 5
+)
+
+// TOTP credentials for a user. Credentials are created when enrollment starts and only enforced at login once the user
+// has confirmed a code from their authenticator app
+type TwoFactor struct {
+	UserID  int64
+	Secret  string
+	Enabled bool
+	// Time step of the last code accepted, so a code can't be replayed within its validity window
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+// A pending login for a user that has entered a correct password, but still needs to enter a two-factor code
+type Challenge struct {
+	ID        int64
+	UserID    int64
+	ExpiresAt time.Time
+	Attempts  int
+}
@@ -0,0 +1,289 @@
+package twofactor
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/slimnate/laser-beam/data"
+)
+
+type TwoFactorRepository struct {
+	db *sql.DB
+}
+
+func NewTwoFactorRepository(db *sql.DB) *TwoFactorRepository {
+	return &TwoFactorRepository{
+		db: db,
+	}
+}
+
+func (r *TwoFactorRepository) Migrate() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS two_factor(
+			user_id INTEGER PRIMARY KEY,
+			secret VARCHAR(64) NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT false,
+			last_used_step BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS recovery_codes(
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			code_hash CHAR(64) NOT NULL,
+			used_at TIMESTAMP,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS two_factor_challenges(
+			id SERIAL PRIMARY KEY,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			user_id INTEGER NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+	}
+
+	for _, q := range queries {
+		if _, err := r.db.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get the two-factor credentials for a user. Returns data.ErrNotExists if they have never started enrollment
+func (r *TwoFactorRepository) GetByUserID(userID int64) (*TwoFactor, error) {
+	row := r.db.QueryRow("SELECT user_id, secret, enabled, last_used_step, created_at FROM two_factor WHERE user_id = $1", userID)
+
+	var tf TwoFactor
+	if err := row.Scan(&tf.UserID, &tf.Secret, &tf.Enabled, &tf.LastUsedStep, &tf.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrNotExists
+		}
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// Returns true if the user has completed two-factor enrollment
+func (r *TwoFactorRepository) IsEnabled(userID int64) (bool, error) {
+	var enabled bool
+	err := r.db.QueryRow("SELECT EXISTS (SELECT 1 FROM two_factor WHERE user_id = $1 AND enabled)", userID).Scan(&enabled)
+	return enabled, err
+}
+
+// Start (or restart) enrollment with a new secret. Returns data.ErrUpdateFailed if two-factor is already enabled for the
+// user, so an enabled secret can't be silently replaced
+func (r *TwoFactorRepository) StartEnrollment(userID int64, secret string) (*TwoFactor, error) {
+	tf := TwoFactor{
+		UserID:    userID,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+
+	query := `INSERT INTO two_factor(user_id, secret, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at WHERE two_factor.enabled = false`
+	res, err := r.db.Exec(query, tf.UserID, tf.Secret, tf.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, data.ErrUpdateFailed
+	}
+
+	return &tf, nil
+}
+
+// Finish enrollment once the user has confirmed a code, replacing any existing recovery codes with the supplied hashes
+func (r *TwoFactorRepository) Enable(userID int64, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE two_factor SET enabled = true, last_used_step = $1 WHERE user_id = $2 AND enabled = false", step, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrUpdateFailed
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Turn off two-factor authentication for a user, removing their secret and recovery codes
+func (r *TwoFactorRepository) Disable(userID int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM two_factor WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Record that a code for time step `step` was used. Returns data.ErrUpdateFailed if a code for the same or a later step
+// was already used, so concurrent requests can't both use the same code
+func (r *TwoFactorRepository) UseStep(userID int64, step int64) error {
+	res, err := r.db.Exec("UPDATE two_factor SET last_used_step = $1 WHERE user_id = $2 AND last_used_step < $1", step, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrUpdateFailed
+	}
+
+	return nil
+}
+
+// Replace all of a user's recovery codes with the supplied hashes
+func (r *TwoFactorRepository) ReplaceRecoveryCodes(userID int64, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int64, codeHashes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes(user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mark an unused recovery code as used. Returns data.ErrUpdateFailed if the code doesn't exist or was already used
+func (r *TwoFactorRepository) UseRecoveryCode(userID int64, codeHash string) error {
+	res, err := r.db.Exec("UPDATE recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL", time.Now(), userID, codeHash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrUpdateFailed
+	}
+
+	return nil
+}
+
+// Count the recovery codes a user has left
+func (r *TwoFactorRepository) RemainingRecoveryCodes(userID int64) (int, error) {
+	var count int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+// Store a login challenge for a user, identified by the hash of the token sent back to their browser
+func (r *TwoFactorRepository) CreateChallenge(userID int64, tokenHash string) (*Challenge, error) {
+	c := Challenge{
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(ChallengeTTL),
+	}
+
+	// a user only needs one outstanding challenge, so clear out any abandoned ones
+	if _, err := r.db.Exec("DELETE FROM two_factor_challenges WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+
+	query := "INSERT INTO two_factor_challenges(token_hash, user_id, expires_at) VALUES ($1, $2, $3) RETURNING id"
+	if err := r.db.QueryRow(query, tokenHash, c.UserID, c.ExpiresAt).Scan(&c.ID); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// Get an unexpired login challenge with attempts remaining by the hash of its token. Returns data.ErrNotExists if there is none
+func (r *TwoFactorRepository) GetValidChallenge(tokenHash string) (*Challenge, error) {
+	query := "SELECT id, user_id, expires_at, attempts FROM two_factor_challenges WHERE token_hash = $1 AND expires_at > $2 AND attempts < $3"
+	row := r.db.QueryRow(query, tokenHash, time.Now(), MaxChallengeAttempts)
+
+	var c Challenge
+	if err := row.Scan(&c.ID, &c.UserID, &c.ExpiresAt, &c.Attempts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrNotExists
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Record an incorrect code entered for a challenge. Returns the number of attempts remaining
+func (r *TwoFactorRepository) RecordFailedAttempt(id int64) (int, error) {
+	var attempts int
+	err := r.db.QueryRow("UPDATE two_factor_challenges SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts", id).Scan(&attempts)
+	if err != nil {
+		return 0, err
+	}
+	return max(MaxChallengeAttempts-attempts, 0), nil
+}
+
+// Delete a challenge once it has been completed. Returns data.ErrDeleteFailed if it was already used, so concurrent
+// requests can't both complete the same challenge
+func (r *TwoFactorRepository) DeleteChallenge(id int64) error {
+	res, err := r.db.Exec("DELETE FROM two_factor_challenges WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return data.ErrDeleteFailed
+	}
+
+	return nil
+}
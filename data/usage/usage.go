@@ -0,0 +1,69 @@
+package usage
+
+import (
+	"time"
+)
+
+// Numbers of events sent to the API, by what happened to them
+type Counts struct {
+	// events that were saved, which count towards the monthly quota
+	Events int64
+	// events removed by the organization's ingestion rules
+	Dropped int64
+	Sampled int64
+}
+
+// Events an organization sent during a calendar month
+type Usage struct {
+	OrganizationID int64
+	Month          time.Time
+	Counts
+}
+
+// Usage of the current month compared to the organization's limits, along with the previous months
+type Summary struct {
+	Current Usage
+	// events per calendar month, 0 is unlimited
+	Quota int64
+	// events per second each API key can send, 0 is unlimited
+	RateLimit float64
+	Burst     int
+	History   []Usage
+}
+
+// Get the first moment of the calendar month (in UTC) that `t` falls in, which usage is counted by
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Get the first moment of the calendar month after the one `t` falls in, when quotas are reset
+func NextMonthStart(t time.Time) time.Time {
+	return MonthStart(t).AddDate(0, 1, 0)
+}
+
+func (u Usage) FormattedMonth() string {
+	return u.Month.Format("January 2006")
+}
+
+func (s Summary) Unlimited() bool {
+	return s.Quota <= 0
+}
+
+// Percentage of the quota used this month, capped at 100
+func (s Summary) PercentUsed() int {
+	if s.Unlimited() {
+		return 0
+	}
+	return int(min(100, s.Current.Events*100/s.Quota))
+}
+
+// Events that can still be sent this month
+func (s Summary) Remaining() int64 {
+	return max(0, s.Quota-s.Current.Events)
+}
+
+// Date the quota is reset, for display
+func (s Summary) FormattedResetDate() string {
+	return NextMonthStart(s.Current.Month).Format("2006/01/02")
+}
@@ -0,0 +1,121 @@
+package usage
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/slimnate/laser-beam/cache"
+)
+
+// How long the usage checked against the monthly quota is cached. Events sent within this time can go over the quota
+const currentCacheTTL = 5 * time.Second
+
+type UsageRepository struct {
+	db *sql.DB
+	// current usage of each organization checked against its quota, so it isn't loaded for every event
+	cache *cache.Cache[int64, Usage]
+}
+
+func NewUsageRepository(db *sql.DB) *UsageRepository {
+	return &UsageRepository{
+		db:    db,
+		cache: cache.New[int64, Usage]("usage_counters", currentCacheTTL),
+	}
+}
+
+func (r *UsageRepository) Migrate() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS usage_counters(
+			organization_id INTEGER NOT NULL,
+			month DATE NOT NULL,
+			events BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY(organization_id, month),
+			FOREIGN KEY(organization_id) REFERENCES organizations(id) ON DELETE CASCADE
+		)`,
+		"ALTER TABLE usage_counters ADD COLUMN IF NOT EXISTS dropped BIGINT NOT NULL DEFAULT 0",
+		"ALTER TABLE usage_counters ADD COLUMN IF NOT EXISTS sampled BIGINT NOT NULL DEFAULT 0",
+	}
+	for _, q := range queries {
+		if _, err := r.db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Add `delta` to the usage of an organization for the current month
+func (r *UsageRepository) Increment(orgID int64, delta Counts) error {
+	query := `INSERT INTO usage_counters(organization_id, month, events, dropped, sampled) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (organization_id, month) DO UPDATE SET
+			events = usage_counters.events + EXCLUDED.events,
+			dropped = usage_counters.dropped + EXCLUDED.dropped,
+			sampled = usage_counters.sampled + EXCLUDED.sampled`
+	_, err := r.db.Exec(query, orgID, MonthStart(time.Now()), delta.Events, delta.Dropped, delta.Sampled)
+	return err
+}
+
+// Same as Increment, but failures are logged rather than returned, for callers that have already handled the events
+func (r *UsageRepository) TryIncrement(orgID int64, delta Counts) {
+	if err := r.Increment(orgID, delta); err != nil {
+		log.Println("[usage] Failed to count events: " + err.Error())
+	}
+}
+
+// Get the usage of an organization for the current month
+func (r *UsageRepository) Current(orgID int64) (*Usage, error) {
+	u := Usage{OrganizationID: orgID, Month: MonthStart(time.Now())}
+	err := r.db.QueryRow("SELECT events, dropped, sampled FROM usage_counters WHERE organization_id = $1 AND month = $2", orgID, u.Month).
+		Scan(&u.Events, &u.Dropped, &u.Sampled)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Same as Current, but uses the cached usage, for checking the quota while ingesting events
+func (r *UsageRepository) CachedCurrent(orgID int64) (*Usage, error) {
+	u, err := r.cache.Get(orgID, func() (Usage, error) {
+		u, err := r.Current(orgID)
+		if err != nil {
+			return Usage{}, err
+		}
+		return *u, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// usage cached at the end of last month doesn't count towards this month
+	if month := MonthStart(time.Now()); !u.Month.Equal(month) {
+		u = Usage{OrganizationID: orgID, Month: month}
+	}
+	return &u, nil
+}
+
+// Get the usage of an organization for up to `months` calendar months before the current one, newest first. Months
+// without any events are left out
+func (r *UsageRepository) History(orgID int64, months int) ([]Usage, error) {
+	current := MonthStart(time.Now())
+	query := `SELECT organization_id, month, events, dropped, sampled FROM usage_counters
+		WHERE organization_id = $1 AND month < $2 AND month >= $3
+		ORDER BY month DESC`
+
+	rows, err := r.db.Query(query, orgID, current, current.AddDate(0, -months, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []Usage
+	for rows.Next() {
+		var u Usage
+		if err := rows.Scan(&u.OrganizationID, &u.Month, &u.Events, &u.Dropped, &u.Sampled); err != nil {
+			return nil, err
+		}
+		history = append(history, u)
+	}
+	return history, rows.Err()
+}
@@ -1,15 +1,26 @@
 package user
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/data/role"
+)
 
 type User struct {
-	ID             int64
-	Username       string
-	FirstName      string
-	LastName       string
-	Email          string
-	Phone          string
-	AdminStatus    int64 // 0 - normal user, 1 - org admin, 2 - global admin
+	ID        int64
+	Username  string
+	FirstName string
+	LastName  string
+	Email     string
+	Phone     string
+	RoleID    int64
+	Role      *role.Role
+	Active    bool
+	// when the password was last changed, used to enforce the organization's maximum password age
+	PasswordChangedAt time.Time
+	// organization the account belongs to. Users can also be members of other organizations
 	OrganizationID int64
 }
 
@@ -22,15 +33,25 @@ func (u *User) FullName() string {
 	return fmt.Sprintf("%s %s", u.FirstName, u.LastName)
 }
 
-func (u *User) AdminStatusString() string {
-	if u.AdminStatus == 0 {
-		return "User"
-	}
-	if u.AdminStatus == 1 {
-		return "Admin"
-	}
-	if u.AdminStatus == 2 {
-		return "Global Admin"
+// Returns true if the account belongs to the organization, rather than the user being a member from another
+// organization. Account wide changes, like deactivating or deleting the user, can only be made by their own organization
+func (u *User) BelongsTo(orgID int64) bool {
+	return u.OrganizationID == orgID
+}
+
+// Returns the name of the users role for display
+func (u *User) RoleName() string {
+	if u.Role == nil {
+		return "N/A"
 	}
-	return "N/A"
+	return u.Role.Name
+}
+
+func (u *User) HasPermission(p auth.Permission) bool {
+	return u.Role.HasPermission(p)
+}
+
+// Wrapper around `HasPermission` that accepts a plain string, since templates can't convert to auth.Permission
+func (u *User) Can(p string) bool {
+	return u.HasPermission(auth.Permission(p))
 }
@@ -2,21 +2,57 @@ package user
 
 import (
 	"errors"
+	"fmt"
+	"log"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/membership"
+	"github.com/slimnate/laser-beam/data/role"
+	"github.com/slimnate/laser-beam/data/session"
 )
 
+// Validates user data before it is saved
+type Validator interface {
+	ValidateNewUser(u *UserSecret) (valid bool, errors map[string]string)
+	ValidateUserUpdate(u *User) (valid bool, errors map[string]string)
+}
+
 type UserController struct {
-	repo *UserRepository
+	repo           *UserRepository
+	roleRepo       *role.RoleRepository
+	sessionRepo    *session.SessionRepository
+	membershipRepo *membership.MembershipRepository
+	auditRepo      *audit.AuditRepository
+	validator      Validator
 }
 
-func NewUserController(repo *UserRepository) *UserController {
+func NewUserController(repo *UserRepository, roleRepo *role.RoleRepository, sessionRepo *session.SessionRepository, membershipRepo *membership.MembershipRepository, auditRepo *audit.AuditRepository, validator Validator) *UserController {
 	return &UserController{
-		repo: repo,
+		repo:           repo,
+		roleRepo:       roleRepo,
+		sessionRepo:    sessionRepo,
+		membershipRepo: membershipRepo,
+		auditRepo:      auditRepo,
+		validator:      validator,
 	}
 }
 
+// Body of user create and update requests
+type userRequest struct {
+	Username  string
+	Password  string
+	FirstName string
+	LastName  string
+	Email     string
+	Phone     string
+	RoleID    int64
+}
+
 func GetUser(ctx *gin.Context) (*User, error) {
 	userAny, exists := ctx.Get("user")
 	if !exists {
@@ -27,6 +63,52 @@ func GetUser(ctx *gin.Context) (*User, error) {
 	return user, nil
 }
 
+// Get the user referenced by the :user_id path param along with the :org_id path param, making sure they are a member
+// of the authorized organization
+func (c *UserController) getOrgUser(ctx *gin.Context) (*User, int64, error) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return nil, -1, err
+	}
+
+	id, err := strconv.ParseInt(ctx.Param("user_id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": "invalid user_id"})
+		return nil, -1, err
+	}
+
+	u, err := c.repo.GetByIDForOrganization(id, orgID)
+	if err != nil {
+		ctx.AbortWithStatusJSON(404, gin.H{"error": "user not found"})
+		return nil, -1, err
+	}
+
+	if !u.Role.AssignableBy(auth.APIKeyPermissions(ctx)) {
+		ctx.AbortWithStatusJSON(403, gin.H{"error": "not authorized to manage this user"})
+		return nil, -1, errors.New("not authorized to manage this user")
+	}
+
+	return u, orgID, nil
+}
+
+// Get the role with the supplied ID, making sure it can be assigned to users of the organization by the current API key
+func (c *UserController) getAssignableRole(ctx *gin.Context, roleID int64, orgID int64) (*role.Role, error) {
+	r, err := c.roleRepo.GetByIDForOrganization(roleID, orgID)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"errors": gin.H{"RoleID": "Invalid role"}})
+		return nil, err
+	}
+
+	if !r.AssignableBy(auth.APIKeyPermissions(ctx)) {
+		ctx.AbortWithStatusJSON(403, gin.H{"error": "not authorized to assign this role"})
+		return nil, errors.New("not authorized to assign this role")
+	}
+
+	return r, nil
+}
+
+// Handler for GET /org/:org_id/users
 func (c *UserController) List(ctx *gin.Context) {
 	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
 	if err != nil {
@@ -43,6 +125,203 @@ func (c *UserController) List(ctx *gin.Context) {
 	ctx.JSON(200, users)
 }
 
+// Handler for POST /org/:org_id/users
+func (c *UserController) Create(ctx *gin.Context) {
+	orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req userRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, err := c.getAssignableRole(ctx, req.RoleID, orgID)
+	if err != nil {
+		return
+	}
+
+	newUser := UserSecret{
+		User: User{
+			Username:       req.Username,
+			FirstName:      req.FirstName,
+			LastName:       req.LastName,
+			Email:          req.Email,
+			Phone:          req.Phone,
+			RoleID:         r.ID,
+			OrganizationID: orgID,
+		},
+		Password: req.Password,
+	}
+
+	if valid, e := c.validator.ValidateNewUser(&newUser); !valid {
+		ctx.AbortWithStatusJSON(400, gin.H{"errors": e})
+		return
+	}
+
+	newUser.Password, err = crypto.HashPassword(newUser.Password)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := c.repo.Create(newUser)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			ctx.AbortWithStatusJSON(409, gin.H{"errors": gin.H{"Username": "Username is already taken"}})
+			return
+		}
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.auditRepo.TryRecord(audit.FromAPIKey(ctx, orgID, audit.ActionUserCreated).On(audit.TargetUser, created.ID).
+		WithDetails(fmt.Sprintf("%s as %s", created.Username, r.Name)))
+
+	ctx.JSON(200, created)
+}
+
+// Handler for PUT /org/:org_id/users/:user_id
+func (c *UserController) Update(ctx *gin.Context) {
+	u, orgID, err := c.getOrgUser(ctx)
+	if err != nil {
+		return
+	}
+
+	var req userRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	before := *u
+	u.FirstName = req.FirstName
+	u.LastName = req.LastName
+	u.Email = req.Email
+	u.Phone = req.Phone
+
+	// members from other organizations manage their own details, only their role can be changed here
+	if !u.BelongsTo(orgID) && (u.FirstName != before.FirstName || u.LastName != before.LastName || u.Email != before.Email || u.Phone != before.Phone) {
+		ctx.AbortWithStatusJSON(403, gin.H{"error": "only the role of members from other organizations can be changed"})
+		return
+	}
+
+	if valid, e := c.validator.ValidateUserUpdate(u); !valid {
+		ctx.AbortWithStatusJSON(400, gin.H{"errors": e})
+		return
+	}
+
+	if req.RoleID != 0 && req.RoleID != u.RoleID {
+		if _, err := c.getAssignableRole(ctx, req.RoleID, orgID); err != nil {
+			return
+		}
+		if _, err := c.repo.UpdateRole(u.ID, orgID, req.RoleID); err != nil {
+			ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if _, err := c.repo.UpdateUserInfo(u.ID, *u); err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := c.repo.GetByIDForOrganization(u.ID, orgID)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if changes := audit.Diff(before, *updated); len(changes) > 0 {
+		c.auditRepo.TryRecord(audit.FromAPIKey(ctx, orgID, audit.ActionUserUpdated).On(audit.TargetUser, u.ID).WithChanges(changes))
+	}
+
+	ctx.JSON(200, updated)
+}
+
+// Handler for POST /org/:org_id/users/:user_id/deactivate
+func (c *UserController) Deactivate(ctx *gin.Context) {
+	c.setActive(ctx, false)
+}
+
+// Handler for POST /org/:org_id/users/:user_id/activate
+func (c *UserController) Activate(ctx *gin.Context) {
+	c.setActive(ctx, true)
+}
+
+func (c *UserController) setActive(ctx *gin.Context, active bool) {
+	u, orgID, err := c.getOrgUser(ctx)
+	if err != nil {
+		return
+	}
+
+	if !u.BelongsTo(orgID) {
+		ctx.AbortWithStatusJSON(403, gin.H{"error": "members from other organizations can't be deactivated, remove them from the organization instead"})
+		return
+	}
+
+	updated, err := c.repo.SetActive(u.ID, active)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	action := audit.ActionUserActivated
+	if !active {
+		action = audit.ActionUserDeactivated
+	}
+	c.auditRepo.TryRecord(audit.FromAPIKey(ctx, u.OrganizationID, action).On(audit.TargetUser, u.ID))
+
+	// deactivated users are logged out immediately
+	if !active {
+		if _, err := c.sessionRepo.DeleteAllForUser(u.ID); err != nil {
+			log.Println("Unable to delete sessions for deactivated user: " + err.Error())
+		}
+	}
+
+	ctx.JSON(200, updated)
+}
+
+// Handler for DELETE /org/:org_id/users/:user_id. Members from other organizations are removed from the organization,
+// rather than their account being deleted
+func (c *UserController) Delete(ctx *gin.Context) {
+	u, orgID, err := c.getOrgUser(ctx)
+	if err != nil {
+		return
+	}
+
+	if !u.BelongsTo(orgID) {
+		if err := c.membershipRepo.Remove(u.ID, orgID); err != nil {
+			ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.auditRepo.TryRecord(audit.FromAPIKey(ctx, orgID, audit.ActionMemberRemoved).On(audit.TargetUser, u.ID).
+			WithDetails(u.Username))
+
+		ctx.Status(204)
+		return
+	}
+
+	if _, err := c.sessionRepo.DeleteAllForUser(u.ID); err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.repo.Delete(u.ID); err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.auditRepo.TryRecord(audit.FromAPIKey(ctx, u.OrganizationID, audit.ActionUserDeleted).On(audit.TargetUser, u.ID).
+		WithDetails(fmt.Sprintf("%s (%s)", u.Username, u.Email)))
+
+	ctx.Status(204)
+}
+
 func (c *UserController) RenderUser(ctx *gin.Context) {
 	user, err := GetUser(ctx)
 	if err != nil {
@@ -3,8 +3,13 @@ package user
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 
+	"github.com/lib/pq"
+	"github.com/slimnate/laser-beam/auth"
 	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/role"
 )
 
 // Repository
@@ -28,32 +33,148 @@ func (r *UserRepository) Migrate() error {
 		last_name VARCHAR(64),
 		email VARCHAR(128) NOT NULL,
 		phone VARCHAR(20),
-		admin_status INTEGER NOT NULL,
+		role_id INTEGER NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT TRUE,
 		organization_id INTEGER NOT NULL,
+		FOREIGN KEY(role_id) REFERENCES roles(id),
 		FOREIGN KEY(organization_id) REFERENCES organizations(id)
 	)
 	`
 
 	_, err := r.db.Exec(query)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// add columns introduced after the table was first created
+	queries := []string{
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT TRUE",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMP NOT NULL DEFAULT now()",
+		`CREATE TABLE IF NOT EXISTS password_history(
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			password VARCHAR(64) NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+	}
+	for _, q := range queries {
+		if _, err := r.db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return r.migrateAdminStatus()
+}
+
+// Convert the legacy integer admin_status column (0 - normal user, 1 - org admin, 2 - global admin) into a role_id
+// referencing the matching built-in role, then drop the old column. Does nothing if the column no longer exists.
+func (r *UserRepository) migrateAdminStatus() error {
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'admin_status')"
+	if err := r.db.QueryRow(query).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS role_id INTEGER REFERENCES roles(id)",
+		fmt.Sprintf(`UPDATE users SET role_id = roles.id FROM roles
+			WHERE roles.organization_id IS NULL AND roles.name = CASE users.admin_status WHEN 2 THEN '%s' WHEN 1 THEN '%s' ELSE '%s' END`,
+			role.GlobalAdmin, role.OrgAdmin, role.Member),
+		"ALTER TABLE users ALTER COLUMN role_id SET NOT NULL",
+		"ALTER TABLE users DROP COLUMN admin_status",
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
+// Create a user, who is made a member of their organization with their role
 func (r *UserRepository) Create(user UserSecret) (*User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	var lastInsertId int64
-	query := "INSERT INTO users(username, password, first_name, last_name, email, phone, admin_status, organization_id) values ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id"
-	err := r.db.QueryRow(query, user.Username, user.Password, user.FirstName, user.LastName, user.Email, user.Phone, user.AdminStatus, user.OrganizationID).Scan(&lastInsertId)
+	query := "INSERT INTO users(username, password, first_name, last_name, email, phone, role_id, organization_id) values ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id"
+	err = tx.QueryRow(query, user.Username, user.Password, user.FirstName, user.LastName, user.Email, user.Phone, user.RoleID, user.OrganizationID).Scan(&lastInsertId)
 
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+			return nil, data.ErrDuplicate
+		}
+		return nil, err
+	}
+
+	if _, err := tx.Exec("INSERT INTO memberships(user_id, organization_id, role_id) VALUES ($1, $2, $3)", lastInsertId, user.OrganizationID, user.RoleID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.GetByID(lastInsertId)
+}
+
+// Columns selected for every user query, joined with the role assigned to the user
+const userColumns = "u.id, u.username, u.first_name, u.last_name, u.email, u.phone, u.role_id, u.active, u.password_changed_at, u.organization_id, r.name, r.permissions, r.organization_id"
+const userTables = "users u JOIN roles r ON r.id = u.role_id"
+
+// Same as userColumns and userTables, but for the members of an organization, with the role they hold in that
+// organization. Queries must filter on m.organization_id
+const memberColumns = "u.id, u.username, u.first_name, u.last_name, u.email, u.phone, m.role_id, u.active, u.password_changed_at, u.organization_id, r.name, r.permissions, r.organization_id"
+const memberTables = "users u JOIN memberships m ON m.user_id = u.id JOIN roles r ON r.id = m.role_id"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// Scan a row selected with `userColumns`, followed by any additional `extra` columns
+func scanUser(row scanner, extra ...any) (*User, error) {
+	var u User
+	var permissions []string
+	var roleOrgID sql.NullInt64
+	u.Role = &role.Role{}
+
+	dest := []any{&u.ID, &u.Username, &u.FirstName, &u.LastName, &u.Email, &u.Phone, &u.RoleID, &u.Active, &u.PasswordChangedAt, &u.OrganizationID, &u.Role.Name, pq.Array(&permissions), &roleOrgID}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrNotExists
+		}
 		return nil, err
 	}
 
-	user.ID = lastInsertId
+	u.Role.ID = u.RoleID
+	for _, p := range permissions {
+		u.Role.Permissions = append(u.Role.Permissions, auth.Permission(p))
+	}
+	if roleOrgID.Valid {
+		u.Role.OrganizationID = &roleOrgID.Int64
+	}
 
-	return &user.User, nil
+	return &u, nil
 }
 
+// Get all members of an organization, including users of other organizations that have been added to it
 func (r *UserRepository) AllForOrganization(orgID int64) ([]User, error) {
-	rows, err := r.db.Query("SELECT id, username, first_name, last_name, email, phone, admin_status, organization_id FROM users WHERE organization_id = $1", orgID)
+	rows, err := r.db.Query("SELECT "+memberColumns+" FROM "+memberTables+" WHERE m.organization_id = $1 ORDER BY u.id", orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -61,39 +182,73 @@ func (r *UserRepository) AllForOrganization(orgID int64) ([]User, error) {
 
 	var all []User
 	for rows.Next() {
-		var u User
-		if err := rows.Scan(&u.ID, &u.Username, &u.FirstName, &u.LastName, &u.Email, &u.Phone, &u.AdminStatus, &u.OrganizationID); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		all = append(all, u)
+		all = append(all, *u)
 	}
 	return all, nil
 }
 
 func (r *UserRepository) GetByID(id int64) (*User, error) {
-	row := r.db.QueryRow("SELECT id, username, first_name, last_name, email, phone, admin_status, organization_id FROM users WHERE id = $1", id)
+	row := r.db.QueryRow("SELECT "+userColumns+" FROM "+userTables+" WHERE u.id = $1", id)
+	return scanUser(row)
+}
 
-	var u User
-	if err := row.Scan(&u.ID, &u.Username, &u.FirstName, &u.LastName, &u.Email, &u.Phone, &u.AdminStatus, &u.OrganizationID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, data.ErrNotExists
-		}
+// Get a user by ID, only if they are a member of the supplied organization. The user has the role they hold in it
+func (r *UserRepository) GetByIDForOrganization(id int64, orgID int64) (*User, error) {
+	row := r.db.QueryRow("SELECT "+memberColumns+" FROM "+memberTables+" WHERE u.id = $1 AND m.organization_id = $2", id, orgID)
+	return scanUser(row)
+}
+
+// Get all active users with the supplied email address. Email addresses aren't unique, so there may be more than one
+func (r *UserRepository) AllActiveByEmail(email string) ([]User, error) {
+	rows, err := r.db.Query("SELECT "+userColumns+" FROM "+userTables+" WHERE lower(u.email) = lower($1) AND u.active ORDER BY u.id", email)
+	if err != nil {
 		return nil, err
 	}
-	return &u, nil
+	defer rows.Close()
+
+	var all []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, *u)
+	}
+	return all, nil
+}
+
+// Get the oldest user whose account belongs to an organization with the supplied email address, including deactivated
+// users. Members from other organizations aren't included, since their accounts can reach more than the organization
+func (r *UserRepository) GetByEmailForOrganization(email string, orgID int64) (*User, error) {
+	row := r.db.QueryRow("SELECT "+userColumns+" FROM "+userTables+" WHERE lower(u.email) = lower($1) AND u.organization_id = $2 ORDER BY u.id LIMIT 1", email, orgID)
+	return scanUser(row)
 }
 
 func (r *UserRepository) GetByUsername(username string) (*UserSecret, error) {
-	row := r.db.QueryRow("SELECT id, username, password, first_name, last_name, email, phone, admin_status, organization_id FROM users WHERE username = $1", username)
+	row := r.db.QueryRow("SELECT "+userColumns+", u.password FROM "+userTables+" WHERE u.username = $1", username)
 
-	var u UserSecret
-	if err := row.Scan(&u.ID, &u.Username, &u.Password, &u.FirstName, &u.LastName, &u.Email, &u.Phone, &u.AdminStatus, &u.OrganizationID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, data.ErrNotExists
-		}
+	var password string
+	u, err := scanUser(row, &password)
+	if err != nil {
 		return nil, err
 	}
-	return &u, nil
+	return &UserSecret{User: *u, Password: password}, nil
+}
+
+// Get a user by ID along with their password hash, for checking the password of a user that is already logged in
+func (r *UserRepository) GetSecretByID(id int64) (*UserSecret, error) {
+	row := r.db.QueryRow("SELECT "+userColumns+", u.password FROM "+userTables+" WHERE u.id = $1", id)
+
+	var password string
+	u, err := scanUser(row, &password)
+	if err != nil {
+		return nil, err
+	}
+	return &UserSecret{User: *u, Password: password}, nil
 }
 
 func (r *UserRepository) UpdateUserInfo(id int64, new User) (*User, error) {
@@ -124,13 +279,88 @@ func (r *UserRepository) UpdateUserInfo(id int64, new User) (*User, error) {
 	return updated, nil
 }
 
+// Update the username and password hash of a user. If the password changed, the previous hash is kept in the user's
+// password history and the password change time is reset
 func (r *UserRepository) UpdateLoginInfo(id int64, new UserSecret) (*User, error) {
 	if id == 0 {
 		return nil, errors.New("invalid ID to update")
 	}
-	query := "UPDATE users SET username = $1, password = $2 WHERE id = $3"
-	res, err := r.db.Exec(query, new.Username, new.Password, id)
 
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	if err := tx.QueryRow("SELECT password FROM users WHERE id = $1 FOR UPDATE", id).Scan(&previous); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, data.ErrUpdateFailed
+		}
+		return nil, err
+	}
+
+	if previous != new.Password {
+		if _, err := tx.Exec("INSERT INTO password_history(user_id, password, created_at) VALUES ($1, $2, $3)", id, previous, time.Now()); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("UPDATE users SET password_changed_at = $1 WHERE id = $2", time.Now(), id); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE users SET username = $1, password = $2 WHERE id = $3", new.Username, new.Password, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	updated, err := r.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// Get the hashes of the user's current password and up to `n` of their most recent previous passwords
+func (r *UserRepository) PasswordHistory(id int64, n int) ([]string, error) {
+	query := `SELECT password FROM users WHERE id = $1
+		UNION ALL (SELECT password FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2)`
+
+	rows, err := r.db.Query(query, id, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
+// Change the role a user holds in an organization they are a member of. The role in their own organization is also
+// kept on the user
+func (r *UserRepository) UpdateRole(id int64, orgID int64, roleID int64) (*User, error) {
+	if id == 0 {
+		return nil, errors.New("invalid ID to update")
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE memberships SET role_id = $1 WHERE user_id = $2 AND organization_id = $3", roleID, id, orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -144,12 +374,37 @@ func (r *UserRepository) UpdateLoginInfo(id int64, new UserSecret) (*User, error
 		return nil, data.ErrUpdateFailed
 	}
 
-	updated, err := r.GetByID(id)
+	if _, err := tx.Exec("UPDATE users SET role_id = $1 WHERE id = $2 AND organization_id = $3", roleID, id, orgID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.GetByIDForOrganization(id, orgID)
+}
+
+// Activate or deactivate a user. Deactivated users are kept for reference, but can no longer log in
+func (r *UserRepository) SetActive(id int64, active bool) (*User, error) {
+	if id == 0 {
+		return nil, errors.New("invalid ID to update")
+	}
+	res, err := r.db.Exec("UPDATE users SET active = $1 WHERE id = $2", active, id)
 	if err != nil {
 		return nil, err
 	}
 
-	return updated, nil
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, data.ErrUpdateFailed
+	}
+
+	return r.GetByID(id)
 }
 
 func (r *UserRepository) Delete(id int64) error {
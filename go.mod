@@ -7,7 +7,6 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.10.9
 	github.com/nyaruka/phonenumbers v1.2.2
-	github.com/thanhpk/randstr v1.0.6
 	golang.org/x/crypto v0.14.0
 )
 
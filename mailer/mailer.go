@@ -0,0 +1,106 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sends email messages. Implementations are selected with the MAILER env variable, see `FromEnv`
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Create the mailer configured by the MAILER env variable - 'log', 'file' or 'smtp'. Defaults to 'log' if not set
+func FromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		return &LogMailer{}, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("MAIL_DIR must be set when using the 'file' mailer")
+		}
+		return &FileMailer{Dir: dir}, nil
+	case "smtp":
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASS"),
+			From:     from,
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid value supplied for MAILER - '%s' - Must be one of 'log', 'file' or 'smtp'", os.Getenv("MAILER"))
+	}
+}
+
+// Development mailer that prints messages to the application log instead of sending them
+type LogMailer struct{}
+
+func (m *LogMailer) Send(msg Message) error {
+	log.Printf("[mailer] To: %s | Subject: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// Development mailer that writes each message to a new file in `Dir` instead of sending it
+type FileMailer struct {
+	Dir string
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.txt", time.Now().Format("20060102150405.000000"), sanitizeFileName(msg.To))
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", msg.To, msg.Subject, msg.Body)
+
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(content), 0644)
+}
+
+// Replace any characters that aren't safe in a file name
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Sends messages through an SMTP server, using PLAIN auth if a username is configured
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// strip line breaks from headers so they can't be used to inject additional headers
+	headerSafe := strings.NewReplacer("\r", "", "\n", "")
+
+	content := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		headerSafe.Replace(m.From), headerSafe.Replace(msg.To), headerSafe.Replace(msg.Subject), msg.Body)
+
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{msg.To}, []byte(content))
+}
@@ -1,23 +1,50 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/data/audit"
 	"github.com/slimnate/laser-beam/data/event"
+	"github.com/slimnate/laser-beam/data/identityprovider"
+	"github.com/slimnate/laser-beam/data/invitation"
+	"github.com/slimnate/laser-beam/data/loginattempt"
+	"github.com/slimnate/laser-beam/data/membership"
 	"github.com/slimnate/laser-beam/data/organization"
+	"github.com/slimnate/laser-beam/data/passwordpolicy"
+	"github.com/slimnate/laser-beam/data/passwordreset"
+	"github.com/slimnate/laser-beam/data/project"
+	"github.com/slimnate/laser-beam/data/release"
+	"github.com/slimnate/laser-beam/data/role"
+	"github.com/slimnate/laser-beam/data/rule"
+	"github.com/slimnate/laser-beam/data/scrubbing"
 	"github.com/slimnate/laser-beam/data/session"
+	"github.com/slimnate/laser-beam/data/twofactor"
+	"github.com/slimnate/laser-beam/data/usage"
 	"github.com/slimnate/laser-beam/data/user"
+	"github.com/slimnate/laser-beam/mailer"
 	"github.com/slimnate/laser-beam/middleware"
+	"github.com/slimnate/laser-beam/oidc"
+	"github.com/slimnate/laser-beam/ratelimit"
 	"github.com/slimnate/laser-beam/site"
+	"github.com/slimnate/laser-beam/validation"
 )
 
+// How long to wait on shutdown for requests in progress to finish and queued events to be saved
+const ShutdownTimeout = 30 * time.Second
+
 func InitDB() *sql.DB {
 	host := os.Getenv("DB_HOST")
 	port := os.Getenv("DB_PORT")
@@ -42,7 +69,7 @@ func InitDB() *sql.DB {
 	log.Printf("Using APP_ENV: %s", appEnv)
 	if appEnv == "dev" {
 		// dev environment, clear database
-		_, err = db.Exec("DROP TABLE IF EXISTS users, organizations, sessions, events")
+		_, err = db.Exec("DROP TABLE IF EXISTS memberships, users, organizations, sessions, events, projects, releases, usage_counters, ingestion_rules, scrubbing_policies, roles, invitations, password_resets, two_factor, recovery_codes, two_factor_challenges, login_attempts, password_history, password_policies, audit_log, identity_providers, user_identities, sso_logins")
 		if err != nil {
 			log.Fatalf("Error dropping tables: %s", err.Error())
 		}
@@ -95,7 +122,8 @@ func InitDB() *sql.DB {
 
 func InitOrganization(db *sql.DB) (*organization.OrganizationController, *organization.OrganizationRepository) {
 	repo := organization.NewOrganizationRepository(db)
-	controller := organization.NewOrganizationController(repo)
+	// the audit table references organizations, so it is migrated later in InitAudit
+	controller := organization.NewOrganizationController(repo, audit.NewAuditRepository(db), validation.OrganizationValidator{})
 	// migrate
 	if err := repo.Migrate(); err != nil {
 		log.Fatal("[organizations] Migration error: ", err)
@@ -105,7 +133,8 @@ func InitOrganization(db *sql.DB) (*organization.OrganizationController, *organi
 	orgs := []organization.OrganizationSecret{
 		{
 			Organization: organization.Organization{
-				Name: "Global Org",
+				Name:     "Global Org",
+				Platform: true,
 			},
 			Key: "secret1",
 		},
@@ -133,9 +162,68 @@ func InitOrganization(db *sql.DB) (*organization.OrganizationController, *organi
 	return controller, repo
 }
 
-func InitEvent(db *sql.DB) (*event.EventController, *event.EventRepository) {
+func InitProject(db *sql.DB) (*project.ProjectController, *project.ProjectRepository) {
+	repo := project.NewProjectRepository(db)
+	controller := project.NewProjectController(repo, audit.NewAuditRepository(db), validation.ProjectValidator{})
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[projects] Migration error", err)
+	}
+
+	// the applications events are seeded for in InitEvent
+	projects := []project.Project{
+		{Name: "TechNexus", Platform: "web"},
+		{Name: "InnovateX", Platform: "server"},
+		{Name: "CodeWave", Platform: "mobile"},
+	}
+	for orgID := int64(2); orgID <= 3; orgID++ {
+		for _, p := range projects {
+			p.OrganizationID = orgID
+			p.Enabled = true
+			created, err := repo.Create(p, fmt.Sprintf("secret%d-%s", orgID, project.Slugify(p.Name)))
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Created project - id: %d | name: %s | slug: %s | org_id: %d \n", created.ID, created.Name, created.Slug, created.OrganizationID)
+		}
+	}
+
+	return controller, repo
+}
+
+func InitRule(db *sql.DB) *rule.RuleRepository {
+	repo := rule.NewRuleRepository(db)
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[ingestion_rules] Migration error", err)
+	}
+
+	return repo
+}
+
+func InitScrubbing(db *sql.DB) *scrubbing.ScrubbingRepository {
+	repo := scrubbing.NewScrubbingRepository(db)
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[scrubbing_policies] Migration error", err)
+	}
+
+	return repo
+}
+
+func InitEvent(db *sql.DB, projectRepo *project.ProjectRepository, ruleRepo *rule.RuleRepository, usageRepo *usage.UsageRepository, scrubbingRepo *scrubbing.ScrubbingRepository) (*event.EventController, *event.EventRepository, *event.IngestionQueue) {
 	repo := event.NewEventRepository(db)
-	controller := event.NewEventController(repo)
+	// the releases table is migrated later in InitRelease, so it can be filled in from the seeded events
+	releaseRepo := release.NewReleaseRepository(db)
+
+	queueConfig, err := event.QueueConfigFromEnv()
+	if err != nil {
+		log.Fatal("[ingestion] Configuration error: ", err)
+	}
+	log.Printf("Ingestion queue - size: %d | workers: %d | batch size: %d | flush interval: %s", queueConfig.Size, queueConfig.Workers, queueConfig.BatchSize, queueConfig.FlushInterval)
+	queue := event.NewIngestionQueue(repo, releaseRepo, usageRepo, queueConfig)
+
+	controller := event.NewEventController(repo, queue, projectRepo, releaseRepo, usageRepo, ruleRepo, scrubbingRepo, validation.EventValidator{}, audit.NewAuditRepository(db))
 
 	if err := repo.Migrate(); err != nil {
 		log.Fatal("[events] Migration error", err)
@@ -157,7 +245,7 @@ func InitEvent(db *sql.DB) (*event.EventController, *event.EventRepository) {
 		"Error 1005: Email delivery failed. Please check your email server settings.",
 	}
 
-	// loop over org ids - start at org 2, since 1 is the global org and doesn't need events
+	// loop over org ids - start at org 2, since 1 is the platform org and doesn't need events
 	for orgID := 2; orgID <= 3; orgID++ {
 		// loop over events
 		for eventNum := 1; eventNum <= 15; eventNum++ {
@@ -166,6 +254,8 @@ func InitEvent(db *sql.DB) (*event.EventController, *event.EventRepository) {
 				eType   string
 				message string
 				app     string
+				env     string
+				version string
 			)
 
 			// Half of events should be error, other half info
@@ -184,6 +274,18 @@ func InitEvent(db *sql.DB) (*event.EventController, *event.EventRepository) {
 				message = messages[messageCode]
 			}
 
+			// alternate between environments, with the later events coming from a newer release
+			if eventNum%2 == 0 {
+				env = "production"
+			} else {
+				env = "staging"
+			}
+			if eventNum <= 8 {
+				version = "1.0.0"
+			} else {
+				version = "1.1.0"
+			}
+
 			if appCode == 0 {
 				app = "TechNexus"
 			} else if appCode == 1 {
@@ -192,11 +294,19 @@ func InitEvent(db *sql.DB) (*event.EventController, *event.EventRepository) {
 				app = "CodeWave"
 			}
 
+			p, err := projectRepo.GetByApplication(int64(orgID), app)
+			if err != nil {
+				log.Fatal(err)
+			}
+
 			e := event.Event{
 				Name:           name,
-				Application:    app,
+				Application:    p.Name,
+				ProjectID:      &p.ID,
 				Type:           eType,
 				Message:        message,
+				Environment:    env,
+				Release:        version,
 				Time:           time.Now(),
 				OrganizationID: int64(orgID),
 			}
@@ -209,17 +319,67 @@ func InitEvent(db *sql.DB) (*event.EventController, *event.EventRepository) {
 		}
 	}
 
+	repo.StartRetentionCleanup(event.RetentionCleanupInterval)
+	queue.Start()
+
+	return controller, repo, queue
+}
+
+func InitRelease(db *sql.DB, projectRepo *project.ProjectRepository) (*release.ReleaseController, *release.ReleaseRepository) {
+	repo := release.NewReleaseRepository(db)
+	controller := release.NewReleaseController(repo, projectRepo)
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[releases] Migration error", err)
+	}
+
+	return controller, repo
+}
+
+func InitRole(db *sql.DB) (*role.RoleController, *role.RoleRepository) {
+	repo := role.NewRoleRepository(db)
+	controller := role.NewRoleController(repo, audit.NewAuditRepository(db))
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[roles] Migration error", err)
+	}
+
 	return controller, repo
 }
 
-func InitUser(db *sql.DB) (*user.UserController, *user.UserRepository) {
+func InitPasswordPolicy(db *sql.DB) *passwordpolicy.PasswordPolicyRepository {
+	repo := passwordpolicy.NewPasswordPolicyRepository(db)
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[password_policies] Migration error", err)
+	}
+
+	return repo
+}
+
+func InitUser(db *sql.DB, roleRepo *role.RoleRepository, policyRepo *passwordpolicy.PasswordPolicyRepository) (*user.UserController, *user.UserRepository, *membership.MembershipRepository) {
 	repo := user.NewUserRepository(db)
-	controller := user.NewUserController(repo)
+	membershipRepo := membership.NewMembershipRepository(db)
+	controller := user.NewUserController(repo, roleRepo, session.NewSessionRepository(db), membershipRepo, audit.NewAuditRepository(db), validation.UserValidator{Policies: policyRepo})
 
 	if err := repo.Migrate(); err != nil {
 		log.Fatal("[users] Migration error", err)
 	}
 
+	// users are made members of their organization when created, so memberships are migrated before any are seeded
+	if err := membershipRepo.Migrate(); err != nil {
+		log.Fatal("[memberships] Migration error", err)
+	}
+
+	roleIDs := make(map[string]int64)
+	for name := range role.BuiltInRoles {
+		r, err := roleRepo.GetBuiltIn(name)
+		if err != nil {
+			log.Fatalf("Unable to find built-in role '%s': %s", name, err.Error())
+		}
+		roleIDs[name] = r.ID
+	}
+
 	users := []user.UserSecret{
 		{
 			User: user.User{
@@ -228,7 +388,7 @@ func InitUser(db *sql.DB) (*user.UserController, *user.UserRepository) {
 				LastName:       "Global",
 				Email:          "admin1@globalorg.com",
 				Phone:          "1234567890",
-				AdminStatus:    2,
+				RoleID:         roleIDs[role.GlobalAdmin],
 				OrganizationID: 1,
 			},
 			Password: "$2a$15$dRgGBE56DiFg/I2sarfnKOYk6GMHSo/A5U38OIDpjKeePBGlLFqKe",
@@ -241,7 +401,7 @@ func InitUser(db *sql.DB) (*user.UserController, *user.UserRepository) {
 				LastName:       "OrgTwo",
 				Email:          "admin2@org2.com",
 				Phone:          "1234567890",
-				AdminStatus:    1,
+				RoleID:         roleIDs[role.OrgAdmin],
 				OrganizationID: 2,
 			},
 			Password: "$2a$15$221/N0pnu5epRsGzs39JCucTXzNMYh22YHFu5oIW36lJ3bYKghz3K",
@@ -254,7 +414,7 @@ func InitUser(db *sql.DB) (*user.UserController, *user.UserRepository) {
 				LastName:       "OrgTwo",
 				Email:          "user2@org2.com",
 				Phone:          "1234567890",
-				AdminStatus:    0,
+				RoleID:         roleIDs[role.Member],
 				OrganizationID: 2,
 			},
 			Password: "$2a$15$TIeBxsBMN94IxawycrT4Ce1HcomMwBoJHt3wsEX5rE56XCV3slN7e",
@@ -262,6 +422,7 @@ func InitUser(db *sql.DB) (*user.UserController, *user.UserRepository) {
 		},
 	}
 
+	userIDs := make(map[string]int64)
 	for _, user := range users {
 		//Hash user password before storing
 		// hashed, err := crypto.HashPassword(user.Password)
@@ -274,22 +435,163 @@ func InitUser(db *sql.DB) (*user.UserController, *user.UserRepository) {
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Printf("Create user - id: %d | u: %s | name: %s | e: %s | p: %s | admin: %d, org_id: %d \n", created.ID, created.Username, created.FullName(), created.Email, created.Phone, created.AdminStatus, created.OrganizationID)
+		fmt.Printf("Create user - id: %d | u: %s | name: %s | e: %s | p: %s | role: %s, org_id: %d \n", created.ID, created.Username, created.FullName(), created.Email, created.Phone, created.RoleName(), created.OrganizationID)
+		userIDs[created.Username] = created.ID
 	}
 
-	return controller, repo
+	// admin2 also works in org 3, so can switch between organizations
+	m, err := membershipRepo.Add(userIDs["admin2"], 3, roleIDs[role.Member])
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Created membership - user_id: %d | org: %s | role: %s \n", m.UserID, m.OrganizationName, m.Role.Name)
+
+	return controller, repo, membershipRepo
 }
 
-func InitSession(db *sql.DB) *session.SessionRepository {
+func InitSession(db *sql.DB) (*session.SessionRepository, session.Config) {
 	repo := session.NewSessionRepository(db)
 
 	if err := repo.Migrate(); err != nil {
 		log.Fatal("[sessions] Migration error", err)
 	}
 
+	cfg, err := session.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("[sessions] Configuration error: ", err)
+	}
+	log.Printf("Session timeouts - absolute: %s | idle: %s", cfg.AbsoluteTimeout, cfg.IdleTimeout)
+
+	repo.StartCleanup(cfg)
+
+	return repo, cfg
+}
+
+func InitInvitation(db *sql.DB) *invitation.InvitationRepository {
+	repo := invitation.NewInvitationRepository(db)
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[invitations] Migration error", err)
+	}
+
+	return repo
+}
+
+func InitPasswordReset(db *sql.DB) *passwordreset.PasswordResetRepository {
+	repo := passwordreset.NewPasswordResetRepository(db)
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[password_resets] Migration error", err)
+	}
+
+	return repo
+}
+
+func InitTwoFactor(db *sql.DB) *twofactor.TwoFactorRepository {
+	repo := twofactor.NewTwoFactorRepository(db)
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[two_factor] Migration error", err)
+	}
+
+	return repo
+}
+
+func InitLoginAttempt(db *sql.DB) *loginattempt.LoginAttemptRepository {
+	repo := loginattempt.NewLoginAttemptRepository(db)
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[login_attempts] Migration error", err)
+	}
+
+	repo.StartCleanup(session.CleanupInterval)
+
+	return repo
+}
+
+func InitUsage(db *sql.DB) (*usage.UsageRepository, *ratelimit.Limiter, ratelimit.Config) {
+	repo := usage.NewUsageRepository(db)
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[usage_counters] Migration error", err)
+	}
+
+	cfg, err := ratelimit.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("[rate limits] Configuration error: ", err)
+	}
+	log.Printf("Rate limits - rate: %g/s | burst: %d | monthly quota: %d", cfg.Rate, cfg.Burst, cfg.MonthlyQuota)
+
+	limiter := ratelimit.NewLimiter()
+	limiter.StartCleanup(ratelimit.CleanupInterval)
+
+	return repo, limiter, cfg
+}
+
+func InitAudit(db *sql.DB) (*audit.AuditController, *audit.AuditRepository) {
+	repo := audit.NewAuditRepository(db)
+	controller := audit.NewAuditController(repo)
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[audit_log] Migration error", err)
+	}
+
+	return controller, repo
+}
+
+func InitIdentityProvider(db *sql.DB, roleRepo *role.RoleRepository, userRepo *user.UserRepository) *identityprovider.IdentityProviderRepository {
+	repo := identityprovider.NewIdentityProviderRepository(db)
+
+	if err := repo.Migrate(); err != nil {
+		log.Fatal("[identity_providers] Migration error", err)
+	}
+
+	if os.Getenv("APP_ENV") != "dev" {
+		return repo
+	}
+
+	// dev only - org 2 logs in through the mock provider in cmd/mockoidc, which runs on localhost
+	oidc.AllowPrivateAddresses()
+	member, err := roleRepo.GetBuiltIn(role.Member)
+	if err != nil {
+		log.Fatalf("Unable to find built-in role '%s': %s", role.Member, err.Error())
+	}
+	// configured by the org admin, so existing members of org 2 can be linked to the mock provider
+	admin, err := userRepo.GetByUsername("admin2")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	created, err := repo.Save(identityprovider.IdentityProvider{
+		OrganizationID: 2,
+		Name:           "Mock OIDC",
+		Issuer:         "http://localhost:9090",
+		ClientID:       "laserbeam",
+		ClientSecret:   "mock-secret",
+		Domain:         "org2.com",
+		AutoProvision:  true,
+		DefaultRoleID:  member.ID,
+		Enabled:        true,
+		ConfiguredBy:   &admin.ID,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Created identity provider - id: %d | issuer: %s | domain: %s | org_id: %d \n", created.ID, created.Issuer, created.Domain, created.OrganizationID)
+
 	return repo
 }
 
+func InitMailer() mailer.Mailer {
+	m, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal("[mailer] Configuration error: ", err)
+	}
+	log.Printf("Using mailer: %T", m)
+
+	return m
+}
+
 func main() {
 	// Init .env variables
 	err := godotenv.Load(".env")
@@ -300,10 +602,24 @@ func main() {
 	// init database and controllers
 	db := InitDB()
 	orgController, orgRepo := InitOrganization(db)
-	eventController, eventRepo := InitEvent(db)
-	userController, userRepo := InitUser(db)
-	sessionRepo := InitSession(db)
-	siteController := site.NewSiteController(orgRepo, eventRepo, userRepo, sessionRepo)
+	projectController, projectRepo := InitProject(db)
+	ruleRepo := InitRule(db)
+	usageRepo, limiter, limitConfig := InitUsage(db)
+	scrubbingRepo := InitScrubbing(db)
+	eventController, eventRepo, ingestionQueue := InitEvent(db, projectRepo, ruleRepo, usageRepo, scrubbingRepo)
+	releaseController, releaseRepo := InitRelease(db, projectRepo)
+	roleController, roleRepo := InitRole(db)
+	policyRepo := InitPasswordPolicy(db)
+	userController, userRepo, membershipRepo := InitUser(db, roleRepo, policyRepo)
+	sessionRepo, sessionConfig := InitSession(db)
+	invitationRepo := InitInvitation(db)
+	resetRepo := InitPasswordReset(db)
+	twoFactorRepo := InitTwoFactor(db)
+	loginAttemptRepo := InitLoginAttempt(db)
+	auditController, auditRepo := InitAudit(db)
+	identityProviderRepo := InitIdentityProvider(db, roleRepo, userRepo)
+	appMailer := InitMailer()
+	siteController := site.NewSiteController(orgRepo, eventRepo, projectRepo, releaseRepo, userRepo, sessionRepo, membershipRepo, roleRepo, invitationRepo, resetRepo, twoFactorRepo, loginAttemptRepo, policyRepo, auditRepo, identityProviderRepo, usageRepo, ruleRepo, scrubbingRepo, ingestionQueue, appMailer, sessionConfig, limitConfig)
 
 	// init router
 	router := gin.Default()
@@ -314,9 +630,15 @@ func main() {
 
 	// Website routes
 	authGroup := router.Group("")
-	authGroup.Use(middleware.AuthMiddleware(sessionRepo, userRepo), middleware.HTMXMiddleware())
+	authGroup.Use(
+		middleware.AuthMiddleware(sessionRepo, userRepo, membershipRepo, sessionConfig),
+		middleware.HTMXMiddleware(),
+		middleware.CSRFMiddleware(sessionRepo),
+		middleware.RequireTwoFactorEnrollment(orgRepo, twoFactorRepo, "/account/two-factor"),
+		middleware.RequirePasswordChange(policyRepo, "/account/password", "/account/two-factor"),
+	)
 	{
-		authGroup.GET("/", siteController.Index)
+		authGroup.GET("/", middleware.RequirePermission(auth.PermissionViewEvents), siteController.Index)
 		authGroup.GET("/account", siteController.RenderAccount)
 		authGroup.PUT("/account", siteController.UpdateUser)
 		authGroup.POST("/account", siteController.UpdateUser)
@@ -324,38 +646,182 @@ func main() {
 		authGroup.GET("/account/password", siteController.RenderPasswordForm)
 		authGroup.PUT("/account/password", siteController.UpdatePassword)
 		authGroup.POST("/account/password", siteController.UpdatePassword)
-		authGroup.GET("/events", siteController.RenderEvents)
+		authGroup.GET("/account/two-factor", siteController.RenderTwoFactor)
+		authGroup.POST("/account/two-factor", siteController.EnableTwoFactor)
+		authGroup.POST("/account/two-factor/recovery-codes", siteController.RegenerateRecoveryCodes)
+		authGroup.POST("/account/two-factor/disable", siteController.DisableTwoFactor)
+		authGroup.GET("/account/sessions", siteController.RenderSessions)
+		authGroup.DELETE("/account/sessions/:session_id", siteController.RevokeSession)
+		authGroup.POST("/account/sessions/:session_id/revoke", siteController.RevokeSession)
+		authGroup.POST("/account/sessions/revoke-all", siteController.RevokeAllSessions)
+		authGroup.GET("/events", middleware.RequirePermission(auth.PermissionViewEvents), siteController.RenderEvents)
+		authGroup.GET("/events/:event_id", middleware.RequirePermission(auth.PermissionViewEvents), siteController.RenderEventDetails)
+		authGroup.GET("/releases", middleware.RequirePermission(auth.PermissionViewEvents), siteController.RenderReleases)
+		authGroup.GET("/rules", middleware.RequirePermission(auth.PermissionManageOrganization), siteController.RenderRules)
+		authGroup.POST("/rules", middleware.RequirePermission(auth.PermissionManageOrganization), siteController.CreateRule)
+		authGroup.POST("/rules/:rule_id/delete", middleware.RequirePermission(auth.PermissionManageOrganization), siteController.DeleteRule)
+		authGroup.GET("/usage", middleware.RequirePermission(auth.PermissionManageOrganization), siteController.RenderUsage)
+		authGroup.GET("/onboarding", middleware.RequirePermission(auth.PermissionManageOrganization), siteController.RenderOnboarding)
+		authGroup.GET("/audit", middleware.RequirePermission(auth.PermissionViewAuditLog), siteController.RenderAuditLog)
+		authGroup.GET("/audit/export", middleware.RequirePermission(auth.PermissionViewAuditLog), siteController.ExportAuditLog)
+
+		authGroup.GET("/organizations/switcher", siteController.RenderOrganizationSwitcher)
+		authGroup.POST("/organizations/switch", siteController.SwitchOrganization)
+		authGroup.POST("/invite/:token/join", siteController.JoinWithInvitation)
+		authGroup.GET("/platform", middleware.RequirePermission(auth.PermissionManageGlobal), siteController.RenderPlatform)
+
+		// organization settings
+		settingsGroup := authGroup.Group("/settings")
+		settingsGroup.Use(middleware.RequirePermission(auth.PermissionManageOrganization))
+		{
+			settingsGroup.GET("", siteController.RenderSettings)
+			settingsGroup.POST("", siteController.SaveSettings)
+			settingsGroup.POST("/scrubbing", siteController.SaveScrubbingPolicy)
+			settingsGroup.POST("/api-key", siteController.RegenerateAPIKey)
+		}
+
+		// organization applications
+		projectGroup := authGroup.Group("/applications")
+		projectGroup.Use(middleware.RequirePermission(auth.PermissionManageOrganization))
+		{
+			projectGroup.GET("", siteController.RenderProjects)
+			projectGroup.POST("", siteController.CreateProject)
+			projectGroup.GET("/:project_id", siteController.RenderProject)
+			projectGroup.POST("/:project_id", siteController.SaveProject)
+			projectGroup.POST("/:project_id/api-key", siteController.RegenerateProjectKey)
+			projectGroup.POST("/:project_id/delete", siteController.DeleteProject)
+		}
+
+		// organization user management
+		userGroup := authGroup.Group("/users")
+		userGroup.Use(middleware.RequirePermission(auth.PermissionManageUsers))
+		{
+			userGroup.GET("", siteController.RenderUsers)
+			userGroup.GET("/new", siteController.RenderInviteUserForm)
+			userGroup.POST("", siteController.InviteUser)
+			userGroup.GET("/:user_id/edit", siteController.RenderManageUserForm)
+			userGroup.PUT("/:user_id", siteController.UpdateManagedUser)
+			userGroup.POST("/:user_id", siteController.UpdateManagedUser)
+			userGroup.POST("/:user_id/deactivate", siteController.DeactivateUser)
+			userGroup.POST("/:user_id/activate", siteController.ActivateUser)
+			userGroup.DELETE("/:user_id", siteController.DeleteUser)
+			userGroup.POST("/:user_id/delete", siteController.DeleteUser)
+			userGroup.POST("/:user_id/reset-two-factor", siteController.ResetUserTwoFactor)
+			userGroup.POST("/password-policy", middleware.RequirePermission(auth.PermissionManageOrganization), siteController.SavePasswordPolicy)
+			userGroup.POST("/require-two-factor", middleware.RequirePermission(auth.PermissionManageOrganization), siteController.SetRequireTwoFactor)
+			userGroup.POST("/sso", middleware.RequirePermission(auth.PermissionManageOrganization), siteController.SaveIdentityProvider)
+			userGroup.DELETE("/invitations/:invitation_id", siteController.RevokeInvitation)
+			userGroup.POST("/invitations/:invitation_id/revoke", siteController.RevokeInvitation)
+		}
 	}
 
 	router.GET("/login", siteController.RenderLogin)
 	router.POST("/login", siteController.ProcessLogin)
+	router.POST("/login/two-factor", siteController.ProcessLoginTwoFactor)
+	router.GET("/login/sso", siteController.RenderSSOLogin)
+	router.POST("/login/sso", siteController.StartSSOLogin)
+	router.GET("/login/sso/callback", siteController.ProcessSSOCallback)
 	router.GET("/logout", siteController.Logout)
+	router.GET("/password/forgot", siteController.RenderForgotPassword)
+	router.POST("/password/forgot", siteController.RequestPasswordReset)
+	router.GET("/password/reset/:token", siteController.RenderResetPassword)
+	router.POST("/password/reset/:token", siteController.ResetPassword)
+	router.GET("/signup", siteController.RenderSignup)
+	router.POST("/signup", siteController.ProcessSignup)
+	router.GET("/invite/:token", siteController.RenderAcceptInvitation)
+	router.POST("/invite/:token", siteController.AcceptInvitation)
 
 	// API routes
 	apiAuthGroup := router.Group("/api")
-	apiAuthGroup.Use(middleware.ApiAuthMiddleware(orgRepo))
+	// application keys can only send events for their application
+	apiAuthGroup.Use(middleware.ApiAuthMiddleware(orgRepo, projectRepo, "POST /api/org/:org_id/events/"))
 	{
 		// Global auth only routes
 		apiAuthGroup.GET("/org", orgController.List)
+		apiAuthGroup.POST("/org", orgController.Create)
 		apiAuthGroup.GET("/events", eventController.ListGlobal)
+		apiAuthGroup.GET("/ingestion/stats", eventController.QueueStats)
 
 		// org specific routes
 		orgGroup := apiAuthGroup.Group("/org/:org_id")
 		{
 			orgGroup.GET("/", orgController.Details)
+			orgGroup.PUT("/", orgController.Update)
+			orgGroup.DELETE("/", orgController.Delete)
+			orgGroup.POST("/key", orgController.RotateKey)
+			orgGroup.PUT("/limits", orgController.UpdateLimits)
 
 			// event specific routes
 			eventGroup := orgGroup.Group("/events")
 			{
 				eventGroup.GET("/", eventController.List)
 				eventGroup.GET("/:event_id", eventController.Details)
-				eventGroup.POST("/", eventController.Create)
+				eventGroup.POST("/", middleware.RateLimit(limiter, limitConfig, orgRepo, usageRepo), eventController.Create)
 				eventGroup.PUT("/:event_id", eventController.Update)
 			}
-		}
 
-		apiAuthGroup.GET("/users", userController.List)
+			// application routes
+			projectGroup := orgGroup.Group("/projects")
+			{
+				projectGroup.GET("/", projectController.List)
+				projectGroup.POST("/", projectController.Create)
+				projectGroup.PUT("/:project_id", projectController.Update)
+				projectGroup.DELETE("/:project_id", projectController.Delete)
+				projectGroup.POST("/:project_id/key", projectController.RotateKey)
+				projectGroup.GET("/:project_id/releases/", releaseController.List)
+			}
+
+			// user management routes
+			userGroup := orgGroup.Group("/users")
+			{
+				userGroup.GET("/", userController.List)
+				userGroup.POST("/", userController.Create)
+				userGroup.PUT("/:user_id", userController.Update)
+				userGroup.DELETE("/:user_id", userController.Delete)
+				userGroup.POST("/:user_id/deactivate", userController.Deactivate)
+				userGroup.POST("/:user_id/activate", userController.Activate)
+			}
+
+			// audit log routes
+			auditGroup := orgGroup.Group("/audit")
+			{
+				auditGroup.GET("/", auditController.List)
+				auditGroup.GET("/export", auditController.Export)
+			}
+
+			// custom role routes
+			roleGroup := orgGroup.Group("/roles")
+			{
+				roleGroup.GET("/", roleController.List)
+				roleGroup.POST("/", roleController.Create)
+				roleGroup.PUT("/:role_id", roleController.Update)
+				roleGroup.DELETE("/:role_id", roleController.Delete)
+			}
+		}
 	}
 
-	router.Run(":8080")
+	// serve until interrupted, then finish the requests in progress and save the queued events before exiting
+	server := &http.Server{Addr: ":8080", Handler: router}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("[server] Listen error: ", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("[server] Shutdown error: " + err.Error())
+	}
+	if err := ingestionQueue.Drain(shutdownCtx); err != nil {
+		log.Println("[ingestion] Drain error: " + err.Error())
+	}
 }
@@ -1,12 +1,17 @@
 package middleware
 
 import (
+	"slices"
+
 	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/auth"
 	"github.com/slimnate/laser-beam/data/organization"
+	"github.com/slimnate/laser-beam/data/project"
 )
 
-// Middleware to check for a valid auth key, and add the corresponding org id to the request context
-func ApiAuthMiddleware(orgRepo *organization.OrganizationRepository) gin.HandlerFunc {
+// Middleware to check for a valid auth key, and add the corresponding org id to the request context. Keys of an
+// application are only accepted for the `applicationRoutes`, given as "METHOD /full/path" eg. "POST /api/org/:org_id/events/"
+func ApiAuthMiddleware(orgRepo *organization.OrganizationRepository, projectRepo *project.ProjectRepository, applicationRoutes ...string) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		key, exists := ctx.GetQuery("key")
 		if !exists {
@@ -14,19 +19,27 @@ func ApiAuthMiddleware(orgRepo *organization.OrganizationRepository) gin.Handler
 			return
 		}
 
+		ctx.Set("apiKey", key)
+
 		org, err := orgRepo.GetByKey(key)
+		if err == nil {
+			auth.SetAuthorizedOrganization(ctx, org.ID, org.Platform)
+			ctx.Next()
+			return
+		}
+
+		p, err := projectRepo.GetByKey(key)
 		if err != nil {
 			ctx.AbortWithStatusJSON(401, gin.H{"error": "invalid api key"})
 			return
 		}
 
-		// Set flag for global authorization if org ID matches the global org
-		if org.ID == 1 {
-			ctx.Set("authorizedGlobal", true)
+		if !slices.Contains(applicationRoutes, ctx.Request.Method+" "+ctx.FullPath()) {
+			ctx.AbortWithStatusJSON(403, gin.H{"error": "application keys can only be used to send events"})
+			return
 		}
 
-		ctx.Set("apiKey", key)
-		ctx.Set("authorizedOrgID", org.ID)
+		auth.SetAuthorizedProject(ctx, p.OrganizationID, p.ID)
 
 		ctx.Next()
 	}
@@ -1,15 +1,21 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/membership"
 	"github.com/slimnate/laser-beam/data/session"
 	"github.com/slimnate/laser-beam/data/user"
 )
 
-func AuthMiddleware(sessionRepo *session.SessionRepository, userRepo *user.UserRepository) gin.HandlerFunc {
+func AuthMiddleware(sessionRepo *session.SessionRepository, userRepo *user.UserRepository, membershipRepo *membership.MembershipRepository, sessionConfig session.Config) gin.HandlerFunc {
 	autoLoginUser := os.Getenv("AUTO_LOGIN_USER")
 
 	// if auto-login is enabled, we skip checking for any session keys
@@ -24,30 +30,86 @@ func AuthMiddleware(sessionRepo *session.SessionRepository, userRepo *user.UserR
 			}
 
 			ctx.Set("user", &user.User)
+			auth.SetPermissions(ctx, user.Role)
 		}
 	}
 
 	return func(ctx *gin.Context) {
-		sessionKey, err := ctx.Cookie("session_key")
+		sessionKey, err := ctx.Cookie(session.CookieName)
 		if err != nil {
 			ctx.Redirect(302, "/login")
+			ctx.Abort()
 			return
 		}
 
-		session, err := sessionRepo.GetByKey(sessionKey)
+		s, err := sessionRepo.GetByToken(sessionKey)
 		if err != nil {
+			session.ClearCookie(ctx, sessionConfig)
 			ctx.Redirect(302, "/login")
+			ctx.Abort()
 			return
 		}
 
-		user, err := userRepo.GetByID(session.UserID)
+		// expired sessions are removed immediately rather than waiting for the background cleanup
+		now := time.Now()
+		if s.Expired(sessionConfig, now) {
+			if err := sessionRepo.DeleteByToken(sessionKey); err != nil {
+				log.Println("Unable to delete expired session: " + err.Error())
+			}
+			session.ClearCookie(ctx, sessionConfig)
+			ctx.Redirect(302, "/login")
+			ctx.Abort()
+			return
+		}
+
+		user, err := userRepo.GetByID(s.UserID)
 		if err != nil {
 			ctx.AbortWithStatus(401)
 			return
 		}
 
-		// set the userID and orgID on the query context
+		// deactivated users can't use sessions created before they were deactivated
+		if !user.Active {
+			ctx.Redirect(302, "/login")
+			ctx.Abort()
+			return
+		}
+
+		// users working in another organization act with the role they hold in it
+		if s.OrganizationID != nil && *s.OrganizationID != user.OrganizationID {
+			m, err := membershipRepo.Get(user.ID, *s.OrganizationID)
+			if err != nil {
+				if !errors.Is(err, data.ErrNotExists) {
+					log.Println(err.Error())
+					ctx.AbortWithStatus(500)
+					return
+				}
+
+				// they have been removed from the organization, so return them to their own
+				if err := sessionRepo.SetOrganization(s.ID, nil); err != nil {
+					log.Println("Unable to reset session organization: " + err.Error())
+				}
+				s.OrganizationID = nil
+			} else {
+				user.Role = m.Role
+				user.RoleID = m.Role.ID
+			}
+		}
+
+		// slide the idle timeout forward, throttled so every request doesn't write to the db
+		if s.NeedsTouch(sessionConfig, now) {
+			if err := sessionRepo.Touch(s.ID, now); err != nil {
+				log.Println("Unable to update session last seen time: " + err.Error())
+			} else {
+				s.LastSeen = now
+				session.SetCookie(ctx, sessionConfig, sessionKey, s.Remaining(sessionConfig, now))
+			}
+		}
+
+		// set the user, their role and session on the query context
 		ctx.Set("user", user)
+		ctx.Set("session", s)
+		auth.SetPermissions(ctx, user.Role)
 
 		ctx.Next()
 	}
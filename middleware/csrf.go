@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data/session"
+)
+
+const (
+	// Header HTMX requests send the CSRF token in, set with `hx-headers` in the page layout
+	CSRFHeader = "X-CSRF-Token"
+	// Form field plain form submissions send the CSRF token in
+	CSRFFormField = "csrf_token"
+)
+
+const csrfErrorMessage = "Your request could not be verified. Please reload the page and try again."
+
+// Middleware to reject state changing requests that don't include the CSRF token of the current session, either in
+// the CSRFHeader header or the CSRFFormField form field. Must be used after `AuthMiddleware`
+func CSRFMiddleware(sessionRepo *session.SessionRepository) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		s := session.FromContext(ctx)
+		// there is no session when auto-login is enabled, so there is no cookie for a forged request to use
+		if s == nil {
+			ctx.Next()
+			return
+		}
+
+		// sessions created before CSRF tokens were introduced get one on their next request
+		if s.CSRFToken == "" {
+			token, err := crypto.GenerateToken()
+			if err != nil {
+				ctx.AbortWithStatus(500)
+				return
+			}
+			if err := sessionRepo.SetCSRFToken(s.ID, token); err != nil {
+				log.Println("Unable to set session CSRF token: " + err.Error())
+				ctx.AbortWithStatus(500)
+				return
+			}
+			s.CSRFToken = token
+		}
+
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			ctx.Next()
+			return
+		}
+
+		sent := ctx.GetHeader(CSRFHeader)
+		if sent == "" {
+			sent = ctx.PostForm(CSRFFormField)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(s.CSRFToken)) != 1 {
+			log.Printf("[auth] Rejected %s %s from %s with invalid CSRF token", ctx.Request.Method, ctx.Request.URL.Path, ctx.ClientIP())
+			ctx.String(http.StatusForbidden, csrfErrorMessage)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// Get the CSRF token to include in pages rendered for the current request, or an empty string if there is no session
+func CSRFToken(ctx *gin.Context) string {
+	if s := session.FromContext(ctx); s != nil {
+		return s.CSRFToken
+	}
+	return ""
+}
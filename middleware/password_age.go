@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/data/passwordpolicy"
+	"github.com/slimnate/laser-beam/data/user"
+)
+
+// Middleware to send users whose password has passed their organization's maximum age to `changePath` until they change
+// it. Requests to any of the `exempt` paths are allowed through, so other required setup pages can still be reached.
+// Must be used after `AuthMiddleware` and `HTMXMiddleware`
+func RequirePasswordChange(policyRepo *passwordpolicy.PasswordPolicyRepository, changePath string, exempt ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		for _, path := range append(exempt, changePath) {
+			if strings.HasPrefix(ctx.Request.URL.Path, path) {
+				ctx.Next()
+				return
+			}
+		}
+
+		u, err := user.GetUser(ctx)
+		if err != nil {
+			ctx.AbortWithStatus(401)
+			return
+		}
+		policy, err := policyRepo.GetForOrganization(u.OrganizationID)
+		if err != nil {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+
+		if policy.Expired(u.PasswordChangedAt, time.Now()) {
+			if GetHxHeaders(ctx).Request {
+				ctx.Header("HX-Redirect", changePath)
+				ctx.AbortWithStatus(200)
+			} else {
+				ctx.Redirect(302, changePath)
+				ctx.Abort()
+			}
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/auth"
+)
+
+// Middleware to reject requests from logged in users whose role does not grant the supplied permission. Must be used after `AuthMiddleware`
+func RequirePermission(p auth.Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !auth.HasPermission(ctx, p) {
+			ctx.AbortWithStatus(403)
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/data/organization"
+	"github.com/slimnate/laser-beam/data/usage"
+	"github.com/slimnate/laser-beam/ratelimit"
+)
+
+// Middleware to limit how fast each API key can send events, and stop organizations from sending more events than
+// their monthly quota. Must be used after `ApiAuthMiddleware`. Limits and usage are cached, so checking them doesn't
+// query the db for every event
+func RateLimit(limiter *ratelimit.Limiter, cfg ratelimit.Config, orgRepo *organization.OrganizationRepository, usageRepo *usage.UsageRepository) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// requests for organizations the key isn't authorized for are rejected by the handler
+		orgID, err := auth.GetAndAuthorizeOrgIDParam(ctx)
+		if err != nil {
+			ctx.Next()
+			return
+		}
+
+		// the rate limit belongs to the organization that owns the key, which differs from the target for platform keys
+		owner, err := orgRepo.GetCachedByID(auth.AuthorizedOrgID(ctx))
+		if err != nil {
+			ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if ok, wait := limiter.Allow(ctx.GetString("apiKey"), cfg.EffectiveRate(owner.RateLimit), cfg.Burst); !ok {
+			tooManyRequests(ctx, wait, "rate limit exceeded")
+			return
+		}
+
+		org := owner
+		if orgID != owner.ID {
+			if org, err = orgRepo.GetCachedByID(orgID); err != nil {
+				ctx.AbortWithStatusJSON(404, gin.H{"error": "organization not found"})
+				return
+			}
+		}
+
+		if quota := cfg.EffectiveQuota(org.MonthlyQuota); quota > 0 {
+			current, err := usageRepo.CachedCurrent(orgID)
+			if err != nil {
+				ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			if current.Events >= quota {
+				tooManyRequests(ctx, time.Until(usage.NextMonthStart(time.Now())), fmt.Sprintf("monthly quota of %d events exceeded", quota))
+				return
+			}
+		}
+
+		ctx.Next()
+	}
+}
+
+// Respond with 429, telling the client how many seconds to wait before retrying
+func tooManyRequests(ctx *gin.Context, wait time.Duration, message string) {
+	seconds := int64(math.Max(1, math.Ceil(wait.Seconds())))
+	ctx.Header("Retry-After", fmt.Sprint(seconds))
+	ctx.AbortWithStatusJSON(429, gin.H{"error": message})
+}
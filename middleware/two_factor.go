@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"log"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/data/organization"
+	"github.com/slimnate/laser-beam/data/session"
+	"github.com/slimnate/laser-beam/data/twofactor"
+	"github.com/slimnate/laser-beam/data/user"
+)
+
+// Middleware to send users of organizations that require two-factor authentication to `enrollPath` until they have
+// enrolled. Must be used after `AuthMiddleware` and `HTMXMiddleware`
+func RequireTwoFactorEnrollment(orgRepo *organization.OrganizationRepository, twoFactorRepo *twofactor.TwoFactorRepository, enrollPath string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if strings.HasPrefix(ctx.Request.URL.Path, enrollPath) {
+			ctx.Next()
+			return
+		}
+
+		// the requirement of the organization the user is currently working in applies
+		u, err := user.GetUser(ctx)
+		if err != nil {
+			ctx.AbortWithStatus(401)
+			return
+		}
+		orgID := u.OrganizationID
+		if s := session.FromContext(ctx); s != nil {
+			orgID = s.ActiveOrganizationID(u.OrganizationID)
+		}
+
+		org, err := orgRepo.GetByID(orgID)
+		if err != nil {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+
+		if org.RequireTwoFactor {
+			enabled, err := twoFactorRepo.IsEnabled(u.ID)
+			if err != nil {
+				log.Println(err.Error())
+				ctx.AbortWithStatus(500)
+				return
+			}
+
+			if !enabled {
+				if GetHxHeaders(ctx).Request {
+					ctx.Header("HX-Redirect", enrollPath)
+					ctx.AbortWithStatus(200)
+				} else {
+					ctx.Redirect(302, enrollPath)
+					ctx.Abort()
+				}
+				return
+			}
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,174 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Allowed difference between our clock and the provider's when checking token times
+const ClockSkew = time.Minute
+
+// Claims read from a verified ID token
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          Audience `json:"aud"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	GivenName         string   `json:"given_name"`
+	FamilyName        string   `json:"family_name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// The `aud` claim, which providers may send as either a single string or a list
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a Audience) Contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+	return false
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// A single key from a provider's JSON Web Key Set. Only RSA keys are supported
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (k jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// Get the provider's signing key with the supplied key ID. If the token doesn't name a key, the provider must only have one
+func (c *Client) signingKey(kid string) (*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(c.HTTPClient, c.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("unable to fetch signing keys: %w", err)
+	}
+
+	var candidates []jsonWebKey
+	for _, k := range set.Keys {
+		if k.Kty == "RSA" && (k.Use == "" || k.Use == "sig") && (kid == "" || k.Kid == kid) {
+			candidates = append(candidates, k)
+		}
+	}
+	if len(candidates) != 1 {
+		return nil, fmt.Errorf("no unique signing key found for key ID '%s'", kid)
+	}
+
+	return candidates[0].publicKey()
+}
+
+// Verify the signature and claims of an ID token returned by Exchange. The token must be signed by the provider with
+// RS256, issued for this client, unexpired, and contain the nonce sent with the login request
+func (c *Client) VerifyIDToken(raw string, nonce string, now time.Time) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported ID token algorithm '%s'", header.Alg)
+	}
+
+	key, err := c.signingKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("invalid ID token signature")
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != strings.TrimSuffix(c.Issuer, "/"):
+		return nil, errors.New("ID token has the wrong issuer")
+	case !claims.Audience.Contains(c.ClientID):
+		return nil, errors.New("ID token was not issued for this client")
+	case now.After(time.Unix(claims.Expiry, 0).Add(ClockSkew)):
+		return nil, errors.New("ID token has expired")
+	case now.Add(ClockSkew).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, errors.New("ID token was issued in the future")
+	case claims.Nonce != nonce:
+		return nil, errors.New("ID token nonce does not match")
+	case claims.Subject == "":
+		return nil, errors.New("ID token has no subject")
+	}
+
+	return &claims, nil
+}
+
+// Decode a base64url encoded JSON segment of a JWT
+func decodeSegment(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
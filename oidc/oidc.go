@@ -0,0 +1,212 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Timeout for requests made to identity providers
+const RequestTimeout = 10 * time.Second
+
+// Endpoints of an OpenID Connect provider, read from its discovery document
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Client for the authorization code flow against a single provider, using PKCE
+type Client struct {
+	Metadata
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	HTTPClient   *http.Client
+}
+
+// Client for requests to identity providers. Organization admins choose the provider, so by default it refuses to
+// connect to addresses that aren't public, which would let them reach services only the server can
+var httpClient = newHTTPClient(false)
+
+// Allow requests to providers on loopback and private addresses, for providers run locally in the dev environment
+func AllowPrivateAddresses() {
+	httpClient = newHTTPClient(true)
+}
+
+func newHTTPClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: RequestTimeout}
+	if !allowPrivate {
+		// checked after the host is resolved, so a public host name can't resolve to a private address
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("refusing to connect to non-public address %s", host)
+			}
+			return nil
+		}
+	}
+
+	// requests aren't sent through a proxy, since the proxy would make the connection instead of the dialer
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: RequestTimeout, Transport: transport}
+}
+
+// Reserved ranges not covered by the net.IP methods, eg. "this network", carrier-grade NAT and benchmarking addresses
+var reservedNetworks = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4", "64:ff9b::/96"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// Check whether an IP is a public unicast address, rather than loopback, private, link-local (which includes cloud
+// metadata services) or otherwise reserved
+func publicIP(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, n := range reservedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fetch the discovery document of the provider with the supplied issuer URL. The issuer in the document must match
+func Discover(issuer string) (*Metadata, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	var m Metadata
+	if err := getJSON(httpClient, issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("unable to fetch discovery document: %w", err)
+	}
+
+	if strings.TrimSuffix(m.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("issuer mismatch - expected '%s', discovery document has '%s'", issuer, m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing required endpoints")
+	}
+
+	return &m, nil
+}
+
+// Create a client for a provider, discovering its endpoints from the issuer URL
+func NewClient(issuer string, clientID string, clientSecret string, redirectURL string) (*Client, error) {
+	m, err := Discover(issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		Metadata:     *m,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		HTTPClient:   httpClient,
+	}, nil
+}
+
+// Get the URL to send the user to for login. `state` and `nonce` are checked when the user returns, and `verifier` is
+// sent with the code exchange, so the code can't be used by anyone else. `loginHint` is optional, and pre-fills the
+// user's email address at the provider
+func (c *Client) AuthCodeURL(state string, nonce string, verifier string, loginHint string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", c.ClientID)
+	q.Set("redirect_uri", c.RedirectURL)
+	q.Set("scope", "openid email profile")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	if loginHint != "" {
+		q.Set("login_hint", loginHint)
+	}
+
+	sep := "?"
+	if strings.Contains(c.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange an authorization code for the provider's tokens, returning the raw ID token
+func (c *Client) Exchange(code string, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, c.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with status %d: %s", res.StatusCode, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", err
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+
+	return tokens.IDToken, nil
+}
+
+// GET a URL and decode the JSON response into `v`
+func getJSON(client *http.Client, u string, v any) error {
+	res, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("request to %s failed with status %d", u, res.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
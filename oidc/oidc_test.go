@@ -0,0 +1,227 @@
+package oidc
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slimnate/laser-beam/oidc/mockprovider"
+)
+
+const (
+	testClientID     = "laserbeam"
+	testClientSecret = "mock-secret"
+	testRedirectURL  = "http://localhost:8080/login/sso/callback"
+)
+
+// Start a mock provider, and create a client for it through discovery
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	// the mock provider listens on loopback
+	AllowPrivateAddresses()
+	t.Cleanup(func() { httpClient = newHTTPClient(false) })
+
+	var provider *mockprovider.Provider
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	provider, err := mockprovider.New(server.URL, testClientID, testClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewClient(server.URL, testClientID, testClientSecret, testRedirectURL)
+	if err != nil {
+		t.Fatalf("discovery failed: %s", err)
+	}
+	return c
+}
+
+// Approve a login at the mock provider's authorize endpoint, returning the code and state it redirects back with
+func authorize(t *testing.T, c *Client, state string, nonce string, verifier string) (code string, returnedState string) {
+	t.Helper()
+
+	authURL, err := url.Parse(c.AuthCodeURL(state, nonce, verifier, "jane@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := authURL.Query()
+	form.Set("sub", "user-1")
+	form.Set("email", "jane@example.com")
+	form.Set("email_verified", "on")
+	form.Set("given_name", "Jane")
+	form.Set("family_name", "Doe")
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := noRedirect.PostForm(c.AuthorizationEndpoint, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned status %d, expected a redirect", res.StatusCode)
+	}
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(location.String(), testRedirectURL) {
+		t.Fatalf("authorize redirected to %s, expected the client's redirect URL", location)
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestDiscover(t *testing.T) {
+	c := newTestClient(t)
+
+	for name, endpoint := range map[string]string{
+		"authorization": c.AuthorizationEndpoint,
+		"token":         c.TokenEndpoint,
+		"jwks":          c.JWKSURI,
+	} {
+		if !strings.HasPrefix(endpoint, c.Issuer+"/") {
+			t.Errorf("%s endpoint %s isn't served by the issuer %s", name, endpoint, c.Issuer)
+		}
+	}
+
+	if _, err := Discover(c.Issuer + "/other"); err == nil {
+		t.Error("expected discovery of an unknown issuer to fail")
+	}
+}
+
+func TestDiscoverRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if _, err := Discover(server.URL); err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Errorf("expected discovery of a provider on loopback to be refused, got %v", err)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+	for _, tt := range tests {
+		if got := publicIP(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("publicIP(%s) = %t, expected %t", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	c := newTestClient(t)
+
+	code, state := authorize(t, c, "state-1", "nonce-1", "verifier-1")
+	if state != "state-1" {
+		t.Errorf("got state %s, expected state-1", state)
+	}
+
+	idToken, err := c.Exchange(code, "verifier-1")
+	if err != nil {
+		t.Fatalf("exchange failed: %s", err)
+	}
+
+	claims, err := c.VerifyIDToken(idToken, "nonce-1", time.Now())
+	if err != nil {
+		t.Fatalf("verification failed: %s", err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "jane@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if claims.GivenName != "Jane" || claims.FamilyName != "Doe" {
+		t.Errorf("unexpected name claims %+v", claims)
+	}
+
+	// codes can only be exchanged once
+	if _, err := c.Exchange(code, "verifier-1"); err == nil {
+		t.Error("expected a second exchange of the same code to fail")
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	c := newTestClient(t)
+
+	code, _ := authorize(t, c, "state", "nonce", "verifier")
+	if _, err := c.Exchange(code, "another-verifier"); err == nil {
+		t.Error("expected the exchange to fail PKCE verification")
+	}
+}
+
+func TestExchangeWrongSecret(t *testing.T) {
+	c := newTestClient(t)
+
+	code, _ := authorize(t, c, "state", "nonce", "verifier")
+	c.ClientSecret = "wrong-secret"
+	if _, err := c.Exchange(code, "verifier"); err == nil {
+		t.Error("expected the exchange to fail with the wrong client secret")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	c := newTestClient(t)
+
+	code, _ := authorize(t, c, "state", "nonce", "verifier")
+	idToken, err := c.Exchange(code, "verifier")
+	if err != nil {
+		t.Fatalf("exchange failed: %s", err)
+	}
+
+	otherClient := *c
+	otherClient.ClientID = "another-client"
+	otherIssuer := *c
+	otherIssuer.Issuer = "https://idp.example.com"
+
+	parts := strings.Split(idToken, ".")
+	tampered := parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2]))
+
+	tests := []struct {
+		name   string
+		client *Client
+		token  string
+		nonce  string
+		now    time.Time
+	}{
+		{"wrong nonce", c, idToken, "another-nonce", time.Now()},
+		{"missing nonce", c, idToken, "", time.Now()},
+		{"wrong audience", &otherClient, idToken, "nonce", time.Now()},
+		{"wrong issuer", &otherIssuer, idToken, "nonce", time.Now()},
+		{"expired", c, idToken, "nonce", time.Now().Add(time.Hour)},
+		{"issued in the future", c, idToken, "nonce", time.Now().Add(-time.Hour)},
+		{"invalid signature", c, tampered, "nonce", time.Now()},
+		{"malformed", c, "not-a-token", "nonce", time.Now()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.client.VerifyIDToken(tt.token, tt.nonce, tt.now); err == nil {
+				t.Error("expected verification to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const (
+	DefaultRate  = 10 // events per second each API key can send
+	DefaultBurst = 50 // events an API key can send at once before being limited to the rate
+)
+
+type Config struct {
+	Rate  float64 // events per second each API key can send, 0 disables rate limiting
+	Burst int     // size of each API key's bucket
+	// events each organization can send per calendar month, 0 is unlimited
+	MonthlyQuota int64
+}
+
+// Read the rate limit config from the INGEST_RATE_LIMIT, INGEST_RATE_BURST and MONTHLY_EVENT_QUOTA env variables,
+// using the defaults for any that aren't set
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Rate:  DefaultRate,
+		Burst: DefaultBurst,
+	}
+
+	if v := os.Getenv("INGEST_RATE_LIMIT"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 {
+			return cfg, fmt.Errorf("invalid INGEST_RATE_LIMIT: %s", v)
+		}
+		cfg.Rate = rate
+	}
+
+	if v := os.Getenv("INGEST_RATE_BURST"); v != "" {
+		burst, err := strconv.Atoi(v)
+		if err != nil || burst < 1 {
+			return cfg, fmt.Errorf("invalid INGEST_RATE_BURST: %s", v)
+		}
+		cfg.Burst = burst
+	}
+
+	if v := os.Getenv("MONTHLY_EVENT_QUOTA"); v != "" {
+		quota, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || quota < 0 {
+			return cfg, fmt.Errorf("invalid MONTHLY_EVENT_QUOTA: %s", v)
+		}
+		cfg.MonthlyQuota = quota
+	}
+
+	return cfg, nil
+}
+
+// Get the rate of an organization, which uses the configured rate unless it has its own
+func (c Config) EffectiveRate(orgRate int) float64 {
+	if orgRate > 0 {
+		return float64(orgRate)
+	}
+	return c.Rate
+}
+
+// Get the monthly quota of an organization, which uses the configured quota unless it has its own
+func (c Config) EffectiveQuota(orgQuota int64) int64 {
+	if orgQuota > 0 {
+		return orgQuota
+	}
+	return c.MonthlyQuota
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// How often buckets that have refilled are removed from memory
+const CleanupInterval = 10 * time.Minute
+
+type bucket struct {
+	tokens  float64
+	rate    float64
+	burst   int
+	updated time.Time
+}
+
+// Add the tokens earned since the bucket was last updated, up to its burst size
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.burst), b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+	b.updated = now
+}
+
+// Token bucket rate limiter, with a separate bucket for each key. Buckets are kept in memory, so limits apply to each
+// server separately. Safe for concurrent use
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Take a token from the bucket of `key`, which refills at `rate` tokens per second and holds up to `burst`. Returns
+// false along with how long until a token is available if the bucket is empty. A rate of 0 is unlimited
+func (l *Limiter) Allow(key string, rate float64, burst int) (bool, time.Duration) {
+	if rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(burst), updated: now}
+		l.buckets[key] = b
+	}
+
+	// limits can change between requests, eg. when an organization's rate is updated
+	b.rate, b.burst = rate, burst
+	b.refill(now)
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return false, wait
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// Remove the buckets that have refilled, since they behave the same as a new bucket
+func (l *Limiter) Cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Start a background goroutine that removes refilled buckets every `interval`
+func (l *Limiter) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			l.Cleanup()
+		}
+	}()
+}
@@ -0,0 +1,75 @@
+package site
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/user"
+	"github.com/slimnate/laser-beam/mailer"
+)
+
+const incorrectPasswordMessage = "Incorrect password"
+
+// Check the current password entered to confirm a sensitive change to the user's account, so a hijacked session can't be
+// used to take over the account. Returns an error message to show the user if the password wasn't confirmed. Wrong
+// passwords count as failed logins, so the password can't be guessed from a stolen session
+func (s *SiteController) confirmCurrentPassword(ctx *gin.Context, u *user.User) (string, error) {
+	ip := ctx.ClientIP()
+
+	wait, err := s.loginWait(u.Username, ip)
+	if err != nil {
+		return "", err
+	}
+	if wait > 0 {
+		return fmt.Sprintf("Too many failed attempts, please try again in %s", formatWait(wait)), nil
+	}
+
+	secret, err := s.userRepo.GetSecretByID(u.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if !crypto.TestMatch(ctx.PostForm("current_password"), secret.Password) {
+		s.recordLoginAttempt(u.Username, ip, false)
+		s.recordAudit(accountAudit(ctx, u, audit.ActionReauthenticationFailure).WithDetails(ctx.Request.URL.Path))
+		return incorrectPasswordMessage, nil
+	}
+
+	return "", nil
+}
+
+// Start an audit entry for an action performed by a user in the organization they are working in
+func auditBy(ctx *gin.Context, u *user.User, action string) audit.Entry {
+	return audit.New(activeOrganizationID(ctx, u), action, ctx.ClientIP()).By(u.ID, u.Username)
+}
+
+// Start an audit entry for an action a user performed on their own account
+func accountAudit(ctx *gin.Context, u *user.User, action string) audit.Entry {
+	return auditBy(ctx, u, action).On(audit.TargetUser, u.ID)
+}
+
+// Add an entry to the audit log. Failures are logged rather than failing the request, since the change has already been made
+func (s *SiteController) recordAudit(e audit.Entry) {
+	s.auditRepo.TryRecord(e)
+}
+
+// Let the user know about a change to their account by emailing `to`, which should be the address on the account before
+// the change. Sent in the background so a slow mail server doesn't hold up the response
+func (s *SiteController) notifyAccountChange(u *user.User, to string, change string, ip string) {
+	msg := mailer.Message{
+		To:      to,
+		Subject: "Your LaserBeam account was changed",
+		Body: fmt.Sprintf("The following change was made to your LaserBeam account '%s' on %s from %s:\n\n%s\n\nIf you didn't make this change, reset your password here and contact your organization's administrator:\n%s",
+			u.Username, time.Now().Format("2006/01/02 15:04:05 MST"), ip, change, AbsoluteURL("/password/forgot")),
+	}
+
+	go func() {
+		if err := s.mailer.Send(msg); err != nil {
+			log.Printf("Unable to send account change notification for user %d: %s", u.ID, err.Error())
+		}
+	}()
+}
@@ -0,0 +1,91 @@
+package site
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+)
+
+// GET /audit
+func (s *SiteController) RenderAuditLog(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	pageData := PageData{
+		User:         u,
+		Organization: org,
+		Route:        "/audit",
+	}
+
+	// users are listed so the log can be filtered by actor
+	pageData.Users, err = s.userRepo.AllForOrganization(org.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	f, err := audit.ParseFilter(ctx)
+	if err != nil {
+		pageData.Errors = map[string]string{"Filter": err.Error()}
+	}
+	pageData.AuditFilter = &f
+
+	pag, err := data.ParsePaginationRequestOptions(ctx)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	pageData.AuditLog, err = s.auditRepo.AllForOrganization(org.ID, f, pag)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	loc := org.Location()
+	for i := range pageData.AuditLog.Data {
+		pageData.AuditLog.Data[i].Time = pageData.AuditLog.Data[i].Time.In(loc)
+	}
+
+	HxRespond(200, ctx, "audit_log.html", "index.html", pageData)
+}
+
+// GET /audit/export
+func (s *SiteController) ExportAuditLog(ctx *gin.Context) {
+	_, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	f, err := audit.ParseFilter(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(400)
+		return
+	}
+
+	entries, err := s.auditRepo.Export(org.ID, f)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	ctx.Header("Content-Type", "text/csv")
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-log-%s.csv"`, time.Now().Format(audit.DateFormat)))
+	ctx.Status(200)
+
+	if err := audit.WriteCSV(ctx.Writer, entries); err != nil {
+		log.Println(err.Error())
+	}
+}
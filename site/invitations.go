@@ -0,0 +1,311 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/invitation"
+	"github.com/slimnate/laser-beam/data/session"
+	"github.com/slimnate/laser-beam/data/user"
+	"github.com/slimnate/laser-beam/mailer"
+	"github.com/slimnate/laser-beam/validation"
+)
+
+const invalidInvitationMessage = "This invitation is invalid or has expired. Please ask your administrator to send a new one."
+
+// POST /users
+func (s *SiteController) InviteUser(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	roles, err := s.roleRepo.AllForOrganization(org.ID)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	email := ctx.PostForm("email")
+	pageData := PageData{
+		User:         u,
+		Organization: org,
+		ManagedUser:  &user.User{Email: email},
+		Roles:        roles,
+		Route:        "/users/new",
+	}
+
+	valid, e := validation.ValidateInvitation(email)
+
+	r, err := s.getAssignableRole(ctx, u, org)
+	if err != nil {
+		e["Role"] = err.Error()
+		valid = false
+	} else {
+		pageData.ManagedUser.RoleID = r.ID
+	}
+
+	if !valid {
+		pageData.Errors = e
+		HxRespond(200, ctx, "user_invite.html", "index.html", pageData)
+		return
+	}
+
+	token, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	inv, err := s.invitationRepo.Create(invitation.Invitation{
+		Email:          email,
+		RoleID:         r.ID,
+		OrganizationID: org.ID,
+		InvitedByID:    u.ID,
+	}, crypto.HashToken(token))
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	err = s.mailer.Send(mailer.Message{
+		To:      email,
+		Subject: fmt.Sprintf("You've been invited to join %s on LaserBeam", org.Name),
+		Body: fmt.Sprintf("%s has invited you to join %s on LaserBeam.\n\nAccept the invitation and create your account here:\n%s\n\nThis link expires on %s. If you weren't expecting this invitation, you can ignore this email.",
+			u.FullName(), org.Name, AbsoluteURL("/invite/"+token), inv.FormattedExpiry()),
+	})
+	if err != nil {
+		// the invitation can't be used without the emailed token, so remove it
+		log.Println("Unable to send invitation email: " + err.Error())
+		if err := s.invitationRepo.Delete(inv.ID, org.ID); err != nil {
+			log.Println(err.Error())
+		}
+		pageData.Errors = map[string]string{"Email": "Unable to send the invitation email, please try again later"}
+		HxRespond(200, ctx, "user_invite.html", "index.html", pageData)
+		return
+	}
+
+	s.recordAudit(auditBy(ctx, u, audit.ActionUserInvited).On(audit.TargetInvitation, inv.ID).
+		WithDetails(fmt.Sprintf("%s as %s", email, r.Name)))
+
+	s.renderUsers(ctx, u, org, fmt.Sprintf("Invitation sent to %s!", email))
+}
+
+// DELETE /users/invitations/:invitation_id
+func (s *SiteController) RevokeInvitation(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	id, err := strconv.ParseInt(ctx.Param("invitation_id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	if err := s.invitationRepo.Delete(id, org.ID); err != nil {
+		if errors.Is(err, data.ErrDeleteFailed) {
+			ctx.AbortWithStatus(404)
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(auditBy(ctx, u, audit.ActionInvitationRevoked).On(audit.TargetInvitation, id))
+
+	s.renderUsers(ctx, u, org, "Invitation revoked")
+}
+
+// Get the pending invitation for the token in the path, or render an error page if it's invalid
+func (s *SiteController) getInvitation(ctx *gin.Context) (*invitation.Invitation, error) {
+	inv, err := s.invitationRepo.GetPendingByTokenHash(crypto.HashToken(ctx.Param("token")))
+	if err != nil {
+		if !errors.Is(err, data.ErrNotExists) {
+			log.Println(err.Error())
+		}
+		HxRespond(404, ctx, "invite_form.html", "invite.html", gin.H{"Error": invalidInvitationMessage})
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Get the session and user of a visitor to a public page, or nils if they aren't logged in
+func (s *SiteController) loggedInUser(ctx *gin.Context) (*session.Session, *user.User) {
+	token, err := ctx.Cookie(session.CookieName)
+	if err != nil {
+		return nil, nil
+	}
+
+	sess, err := s.sessionRepo.GetByToken(token)
+	if err != nil || sess.Expired(s.sessionConfig, time.Now()) {
+		return nil, nil
+	}
+
+	u, err := s.userRepo.GetByID(sess.UserID)
+	if err != nil || !u.Active {
+		return nil, nil
+	}
+
+	return sess, u
+}
+
+// GET /invite/:token
+func (s *SiteController) RenderAcceptInvitation(ctx *gin.Context) {
+	inv, err := s.getInvitation(ctx)
+	if err != nil {
+		return
+	}
+
+	policy, err := s.policyRepo.GetForOrganization(inv.OrganizationID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	pageData := gin.H{
+		"Invitation":     inv,
+		"Token":          ctx.Param("token"),
+		"User":           &user.User{},
+		"PasswordPolicy": policy,
+	}
+
+	// logged in users can join the organization with their existing account instead of creating a new one
+	if sess, current := s.loggedInUser(ctx); current != nil && !current.BelongsTo(inv.OrganizationID) {
+		pageData["CurrentUser"] = current
+		pageData["CSRFToken"] = sess.CSRFToken
+	}
+
+	ctx.HTML(http.StatusOK, "invite.html", pageData)
+}
+
+// POST /invite/:token/join
+func (s *SiteController) JoinWithInvitation(ctx *gin.Context) {
+	inv, err := s.getInvitation(ctx)
+	if err != nil {
+		return
+	}
+
+	u := ctx.MustGet("user").(*user.User)
+
+	if _, err := s.membershipRepo.Add(u.ID, inv.OrganizationID, inv.RoleID); err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			HxRespond(409, ctx, "invite_form.html", "invite.html", gin.H{"Error": "You are already a member of this organization."})
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// invitations are single use - if another request accepted it first, undo the membership
+	if err := s.invitationRepo.MarkAccepted(inv.ID); err != nil {
+		if err := s.membershipRepo.Remove(u.ID, inv.OrganizationID); err != nil {
+			log.Println(err.Error())
+		}
+		HxRespond(409, ctx, "invite_form.html", "invite.html", gin.H{"Error": invalidInvitationMessage})
+		return
+	}
+
+	s.recordAudit(audit.New(inv.OrganizationID, audit.ActionMemberAdded, ctx.ClientIP()).By(u.ID, u.Username).
+		On(audit.TargetUser, u.ID).WithDetails(fmt.Sprintf("accepted invitation %d", inv.ID)))
+
+	// start working in the organization that was just joined
+	if sess := session.FromContext(ctx); sess != nil {
+		if err := s.sessionRepo.SetOrganization(sess.ID, &inv.OrganizationID); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	HxRedirect(ctx, "/")
+}
+
+// POST /invite/:token
+func (s *SiteController) AcceptInvitation(ctx *gin.Context) {
+	inv, err := s.getInvitation(ctx)
+	if err != nil {
+		return
+	}
+
+	newUser := user.UserSecret{
+		User: user.User{
+			Username:       ctx.PostForm("username"),
+			FirstName:      ctx.PostForm("first_name"),
+			LastName:       ctx.PostForm("last_name"),
+			Email:          inv.Email,
+			Phone:          ctx.PostForm("phone"),
+			RoleID:         inv.RoleID,
+			OrganizationID: inv.OrganizationID,
+		},
+		Password: ctx.PostForm("password"),
+	}
+
+	policy, err := s.policyRepo.GetForOrganization(inv.OrganizationID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	pageData := gin.H{
+		"Invitation":     inv,
+		"Token":          ctx.Param("token"),
+		"User":           &newUser.User,
+		"PasswordPolicy": policy,
+	}
+
+	valid, e := validation.ValidateNewUser(&newUser, ctx.PostForm("confirm_password"), *policy)
+	if !valid {
+		pageData["Errors"] = e
+		HxRespond(200, ctx, "invite_form.html", "invite.html", pageData)
+		return
+	}
+
+	newUser.Password, err = crypto.HashPassword(newUser.Password)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	created, err := s.userRepo.Create(newUser)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			pageData["Errors"] = map[string]string{"Username": "Username is already taken"}
+			HxRespond(200, ctx, "invite_form.html", "invite.html", pageData)
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// invitations are single use - if another request accepted it first, undo the account creation
+	if err := s.invitationRepo.MarkAccepted(inv.ID); err != nil {
+		if err := s.userRepo.Delete(created.ID); err != nil {
+			log.Println(err.Error())
+		}
+		HxRespond(409, ctx, "invite_form.html", "invite.html", gin.H{"Error": invalidInvitationMessage})
+		return
+	}
+
+	s.recordAudit(accountAudit(ctx, created, audit.ActionUserCreated).WithDetails(fmt.Sprintf("accepted invitation %d", inv.ID)))
+
+	HxRespond(200, ctx, "login_form.html", "login.html", gin.H{
+		"Message":  "Your account has been created, please log in to continue",
+		"Username": created.Username,
+	})
+}
@@ -0,0 +1,52 @@
+package site
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/slimnate/laser-beam/data/loginattempt"
+)
+
+// Get how long the client has to wait before attempting to log in as `username` from `ip`, based on recent failures
+// for both. Returns zero if an attempt can be made now
+func (s *SiteController) loginWait(username string, ip string) (time.Duration, error) {
+	now := time.Now()
+
+	userFailures, err := s.loginAttemptRepo.FailuresForUsername(username, loginattempt.UsernamePolicy)
+	if err != nil {
+		return 0, err
+	}
+
+	ipFailures, err := s.loginAttemptRepo.FailuresForIP(ip, loginattempt.IPPolicy)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(loginattempt.UsernamePolicy.Remaining(userFailures, now), loginattempt.IPPolicy.Remaining(ipFailures, now)), nil
+}
+
+// Record the result of a login attempt. Failures are also written to the log so they can be picked up by log monitoring
+func (s *SiteController) recordLoginAttempt(username string, ip string, success bool) {
+	if !success {
+		log.Printf("[auth] Failed login for username '%s' from %s", username, ip)
+	}
+
+	if err := s.loginAttemptRepo.Record(username, ip, success); err != nil {
+		log.Println("Unable to record login attempt: " + err.Error())
+	}
+}
+
+// Format a wait time for display, rounded up to the next second or minute
+func formatWait(d time.Duration) string {
+	if d > time.Minute {
+		minutes := int((d + time.Minute - 1) / time.Minute)
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds == 1 {
+		return "1 second"
+	}
+	return fmt.Sprintf("%d seconds", seconds)
+}
@@ -0,0 +1,78 @@
+package site
+
+import (
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/session"
+	"github.com/slimnate/laser-beam/data/user"
+	"github.com/slimnate/laser-beam/middleware"
+)
+
+// GET /organizations/switcher
+func (s *SiteController) RenderOrganizationSwitcher(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	memberships, err := s.membershipRepo.AllForUser(u.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// always rendered on its own, since it is loaded into the navbar after the page
+	ctx.HTML(200, "org_switcher.html", PageData{
+		User:         u,
+		Organization: org,
+		Memberships:  memberships,
+		CSRFToken:    middleware.CSRFToken(ctx),
+	})
+}
+
+// POST /organizations/switch
+func (s *SiteController) SwitchOrganization(ctx *gin.Context) {
+	u := ctx.MustGet("user").(*user.User)
+
+	sess := session.FromContext(ctx)
+	if sess == nil {
+		// auto-login users have no session to keep the organization on
+		ctx.AbortWithStatus(400)
+		return
+	}
+
+	orgID, err := strconv.ParseInt(ctx.PostForm("organization_id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatus(400)
+		return
+	}
+
+	// switching back to the user's own organization clears the active organization
+	var active *int64
+	if !u.BelongsTo(orgID) {
+		if _, err := s.membershipRepo.Get(u.ID, orgID); err != nil {
+			if errors.Is(err, data.ErrNotExists) {
+				ctx.AbortWithStatus(403)
+				return
+			}
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+		active = &orgID
+	}
+
+	if err := s.sessionRepo.SetOrganization(sess.ID, active); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	HxRedirect(ctx, "/")
+}
@@ -1,9 +1,25 @@
 package site
 
 import (
+	"fmt"
+	"html/template"
+
 	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
 	"github.com/slimnate/laser-beam/data/event"
+	"github.com/slimnate/laser-beam/data/identityprovider"
+	"github.com/slimnate/laser-beam/data/invitation"
+	"github.com/slimnate/laser-beam/data/membership"
 	"github.com/slimnate/laser-beam/data/organization"
+	"github.com/slimnate/laser-beam/data/passwordpolicy"
+	"github.com/slimnate/laser-beam/data/project"
+	"github.com/slimnate/laser-beam/data/release"
+	"github.com/slimnate/laser-beam/data/role"
+	"github.com/slimnate/laser-beam/data/rule"
+	"github.com/slimnate/laser-beam/data/scrubbing"
+	"github.com/slimnate/laser-beam/data/session"
+	"github.com/slimnate/laser-beam/data/twofactor"
+	"github.com/slimnate/laser-beam/data/usage"
 	"github.com/slimnate/laser-beam/data/user"
 )
 
@@ -11,9 +27,52 @@ type PageData struct {
 	User         *user.User
 	Organization *organization.Organization
 	Events       *data.PaginationResponseData[[]event.Event]
-	Route        string
-	Errors       map[string]string
-	Toasts       []string
+	Event        *event.EventDetails
+	Users        []user.User
+	ManagedUser  *user.User // the user being viewed or edited on the user management pages
+	Roles        []role.Role
+	Invitations  []invitation.Invitation
+	Sessions     []session.Session
+	// ID of the session the current request was made with, so it can be highlighted in the session list
+	CurrentSessionID int64
+	TwoFactor        *twofactor.TwoFactor
+	// otpauth:// URI for the secret being enrolled, only set while enrollment is pending
+	TwoFactorURI string
+	// newly generated recovery codes, which are only ever shown once
+	RecoveryCodes          []string
+	RemainingRecoveryCodes int
+	PasswordPolicy         *passwordpolicy.PasswordPolicy
+	ScrubbingPolicy        *scrubbing.ScrubbingPolicy
+	IdentityProvider       *identityprovider.IdentityProvider
+	AuditLog               *data.PaginationResponseData[[]audit.Entry]
+	AuditFilter            *audit.Filter
+	// organization settings shown in the settings form, which differ from Organization when the form had errors
+	OrganizationSettings *organization.Organization
+	// organizations the user is a member of, for the organization switcher
+	Memberships []membership.Membership
+	// applications of the organization, and the one being viewed or edited
+	Projects []project.Project
+	Project  *project.Project
+	// releases of Project, and the environments their event counts can be limited to
+	Releases     []release.Release
+	Environments []string
+	Environment  string
+	// ingestion rules of the organization, and the values of the new rule form
+	Rules []rule.Rule
+	Rule  *rule.Rule
+	// this month's events compared to the organization's quota, along with previous months
+	Usage *usage.Summary
+	// every organization with its usage, only set on the platform admin console
+	Organizations []organization.OrganizationUsage
+	// depth of the ingestion queue and the events it has handled, only set on the platform admin console
+	IngestionQueue *event.QueueStats
+	// API key of the organization, only set on pages that show it to organization admins
+	APIKey string
+	// token for the current session, included in forms and HTMX request headers
+	CSRFToken string
+	Route     string
+	Errors    map[string]string
+	Toasts    []string
 }
 
 func (d PageData) HasError(name string) bool {
@@ -27,3 +86,52 @@ func (d PageData) HasError(name string) bool {
 func (d *PageData) AddToast(s string) {
 	d.Toasts = append(d.Toasts, s)
 }
+
+// Whether the logged in user can use the platform admin console
+func (d PageData) IsPlatformAdmin() bool {
+	return d.User != nil && d.Organization != nil && isPlatformAdmin(d.User, d.Organization)
+}
+
+// URL identity providers redirect back to after login, which organizations have to register with their provider
+func (d PageData) SSOCallbackURL() string {
+	return AbsoluteURL(ssoCallbackPath)
+}
+
+// Actions and target types the audit log can be filtered by
+func (d PageData) AuditActions() []string {
+	return audit.AllActions
+}
+
+func (d PageData) AuditTargetTypes() []string {
+	return audit.AllTargetTypes
+}
+
+// Link to a page of the audit log that keeps the current filter
+func (d PageData) AuditPageLink(p *data.PaginationRequestOptions) template.URL {
+	return template.URL("/audit" + p.OffsetLimitQueryParams() + d.AuditFilter.QueryParams())
+}
+
+// Link to download the entries matching the current filter of the audit log
+func (d PageData) AuditExportLink() template.URL {
+	return template.URL("/audit/export?" + d.AuditFilter.QueryParams())
+}
+
+// Platforms that can be chosen for an application
+func (d PageData) ProjectPlatforms() []string {
+	return project.Platforms
+}
+
+// Actions that can be chosen for an ingestion rule
+func (d PageData) RuleActions() []string {
+	return rule.Actions
+}
+
+// Event fields that rewrite rules can set
+func (d PageData) RuleRewriteFields() []string {
+	return rule.RewriteFields
+}
+
+// URL clients send new events to with the API key shown on the page, which is either the organization's or an application's
+func (d PageData) IngestionURL() string {
+	return AbsoluteURL(fmt.Sprintf("/api/org/%d/events/?key=%s", d.Organization.ID, d.APIKey))
+}
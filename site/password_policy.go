@@ -0,0 +1,81 @@
+package site
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/passwordpolicy"
+	"github.com/slimnate/laser-beam/validation"
+)
+
+// Validate a new password for an existing user against their organization's policy, including the user's password history
+func (s *SiteController) validateNewPassword(userID int64, policy *passwordpolicy.PasswordPolicy, password string, confirmPassword string) (bool, map[string]string, error) {
+	valid, e := validation.ValidatePasswordUpdate(password, confirmPassword, *policy)
+	if !valid || policy.HistoryCount == 0 {
+		return valid, e, nil
+	}
+
+	hashes, err := s.userRepo.PasswordHistory(userID, policy.HistoryCount)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if validation.PasswordReused(password, hashes) {
+		e["Password"] = "You have used this password recently, please choose a different one"
+		return false, e, nil
+	}
+
+	return true, e, nil
+}
+
+// POST /users/password-policy
+func (s *SiteController) SavePasswordPolicy(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// unparseable numbers are left as zero, which fails validation for the minimum length and disables the others
+	minLength, _ := strconv.Atoi(ctx.PostForm("min_length"))
+	historyCount, _ := strconv.Atoi(ctx.PostForm("history_count"))
+	maxAgeDays, _ := strconv.Atoi(ctx.PostForm("max_age_days"))
+
+	policy := passwordpolicy.PasswordPolicy{
+		OrganizationID:   org.ID,
+		MinLength:        minLength,
+		RequireUppercase: ctx.PostForm("require_uppercase") == "on",
+		RequireLowercase: ctx.PostForm("require_lowercase") == "on",
+		RequireDigit:     ctx.PostForm("require_digit") == "on",
+		RequireSymbol:    ctx.PostForm("require_symbol") == "on",
+		RejectCommon:     ctx.PostForm("reject_common") == "on",
+		HistoryCount:     historyCount,
+		MaxAgeDays:       maxAgeDays,
+	}
+
+	if valid, e := validation.ValidatePasswordPolicy(policy); !valid {
+		s.renderUsersPage(ctx, PageData{User: u, Organization: org, PasswordPolicy: &policy, Errors: e})
+		return
+	}
+
+	before, err := s.policyRepo.GetForOrganization(org.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if _, err := s.policyRepo.Save(policy); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if changes := audit.Diff(*before, policy); len(changes) > 0 {
+		s.recordAudit(auditBy(ctx, u, audit.ActionPasswordPolicyUpdated).On(audit.TargetOrganization, org.ID).WithChanges(changes))
+	}
+
+	s.renderUsers(ctx, u, org, "Password policy saved!")
+}
@@ -0,0 +1,177 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/passwordreset"
+	"github.com/slimnate/laser-beam/data/user"
+	"github.com/slimnate/laser-beam/mailer"
+)
+
+const (
+	resetRequestedMessage = "If an account exists for that email address, a link to reset your password has been sent to it."
+	invalidResetMessage   = "This password reset link is invalid or has expired. Please request a new one."
+)
+
+// GET /password/forgot
+func (s *SiteController) RenderForgotPassword(ctx *gin.Context) {
+	ctx.HTML(http.StatusOK, "forgot_password.html", nil)
+}
+
+// POST /password/forgot
+func (s *SiteController) RequestPasswordReset(ctx *gin.Context) {
+	email := strings.TrimSpace(ctx.PostForm("email"))
+
+	users, err := s.userRepo.AllActiveByEmail(email)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// send in the background and always respond with the same message, so neither the response nor its timing can be
+	// used to find out which emails have accounts
+	go func() {
+		for _, u := range users {
+			if err := s.sendPasswordReset(&u); err != nil {
+				log.Printf("Unable to send password reset for user %d: %s", u.ID, err.Error())
+			}
+		}
+	}()
+
+	HxRespond(200, ctx, "forgot_password_form.html", "forgot_password.html", gin.H{"Message": resetRequestedMessage})
+}
+
+// Create a password reset token for the user and email them a link to use it
+func (s *SiteController) sendPasswordReset(u *user.User) error {
+	token, err := crypto.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.resetRepo.Create(u.ID, crypto.HashToken(token)); err != nil {
+		return err
+	}
+
+	return s.mailer.Send(mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your LaserBeam password",
+		Body: fmt.Sprintf("A password reset was requested for your LaserBeam account '%s'.\n\nChoose a new password here:\n%s\n\nThis link expires in %s. If you didn't request a password reset, you can ignore this email.",
+			u.Username, AbsoluteURL("/password/reset/"+token), passwordreset.TTL),
+	})
+}
+
+// Get the valid password reset for the token in the path, or render an error page if it's invalid
+func (s *SiteController) getPasswordReset(ctx *gin.Context) (*passwordreset.PasswordReset, error) {
+	reset, err := s.resetRepo.GetValidByTokenHash(crypto.HashToken(ctx.Param("token")))
+	if err != nil {
+		if !errors.Is(err, data.ErrNotExists) {
+			log.Println(err.Error())
+		}
+		HxRespond(404, ctx, "reset_password_form.html", "reset_password.html", gin.H{"Error": invalidResetMessage})
+		return nil, err
+	}
+	return reset, nil
+}
+
+// GET /password/reset/:token
+func (s *SiteController) RenderResetPassword(ctx *gin.Context) {
+	reset, err := s.getPasswordReset(ctx)
+	if err != nil {
+		return
+	}
+
+	u, err := s.userRepo.GetByID(reset.UserID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	policy, err := s.policyRepo.GetForOrganization(u.OrganizationID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	ctx.HTML(http.StatusOK, "reset_password.html", gin.H{"Token": ctx.Param("token"), "PasswordPolicy": policy})
+}
+
+// POST /password/reset/:token
+func (s *SiteController) ResetPassword(ctx *gin.Context) {
+	reset, err := s.getPasswordReset(ctx)
+	if err != nil {
+		return
+	}
+
+	newPassword := ctx.PostForm("password")
+	confirmPassword := ctx.PostForm("confirm_password")
+
+	u, err := s.userRepo.GetByID(reset.UserID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	policy, err := s.policyRepo.GetForOrganization(u.OrganizationID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	valid, e, err := s.validateNewPassword(u.ID, policy, newPassword, confirmPassword)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+	if !valid {
+		HxRespond(200, ctx, "reset_password_form.html", "reset_password.html", gin.H{"Token": ctx.Param("token"), "Errors": e, "PasswordPolicy": policy})
+		return
+	}
+
+	// reset tokens are single use, so claim it before changing anything
+	if err := s.resetRepo.MarkUsed(reset.ID); err != nil {
+		HxRespond(409, ctx, "reset_password_form.html", "reset_password.html", gin.H{"Error": invalidResetMessage})
+		return
+	}
+
+	p, err := crypto.HashPassword(newPassword)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if _, err := s.userRepo.UpdateLoginInfo(u.ID, user.UserSecret{User: *u, Password: p}); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(accountAudit(ctx, u, audit.ActionPasswordReset))
+	s.notifyAccountChange(u, u.Email, "Your password was reset using a password reset link.", ctx.ClientIP())
+
+	// anyone who was logged in with the old password is logged out, and any other reset links stop working
+	if _, err := s.sessionRepo.DeleteAllForUser(u.ID); err != nil {
+		log.Println("Unable to delete sessions after password reset: " + err.Error())
+	}
+	if err := s.resetRepo.DeleteAllForUser(u.ID); err != nil {
+		log.Println("Unable to delete password resets after password reset: " + err.Error())
+	}
+
+	HxRespond(200, ctx, "login_form.html", "login.html", gin.H{
+		"Message":  "Your password has been reset, please log in to continue",
+		"Username": u.Username,
+	})
+}
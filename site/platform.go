@@ -0,0 +1,47 @@
+package site
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/data/organization"
+	"github.com/slimnate/laser-beam/data/user"
+)
+
+// Platform admins are users with the global.manage permission in a platform organization. The permission alone isn't
+// enough, since it can be held by users of other organizations
+func isPlatformAdmin(u *user.User, org *organization.Organization) bool {
+	return org.Platform && u.HasPermission(auth.PermissionManageGlobal)
+}
+
+// GET /platform
+func (s *SiteController) RenderPlatform(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if !isPlatformAdmin(u, org) {
+		ctx.AbortWithStatus(403)
+		return
+	}
+
+	orgs, err := s.orgRepo.AllWithUsage()
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	queueStats := s.ingestionQueue.Stats()
+
+	HxRespond(200, ctx, "platform.html", "index.html", PageData{
+		User:           u,
+		Organization:   org,
+		Organizations:  orgs,
+		IngestionQueue: &queueStats,
+		Route:          "/platform",
+	})
+}
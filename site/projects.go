@@ -0,0 +1,247 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/organization"
+	"github.com/slimnate/laser-beam/data/project"
+	"github.com/slimnate/laser-beam/validation"
+)
+
+// Read the application form. Unparseable retention periods are left as -1, which fails validation
+func projectFromForm(ctx *gin.Context, p project.Project) project.Project {
+	retentionDays, err := strconv.Atoi(ctx.PostForm("retention_days"))
+	if err != nil {
+		retentionDays = -1
+	}
+
+	p.Name = ctx.PostForm("name")
+	p.Slug = ctx.PostForm("slug")
+	p.Platform = ctx.PostForm("platform")
+	p.Enabled = ctx.PostForm("enabled") == "on"
+	p.RetentionDays = retentionDays
+	return p
+}
+
+// Get the application from the project_id path param, only if it belongs to the organization
+func (s *SiteController) getOrgProject(ctx *gin.Context, org *organization.Organization) (*project.Project, error) {
+	id, err := strconv.ParseInt(ctx.Param("project_id"), 10, 64)
+	if err != nil {
+		return nil, data.ErrNotExists
+	}
+	return s.projectRepo.GetByIDForOrganization(id, org.ID)
+}
+
+// Render the application list, showing the toast if one is supplied. The new application form shows pageData.Project
+// when set, so invalid values can be corrected
+func (s *SiteController) renderProjects(ctx *gin.Context, pageData PageData, toast string) {
+	projects, err := s.projectRepo.AllForOrganization(pageData.Organization.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	pageData.Projects = projects
+	pageData.Route = "/applications"
+	if pageData.Project == nil {
+		pageData.Project = &project.Project{Platform: project.DefaultPlatform, Enabled: true}
+	}
+	if toast != "" {
+		pageData.AddToast(toast)
+	}
+
+	HxRespond(200, ctx, "projects.html", "index.html", pageData)
+}
+
+// Render the settings of an application along with its API key, showing the toast if one is supplied
+func (s *SiteController) renderProject(ctx *gin.Context, pageData PageData, id int64, toast string) {
+	key, err := s.projectRepo.GetKey(id, pageData.Organization.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	pageData.APIKey = key
+	pageData.Route = "/applications/:project_id"
+	if toast != "" {
+		pageData.AddToast(toast)
+	}
+
+	HxRespond(200, ctx, "project_settings.html", "index.html", pageData)
+}
+
+// GET /applications
+func (s *SiteController) RenderProjects(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.renderProjects(ctx, PageData{User: u, Organization: org}, "")
+}
+
+// POST /applications
+func (s *SiteController) CreateProject(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	p := projectFromForm(ctx, project.Project{OrganizationID: org.ID})
+	pageData := PageData{User: u, Organization: org, Project: &p}
+
+	if valid, e := validation.ValidateProject(&p); !valid {
+		pageData.Errors = e
+		s.renderProjects(ctx, pageData, "")
+		return
+	}
+
+	key, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	created, err := s.projectRepo.Create(p, key)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			pageData.Errors = map[string]string{"Name": "An application with this name or slug already exists"}
+			s.renderProjects(ctx, pageData, "")
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(auditBy(ctx, u, audit.ActionProjectCreated).On(audit.TargetProject, created.ID).WithDetails(created.Name))
+
+	s.renderProject(ctx, PageData{User: u, Organization: org, Project: created}, created.ID, fmt.Sprintf("Application '%s' created!", created.Name))
+}
+
+// GET /applications/:project_id
+func (s *SiteController) RenderProject(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	p, err := s.getOrgProject(ctx, org)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	s.renderProject(ctx, PageData{User: u, Organization: org, Project: p}, p.ID, "")
+}
+
+// POST /applications/:project_id
+func (s *SiteController) SaveProject(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	existing, err := s.getOrgProject(ctx, org)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	p := projectFromForm(ctx, *existing)
+	pageData := PageData{User: u, Organization: org, Project: &p}
+
+	if valid, e := validation.ValidateProject(&p); !valid {
+		pageData.Errors = e
+		s.renderProject(ctx, pageData, existing.ID, "")
+		return
+	}
+
+	updated, err := s.projectRepo.Update(existing.ID, org.ID, p)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			pageData.Errors = map[string]string{"Name": "An application with this name or slug already exists"}
+			s.renderProject(ctx, pageData, existing.ID, "")
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if changes := audit.Diff(*existing, *updated); len(changes) > 0 {
+		s.recordAudit(auditBy(ctx, u, audit.ActionProjectUpdated).On(audit.TargetProject, updated.ID).WithChanges(changes))
+	}
+
+	s.renderProject(ctx, PageData{User: u, Organization: org, Project: updated}, updated.ID, "Application saved!")
+}
+
+// POST /applications/:project_id/api-key
+func (s *SiteController) RegenerateProjectKey(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	p, err := s.getOrgProject(ctx, org)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	key, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// the old key stops working immediately
+	if err := s.projectRepo.SetKey(p.ID, org.ID, key); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(auditBy(ctx, u, audit.ActionProjectKeyChanged).On(audit.TargetProject, p.ID))
+
+	s.renderProject(ctx, PageData{User: u, Organization: org, Project: p}, p.ID, "API key regenerated! Update the application to use the new key.")
+}
+
+// POST /applications/:project_id/delete
+func (s *SiteController) DeleteProject(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	p, err := s.getOrgProject(ctx, org)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	if err := s.projectRepo.Delete(p.ID, org.ID); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(auditBy(ctx, u, audit.ActionProjectDeleted).On(audit.TargetProject, p.ID).WithDetails(p.Name))
+
+	s.renderProjects(ctx, PageData{User: u, Organization: org}, fmt.Sprintf("Application '%s' deleted", p.Name))
+}
@@ -0,0 +1,65 @@
+package site
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /releases
+func (s *SiteController) RenderReleases(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	projects, err := s.projectRepo.AllForOrganization(org.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	environments, err := s.eventRepo.GetDistinctValues(org.ID, "environment")
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	pageData := PageData{
+		User:         u,
+		Organization: org,
+		Projects:     projects,
+		Environments: environments,
+		Environment:  ctx.Query("environment"),
+		Route:        "/releases",
+	}
+
+	// show the chosen application, or the first one if none was chosen
+	projectID, _ := strconv.ParseInt(ctx.Query("application"), 10, 64)
+	for i := range projects {
+		if projects[i].ID == projectID || (projectID == 0 && i == 0) {
+			pageData.Project = &projects[i]
+		}
+	}
+
+	if pageData.Project != nil {
+		pageData.Releases, err = s.releaseRepo.AllForProject(pageData.Project.ID, pageData.Environment)
+		if err != nil {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+
+		loc := org.Location()
+		for i := range pageData.Releases {
+			pageData.Releases[i].FirstSeen = pageData.Releases[i].FirstSeen.In(loc)
+			pageData.Releases[i].LastSeen = pageData.Releases[i].LastSeen.In(loc)
+		}
+	}
+
+	HxRespond(200, ctx, "releases.html", "index.html", pageData)
+}
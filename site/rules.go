@@ -0,0 +1,153 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/rule"
+	"github.com/slimnate/laser-beam/validation"
+)
+
+// Read the new rule form. Unparseable sample rates are left as -1, which fails validation
+func ruleFromForm(ctx *gin.Context, r rule.Rule) rule.Rule {
+	sampleRate, err := strconv.Atoi(ctx.PostForm("sample_rate"))
+	if err != nil {
+		sampleRate = -1
+	}
+
+	r.Type = ctx.PostForm("type")
+	r.NamePattern = ctx.PostForm("name_pattern")
+	r.Tag = ctx.PostForm("tag")
+	r.Action = ctx.PostForm("action")
+	r.SampleRate = sampleRate
+	r.Field = ctx.PostForm("field")
+	r.Value = ctx.PostForm("value")
+	return r
+}
+
+// Render the ingestion rules of the organization, showing the toast if one is supplied. The new rule form shows
+// pageData.Rule when set, so invalid values can be corrected
+func (s *SiteController) renderRules(ctx *gin.Context, pageData PageData, toast string) {
+	rules, err := s.ruleRepo.AllForOrganization(pageData.Organization.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	projects, err := s.projectRepo.AllForOrganization(pageData.Organization.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	pageData.Rules = rules
+	pageData.Projects = projects
+	pageData.Route = "/rules"
+	if pageData.Rule == nil {
+		pageData.Rule = &rule.Rule{Action: rule.ActionSample, SampleRate: 10}
+	}
+	if toast != "" {
+		pageData.AddToast(toast)
+	}
+
+	HxRespond(200, ctx, "rules.html", "index.html", pageData)
+}
+
+// GET /rules
+func (s *SiteController) RenderRules(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.renderRules(ctx, PageData{User: u, Organization: org}, "")
+}
+
+// POST /rules
+func (s *SiteController) CreateRule(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	r := ruleFromForm(ctx, rule.Rule{OrganizationID: org.ID})
+	pageData := PageData{User: u, Organization: org, Rule: &r}
+
+	valid, e := validation.ValidateRule(&r)
+
+	// rules can only be limited to the organization's own applications
+	if projectID, err := strconv.ParseInt(ctx.PostForm("application"), 10, 64); err == nil {
+		p, err := s.projectRepo.GetByIDForOrganization(projectID, org.ID)
+		if err != nil {
+			e["Application"] = "Application not found"
+			valid = false
+		} else {
+			r.ProjectID = &p.ID
+			r.Application = p.Name
+		}
+	}
+
+	if !valid {
+		pageData.Errors = e
+		s.renderRules(ctx, pageData, "")
+		return
+	}
+
+	created, err := s.ruleRepo.Create(r)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(auditBy(ctx, u, audit.ActionRuleCreated).On(audit.TargetRule, created.ID).
+		WithDetails(fmt.Sprintf("%s: %s", created.FormattedConditions(), created.FormattedAction())))
+
+	s.renderRules(ctx, PageData{User: u, Organization: org}, "Rule created!")
+}
+
+// POST /rules/:rule_id/delete
+func (s *SiteController) DeleteRule(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	id, err := strconv.ParseInt(ctx.Param("rule_id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	r, err := s.ruleRepo.GetByIDForOrganization(id, org.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrNotExists) {
+			ctx.AbortWithStatus(404)
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if err := s.ruleRepo.Delete(r.ID, org.ID); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(auditBy(ctx, u, audit.ActionRuleDeleted).On(audit.TargetRule, r.ID).
+		WithDetails(fmt.Sprintf("%s: %s", r.FormattedConditions(), r.FormattedAction())))
+
+	s.renderRules(ctx, PageData{User: u, Organization: org}, "Rule deleted")
+}
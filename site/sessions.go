@@ -0,0 +1,108 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/organization"
+	"github.com/slimnate/laser-beam/data/session"
+	"github.com/slimnate/laser-beam/data/user"
+)
+
+// Render the list of the user's active sessions with an optional toast message
+func (s *SiteController) renderSessions(ctx *gin.Context, u *user.User, org *organization.Organization, toast string) {
+	sessions, err := s.sessionRepo.AllActiveForUser(u.ID, s.sessionConfig)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	data := PageData{
+		User:         u,
+		Organization: org,
+		Sessions:     sessions,
+		Route:        "/account/sessions",
+	}
+	if current := session.FromContext(ctx); current != nil {
+		data.CurrentSessionID = current.ID
+	}
+	if toast != "" {
+		data.AddToast(toast)
+	}
+
+	HxRespond(200, ctx, "user_sessions.html", "index.html", data)
+}
+
+// GET /account/sessions
+func (s *SiteController) RenderSessions(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.renderSessions(ctx, u, org, "")
+}
+
+// DELETE /account/sessions/:session_id
+func (s *SiteController) RevokeSession(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	id, err := strconv.ParseInt(ctx.Param("session_id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	if err := s.sessionRepo.DeleteByIDForUser(id, u.ID); err != nil {
+		if errors.Is(err, data.ErrDeleteFailed) {
+			ctx.AbortWithStatus(404)
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(accountAudit(ctx, u, audit.ActionSessionRevoked).On(audit.TargetSession, id))
+
+	// revoking the current session is the same as logging out
+	if current := session.FromContext(ctx); current != nil && current.ID == id {
+		session.ClearCookie(ctx, s.sessionConfig)
+		HxRedirect(ctx, "/login")
+		return
+	}
+
+	s.renderSessions(ctx, u, org, "Session revoked")
+}
+
+// POST /account/sessions/revoke-all
+func (s *SiteController) RevokeAllSessions(ctx *gin.Context) {
+	u, _, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	count, err := s.sessionRepo.DeleteAllForUser(u.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(accountAudit(ctx, u, audit.ActionSessionRevoked).WithDetails(fmt.Sprintf("all sessions revoked (%d)", count)))
+
+	session.ClearCookie(ctx, s.sessionConfig)
+	HxRedirect(ctx, "/login")
+}
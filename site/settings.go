@@ -0,0 +1,188 @@
+package site
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/scrubbing"
+	"github.com/slimnate/laser-beam/validation"
+)
+
+// Render the organization settings page along with the API key, showing the toast if one is supplied. The form shows
+// pageData.OrganizationSettings when set, so invalid values can be corrected
+func (s *SiteController) renderSettings(ctx *gin.Context, pageData PageData, toast string) {
+	key, err := s.orgRepo.GetKey(pageData.Organization.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	pageData.APIKey = key
+	pageData.Route = "/settings"
+	if pageData.OrganizationSettings == nil {
+		pageData.OrganizationSettings = pageData.Organization
+	}
+	if pageData.ScrubbingPolicy == nil {
+		pageData.ScrubbingPolicy, err = s.scrubbingRepo.GetForOrganization(pageData.Organization.ID)
+		if err != nil {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+	}
+	if toast != "" {
+		pageData.AddToast(toast)
+	}
+
+	HxRespond(200, ctx, "organization_settings.html", "index.html", pageData)
+}
+
+// GET /settings
+func (s *SiteController) RenderSettings(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.renderSettings(ctx, PageData{User: u, Organization: org}, "")
+}
+
+// POST /settings
+func (s *SiteController) SaveSettings(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// unparseable numbers are left as -1, which fails validation
+	retentionDays, err := strconv.Atoi(ctx.PostForm("retention_days"))
+	if err != nil {
+		retentionDays = -1
+	}
+	pageSize, err := strconv.ParseInt(ctx.PostForm("default_page_size"), 10, 64)
+	if err != nil {
+		pageSize = -1
+	}
+
+	settings := *org
+	settings.Name = ctx.PostForm("organization_name")
+	settings.Timezone = strings.TrimSpace(ctx.PostForm("timezone"))
+	settings.RetentionDays = retentionDays
+	settings.DefaultFilter = strings.TrimSpace(ctx.PostForm("default_filter"))
+	settings.DefaultPageSize = pageSize
+
+	pageData := PageData{User: u, Organization: org, OrganizationSettings: &settings}
+
+	if valid, e := validation.ValidateOrganization(&settings); !valid {
+		pageData.Errors = e
+		s.renderSettings(ctx, pageData, "")
+		return
+	}
+
+	updated, err := s.orgRepo.Update(org.ID, settings)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			pageData.Errors = map[string]string{"OrganizationName": "Organization name is already taken"}
+			s.renderSettings(ctx, pageData, "")
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if changes := audit.Diff(*org, *updated); len(changes) > 0 {
+		s.recordAudit(auditBy(ctx, u, audit.ActionOrganizationUpdated).On(audit.TargetOrganization, org.ID).WithChanges(changes))
+	}
+
+	s.renderSettings(ctx, PageData{User: u, Organization: updated}, "Settings saved!")
+}
+
+// POST /settings/scrubbing
+func (s *SiteController) SaveScrubbingPolicy(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	policy := scrubbing.ScrubbingPolicy{
+		OrganizationID: org.ID,
+		Email:          ctx.PostForm("email") == "on",
+		CreditCard:     ctx.PostForm("credit_card") == "on",
+		IPAddress:      ctx.PostForm("ip_address") == "on",
+		BearerToken:    ctx.PostForm("bearer_token") == "on",
+		// one pattern per line
+		CustomPatterns: strings.Split(ctx.PostForm("custom_patterns"), "\n"),
+	}
+
+	if valid, e := validation.ValidateScrubbingPolicy(&policy); !valid {
+		s.renderSettings(ctx, PageData{User: u, Organization: org, ScrubbingPolicy: &policy, Errors: e}, "")
+		return
+	}
+
+	before, err := s.scrubbingRepo.GetForOrganization(org.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	saved, err := s.scrubbingRepo.Save(policy)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// audit.Diff skips slices, so changes to the custom patterns are added separately
+	changes := audit.Diff(*before, *saved)
+	if !slices.Equal(before.CustomPatterns, saved.CustomPatterns) {
+		changes = append(changes, audit.Change{
+			Field: "CustomPatterns",
+			Old:   strings.Join(before.CustomPatterns, ", "),
+			New:   strings.Join(saved.CustomPatterns, ", "),
+		})
+	}
+	if len(changes) > 0 {
+		s.recordAudit(auditBy(ctx, u, audit.ActionScrubbingUpdated).On(audit.TargetOrganization, org.ID).WithChanges(changes))
+	}
+
+	s.renderSettings(ctx, PageData{User: u, Organization: org, ScrubbingPolicy: saved}, "Data scrubbing saved!")
+}
+
+// POST /settings/api-key
+func (s *SiteController) RegenerateAPIKey(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	key, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// the old key stops working immediately
+	if err := s.orgRepo.SetKey(org.ID, key); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(auditBy(ctx, u, audit.ActionAPIKeyChanged).On(audit.TargetOrganization, org.ID))
+
+	s.renderSettings(ctx, PageData{User: u, Organization: org}, "API key regenerated! Update your applications to use the new key.")
+}
@@ -0,0 +1,165 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/organization"
+	"github.com/slimnate/laser-beam/data/passwordpolicy"
+	"github.com/slimnate/laser-beam/data/project"
+	"github.com/slimnate/laser-beam/data/role"
+	"github.com/slimnate/laser-beam/data/user"
+	"github.com/slimnate/laser-beam/validation"
+)
+
+// Name of the application new organizations start with, used as "my-app" by the sample clients on the onboarding page
+const DefaultProjectName = "My App"
+
+// GET /signup
+func (s *SiteController) RenderSignup(ctx *gin.Context) {
+	policy := passwordpolicy.Default(0)
+	ctx.HTML(http.StatusOK, "signup.html", gin.H{"PasswordPolicy": &policy})
+}
+
+// POST /signup
+func (s *SiteController) ProcessSignup(ctx *gin.Context) {
+	org := organization.Organization{
+		Name: ctx.PostForm("organization_name"),
+	}
+
+	admin := user.UserSecret{
+		User: user.User{
+			Username:  ctx.PostForm("username"),
+			FirstName: ctx.PostForm("first_name"),
+			LastName:  ctx.PostForm("last_name"),
+			Email:     ctx.PostForm("email"),
+			Phone:     ctx.PostForm("phone"),
+		},
+		Password: ctx.PostForm("password"),
+	}
+
+	// new organizations start with the default password policy
+	policy := passwordpolicy.Default(0)
+
+	pageData := gin.H{
+		"Organization":   &org,
+		"User":           &admin.User,
+		"PasswordPolicy": &policy,
+	}
+
+	valid, e := validation.ValidateNewUser(&admin, ctx.PostForm("confirm_password"), policy)
+	if orgValid, orgErrors := validation.ValidateOrganization(&org); !orgValid {
+		e["OrganizationName"] = orgErrors["OrganizationName"]
+		valid = false
+	}
+
+	// check the username up front, so the organization doesn't need to be removed again in the common case
+	if _, err := s.userRepo.GetByUsername(admin.Username); err == nil {
+		e["Username"] = "Username is already taken"
+		valid = false
+	} else if !errors.Is(err, data.ErrNotExists) {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if !valid {
+		pageData["Errors"] = e
+		HxRespond(200, ctx, "signup_form.html", "signup.html", pageData)
+		return
+	}
+
+	adminRole, err := s.roleRepo.GetBuiltIn(role.OrgAdmin)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	admin.Password, err = crypto.HashPassword(admin.Password)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	key, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	created, err := s.orgRepo.Create(org, key)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			pageData["Errors"] = map[string]string{"OrganizationName": "Organization name is already taken"}
+			HxRespond(200, ctx, "signup_form.html", "signup.html", pageData)
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	admin.RoleID = adminRole.ID
+	admin.OrganizationID = created.ID
+	u, err := s.userRepo.Create(admin)
+	if err != nil {
+		// the organization is unusable without an admin, so undo its creation
+		if err := s.orgRepo.Delete(created.ID); err != nil {
+			log.Println(err.Error())
+		}
+		if errors.Is(err, data.ErrDuplicate) {
+			pageData["Errors"] = map[string]string{"Username": "Username is already taken"}
+			HxRespond(200, ctx, "signup_form.html", "signup.html", pageData)
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// new organizations start with an application, so the sample clients on the onboarding page work straight away
+	appKey, err := crypto.GenerateToken()
+	if err == nil {
+		_, err = s.projectRepo.Create(project.Project{OrganizationID: created.ID, Name: DefaultProjectName, Enabled: true}, appKey)
+	}
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	s.recordAudit(auditBy(ctx, u, audit.ActionOrganizationCreated).On(audit.TargetOrganization, created.ID).
+		WithDetails(fmt.Sprintf("%s signed up as %s", created.Name, u.Username)))
+
+	s.startSessionAndRedirect(ctx, u, "signup", "/onboarding")
+}
+
+// GET /onboarding
+func (s *SiteController) RenderOnboarding(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	key, err := s.orgRepo.GetKey(org.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	pageData := PageData{
+		User:         u,
+		Organization: org,
+		APIKey:       key,
+		Route:        "/onboarding",
+	}
+
+	HxRespond(200, ctx, "onboarding.html", "index.html", pageData)
+}
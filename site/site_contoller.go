@@ -2,39 +2,115 @@ package site
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/slimnate/laser-beam/crypto"
 	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
 	"github.com/slimnate/laser-beam/data/event"
+	"github.com/slimnate/laser-beam/data/identityprovider"
+	"github.com/slimnate/laser-beam/data/invitation"
+	"github.com/slimnate/laser-beam/data/loginattempt"
+	"github.com/slimnate/laser-beam/data/membership"
 	"github.com/slimnate/laser-beam/data/organization"
+	"github.com/slimnate/laser-beam/data/passwordpolicy"
+	"github.com/slimnate/laser-beam/data/passwordreset"
+	"github.com/slimnate/laser-beam/data/project"
+	"github.com/slimnate/laser-beam/data/release"
+	"github.com/slimnate/laser-beam/data/role"
+	"github.com/slimnate/laser-beam/data/rule"
+	"github.com/slimnate/laser-beam/data/scrubbing"
 	"github.com/slimnate/laser-beam/data/session"
+	"github.com/slimnate/laser-beam/data/twofactor"
+	"github.com/slimnate/laser-beam/data/usage"
 	"github.com/slimnate/laser-beam/data/user"
+	"github.com/slimnate/laser-beam/mailer"
 	"github.com/slimnate/laser-beam/middleware"
+	"github.com/slimnate/laser-beam/ratelimit"
 	"github.com/slimnate/laser-beam/validation"
-	"github.com/thanhpk/randstr"
+)
+
+const (
+	RelatedEventsWindow = time.Hour // how far either side of an event to look for related events
+	RelatedEventsLimit  = 10
 )
 
 type SiteController struct {
-	orgRepo     *organization.OrganizationRepository
-	eventRepo   *event.EventRepository
-	userRepo    *user.UserRepository
-	sessionRepo *session.SessionRepository
+	orgRepo              *organization.OrganizationRepository
+	eventRepo            *event.EventRepository
+	projectRepo          *project.ProjectRepository
+	releaseRepo          *release.ReleaseRepository
+	userRepo             *user.UserRepository
+	sessionRepo          *session.SessionRepository
+	membershipRepo       *membership.MembershipRepository
+	roleRepo             *role.RoleRepository
+	invitationRepo       *invitation.InvitationRepository
+	resetRepo            *passwordreset.PasswordResetRepository
+	twoFactorRepo        *twofactor.TwoFactorRepository
+	loginAttemptRepo     *loginattempt.LoginAttemptRepository
+	policyRepo           *passwordpolicy.PasswordPolicyRepository
+	auditRepo            *audit.AuditRepository
+	identityProviderRepo *identityprovider.IdentityProviderRepository
+	usageRepo            *usage.UsageRepository
+	ruleRepo             *rule.RuleRepository
+	scrubbingRepo        *scrubbing.ScrubbingRepository
+	ingestionQueue       *event.IngestionQueue
+	mailer               mailer.Mailer
+	sessionConfig        session.Config
+	limitConfig          ratelimit.Config
 }
 
-func NewSiteController(orgRepo *organization.OrganizationRepository, eventRepo *event.EventRepository, userRepo *user.UserRepository, sessionRepo *session.SessionRepository) *SiteController {
+func NewSiteController(orgRepo *organization.OrganizationRepository, eventRepo *event.EventRepository, projectRepo *project.ProjectRepository, releaseRepo *release.ReleaseRepository, userRepo *user.UserRepository, sessionRepo *session.SessionRepository, membershipRepo *membership.MembershipRepository, roleRepo *role.RoleRepository, invitationRepo *invitation.InvitationRepository, resetRepo *passwordreset.PasswordResetRepository, twoFactorRepo *twofactor.TwoFactorRepository, loginAttemptRepo *loginattempt.LoginAttemptRepository, policyRepo *passwordpolicy.PasswordPolicyRepository, auditRepo *audit.AuditRepository, identityProviderRepo *identityprovider.IdentityProviderRepository, usageRepo *usage.UsageRepository, ruleRepo *rule.RuleRepository, scrubbingRepo *scrubbing.ScrubbingRepository, ingestionQueue *event.IngestionQueue, mailer mailer.Mailer, sessionConfig session.Config, limitConfig ratelimit.Config) *SiteController {
 	return &SiteController{
-		orgRepo:     orgRepo,
-		eventRepo:   eventRepo,
-		userRepo:    userRepo,
-		sessionRepo: sessionRepo,
+		orgRepo:              orgRepo,
+		eventRepo:            eventRepo,
+		projectRepo:          projectRepo,
+		releaseRepo:          releaseRepo,
+		userRepo:             userRepo,
+		sessionRepo:          sessionRepo,
+		membershipRepo:       membershipRepo,
+		roleRepo:             roleRepo,
+		invitationRepo:       invitationRepo,
+		resetRepo:            resetRepo,
+		twoFactorRepo:        twoFactorRepo,
+		loginAttemptRepo:     loginAttemptRepo,
+		policyRepo:           policyRepo,
+		auditRepo:            auditRepo,
+		identityProviderRepo: identityProviderRepo,
+		usageRepo:            usageRepo,
+		ruleRepo:             ruleRepo,
+		scrubbingRepo:        scrubbingRepo,
+		ingestionQueue:       ingestionQueue,
+		mailer:               mailer,
+		sessionConfig:        sessionConfig,
+		limitConfig:          limitConfig,
+	}
+}
+
+// Get an absolute URL to a path on the site, for use in emails. The base URL is configured with the APP_URL env variable
+func AbsoluteURL(path string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:8080"
 	}
+	return strings.TrimSuffix(base, "/") + path
 }
 
 // Send a different response depending whether we are responding to an HTMX request or not
 func HxRespond(status int, ctx *gin.Context, htmxTemplate string, defaultTemplate string, data any) {
+	// pages for logged in users need the CSRF token for their forms
+	if d, ok := data.(PageData); ok {
+		d.CSRFToken = middleware.CSRFToken(ctx)
+		data = d
+	}
+
 	hx := middleware.GetHxHeaders(ctx)
 	if hx.Request {
 		ctx.HTML(200, htmxTemplate, data)
@@ -54,6 +130,15 @@ func HxRedirect(ctx *gin.Context, path string) {
 	}
 }
 
+// Get the ID of the organization the user is working in. Users that are members of several organizations work in
+// whichever one they last switched to
+func activeOrganizationID(ctx *gin.Context, u *user.User) int64 {
+	if sess := session.FromContext(ctx); sess != nil {
+		return sess.ActiveOrganizationID(u.OrganizationID)
+	}
+	return u.OrganizationID
+}
+
 // Extract the user and organization data from the request context
 func (s *SiteController) GetUserOrg(ctx *gin.Context) (u *user.User, o *organization.Organization, err error) {
 	userAny, exists := ctx.Get("user")
@@ -62,7 +147,7 @@ func (s *SiteController) GetUserOrg(ctx *gin.Context) (u *user.User, o *organiza
 	}
 	user := userAny.(*user.User)
 
-	org, err := s.orgRepo.GetByID(user.OrganizationID)
+	org, err := s.orgRepo.GetByID(activeOrganizationID(ctx, user))
 	if err != nil {
 		return nil, nil, errors.New("unable to get org")
 	}
@@ -82,7 +167,8 @@ func (s *SiteController) Index(ctx *gin.Context) {
 		Limit: 5,
 	})
 	if err != nil {
-		ctx.AbortWithStatus(500)
+		ctx.AbortWithStatus(400)
+		return
 	}
 
 	events, err := s.eventRepo.AllForOrganization(org.ID, pag)
@@ -90,6 +176,7 @@ func (s *SiteController) Index(ctx *gin.Context) {
 		ctx.AbortWithStatus(500)
 		return
 	}
+	localizeEvents(events.Data, org)
 
 	data := PageData{
 		User:         user,
@@ -110,56 +197,122 @@ func (s *SiteController) RenderLogin(ctx *gin.Context) {
 func (s *SiteController) ProcessLogin(ctx *gin.Context) {
 	username := ctx.PostForm("username")
 	password := ctx.PostForm("password")
+	ip := ctx.ClientIP()
 
-	data := gin.H{"Error": "Invalid username or password", "Username": username}
+	pageData := gin.H{"Error": "Invalid username or password", "Username": username}
 
-	user, err := s.userRepo.GetByUsername(username)
+	// throttled attempts are rejected before checking the password, so they don't cost a bcrypt comparison
+	wait, err := s.loginWait(username, ip)
 	if err != nil {
-		log.Println("Invalid user")
 		log.Println(err.Error())
-		HxRespond(401, ctx, "login_form.html", "index.html", data)
+		ctx.AbortWithStatus(500)
+		return
+	}
+	if wait > 0 {
+		ctx.Header("Retry-After", strconv.Itoa(int(wait.Seconds())))
+		pageData["Error"] = fmt.Sprintf("Too many failed login attempts, please try again in %s", formatWait(wait))
+		HxRespond(429, ctx, "login_form.html", "login.html", pageData)
+		return
+	}
+
+	user, err := s.userRepo.GetByUsername(username)
+	if err != nil {
+		if !errors.Is(err, data.ErrNotExists) {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+		// take as long as a wrong password would, so unknown usernames can't be found by timing responses
+		crypto.SimulateMatch(password)
+		s.recordLoginAttempt(username, ip, false)
+		HxRespond(401, ctx, "login_form.html", "login.html", pageData)
 		return
 	}
 
 	if !crypto.TestMatch(password, user.Password) {
-		log.Println("invalid pass")
-		HxRespond(401, ctx, "login_form.html", "index.html", data)
+		s.recordLoginAttempt(username, ip, false)
+		s.recordAudit(accountAudit(ctx, &user.User, audit.ActionLoginFailed).WithDetails("incorrect password"))
+		HxRespond(401, ctx, "login_form.html", "login.html", pageData)
 		return
 	}
 
-	session_key := randstr.String(64)
-	session, err := s.sessionRepo.Create(session_key, user.ID)
+	if !user.Active {
+		pageData["Error"] = "This account has been deactivated"
+		HxRespond(403, ctx, "login_form.html", "login.html", pageData)
+		return
+	}
+
+	// users with two-factor enabled need to enter a code before a session is created
+	enabled, err := s.twoFactorRepo.IsEnabled(user.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+	if enabled {
+		s.startTwoFactorChallenge(ctx, user.ID)
+		return
+	}
+
+	s.recordLoginAttempt(username, ip, true)
+	s.startSession(ctx, &user.User, "password")
+}
+
+// Log the user in by creating a new session and setting the session cookie, then redirect to the dashboard. The login
+// is recorded in the audit log along with the `method` used to authenticate
+func (s *SiteController) startSession(ctx *gin.Context, u *user.User, method string) {
+	s.startSessionAndRedirect(ctx, u, method, "/")
+}
+
+// Same as startSession, but redirects to `path` once the user is logged in
+func (s *SiteController) startSessionAndRedirect(ctx *gin.Context, u *user.User, method string, path string) {
+	sessionKey, err := crypto.GenerateToken()
 	if err != nil {
 		ctx.AbortWithStatusJSON(500, gin.H{"Error": "unable to create user session"})
 		return
 	}
 
-	cookie := &http.Cookie{
-		Name:   "session_key",
-		Value:  session.Key,
-		MaxAge: 0,
+	newSession, err := s.sessionRepo.Create(sessionKey, u.ID, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"Error": "unable to create user session"})
+		return
 	}
-	ctx.SetCookie(cookie.Name, cookie.Value, cookie.MaxAge, cookie.Path, cookie.Domain, cookie.Secure, cookie.HttpOnly)
 
-	HxRedirect(ctx, "/")
+	s.recordAudit(accountAudit(ctx, u, audit.ActionLogin).WithDetails(method))
+
+	session.SetCookie(ctx, s.sessionConfig, sessionKey, newSession.Remaining(s.sessionConfig, time.Now()))
+
+	HxRedirect(ctx, path)
 }
 
 // GET /logout
 func (s *SiteController) Logout(ctx *gin.Context) {
-	sessionCookie, err := ctx.Request.Cookie("session_key")
+	sessionKey, err := ctx.Cookie(session.CookieName)
 	if err != nil {
 		log.Println("No session cookie found when trying to log out")
 		ctx.Redirect(302, "/")
 		return
 	}
 
-	if err := s.sessionRepo.DeleteByKey(sessionCookie.Value); err != nil {
+	// look up the session first so the logout can be attributed to its user
+	existing, err := s.sessionRepo.GetByToken(sessionKey)
+	if err != nil && !errors.Is(err, data.ErrNotExists) {
+		log.Println("Unable to get session entry from db: " + err.Error())
+	}
+
+	if err := s.sessionRepo.DeleteByToken(sessionKey); err != nil {
 		log.Println("Unable to delete session entry from db: " + err.Error())
 		ctx.Redirect(302, "/")
 		return
 	}
 
-	ctx.SetCookie(sessionCookie.Name, "", -1, sessionCookie.Path, sessionCookie.Domain, sessionCookie.Secure, sessionCookie.HttpOnly)
+	if existing != nil {
+		if u, err := s.userRepo.GetByID(existing.UserID); err == nil {
+			s.recordAudit(accountAudit(ctx, u, audit.ActionLogout).On(audit.TargetSession, existing.ID))
+		}
+	}
+
+	session.ClearCookie(ctx, s.sessionConfig)
 	ctx.Redirect(302, "/")
 }
 
@@ -210,6 +363,10 @@ func (s *SiteController) UpdateUser(ctx *gin.Context) {
 		return
 	}
 
+	before := *user
+	oldEmail := user.Email
+	emailChanged := !strings.EqualFold(strings.TrimSpace(newEmail), oldEmail)
+
 	user.FirstName = newFirstName
 	user.LastName = newLastName
 	user.Email = newEmail
@@ -222,6 +379,21 @@ func (s *SiteController) UpdateUser(ctx *gin.Context) {
 	}
 
 	valid, e := validation.ValidateUserUpdate(user)
+
+	// the email address is where password resets are sent, so changing it needs the current password
+	if emailChanged {
+		msg, err := s.confirmCurrentPassword(ctx, user)
+		if err != nil {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+		if msg != "" {
+			e["CurrentPassword"] = msg
+			valid = false
+		}
+	}
+
 	if !valid {
 		data.Errors = e
 		HxRespond(200, ctx, "user_form.html", "index.html", data)
@@ -235,6 +407,18 @@ func (s *SiteController) UpdateUser(ctx *gin.Context) {
 		return
 	}
 
+	if changes := audit.Diff(before, *newUser); len(changes) > 0 {
+		action := audit.ActionProfileUpdated
+		if emailChanged {
+			action = audit.ActionEmailChanged
+		}
+		s.recordAudit(accountAudit(ctx, newUser, action).WithChanges(changes))
+	}
+
+	if emailChanged {
+		s.notifyAccountChange(newUser, oldEmail, fmt.Sprintf("The email address on your account was changed to %s.", newUser.Email), ctx.ClientIP())
+	}
+
 	data.User = newUser
 	data.Route = "/account"
 	data.AddToast("Successfully updated user account!")
@@ -250,10 +434,21 @@ func (s *SiteController) RenderPasswordForm(ctx *gin.Context) {
 		return
 	}
 
+	policy, err := s.policyRepo.GetForOrganization(org.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
 	data := PageData{
-		User:         user,
-		Organization: org,
-		Route:        "/account/password",
+		User:           user,
+		Organization:   org,
+		PasswordPolicy: policy,
+		Route:          "/account/password",
+	}
+	if policy.Expired(user.PasswordChangedAt, time.Now()) {
+		data.AddToast("Your password has expired, please choose a new one")
 	}
 
 	HxRespond(200, ctx, "user_password.html", "index.html", data)
@@ -270,13 +465,38 @@ func (s *SiteController) UpdatePassword(ctx *gin.Context) {
 		return
 	}
 
+	policy, err := s.policyRepo.GetForOrganization(org.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
 	data := PageData{
-		User:         u,
-		Organization: org,
-		Route:        "/account/password",
+		User:           u,
+		Organization:   org,
+		PasswordPolicy: policy,
+		Route:          "/account/password",
 	}
 
-	valid, e := validation.ValidatePasswordUpdate(newPassword, confirmPassword)
+	msg, err := s.confirmCurrentPassword(ctx, u)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+	if msg != "" {
+		data.Errors = map[string]string{"CurrentPassword": msg}
+		HxRespond(200, ctx, "user_password.html", "index.html", data)
+		return
+	}
+
+	valid, e, err := s.validateNewPassword(u.ID, policy, newPassword, confirmPassword)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
 	if !valid {
 		data.Errors = e
 		HxRespond(200, ctx, "user_password.html", "index.html", data)
@@ -301,6 +521,9 @@ func (s *SiteController) UpdatePassword(ctx *gin.Context) {
 		return
 	}
 
+	s.recordAudit(accountAudit(ctx, u, audit.ActionPasswordChanged))
+	s.notifyAccountChange(newUser, newUser.Email, "Your password was changed.", ctx.ClientIP())
+
 	data.User = newUser
 	data.Route = "/account"
 	data.AddToast("Successfully updated password!")
@@ -308,19 +531,26 @@ func (s *SiteController) UpdatePassword(ctx *gin.Context) {
 	HxRespond(200, ctx, "user_display.html", "index.html", data)
 }
 
+// Convert event times to the organization's time zone for display
+func localizeEvents(events []event.Event, org *organization.Organization) {
+	loc := org.Location()
+	for i := range events {
+		events[i].Time = events[i].Time.In(loc)
+	}
+}
+
 // GET /events
 func (s *SiteController) RenderEvents(ctx *gin.Context) {
-	pag, err := data.ParsePaginationRequestOptions(ctx)
+	u, o, err := s.GetUserOrg(ctx)
 	if err != nil {
 		log.Println(err.Error())
 		ctx.AbortWithStatus(500)
 		return
 	}
 
-	u, o, err := s.GetUserOrg(ctx)
+	pag, err := data.ParsePaginationRequestOptionsCustomDefault(ctx, o.DefaultPagination())
 	if err != nil {
-		log.Println(err.Error())
-		ctx.AbortWithStatus(500)
+		ctx.AbortWithStatus(400)
 		return
 	}
 
@@ -330,6 +560,7 @@ func (s *SiteController) RenderEvents(ctx *gin.Context) {
 		ctx.AbortWithStatus(500)
 		return
 	}
+	localizeEvents(e.Data, o)
 
 	data := PageData{
 		User:         u,
@@ -340,3 +571,74 @@ func (s *SiteController) RenderEvents(ctx *gin.Context) {
 
 	HxRespond(200, ctx, "events.html", "index.html", data)
 }
+
+// GET /events/:event_id
+func (s *SiteController) RenderEventDetails(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("event_id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	u, o, err := s.GetUserOrg(ctx)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// neighbors are found within the same list the event was opened from, which uses the organization's defaults
+	pag, err := data.ParsePaginationRequestOptionsCustomDefault(ctx, o.DefaultPagination())
+	if err != nil {
+		ctx.AbortWithStatus(400)
+		return
+	}
+
+	e, err := s.eventRepo.GetByIDAndOrg(id, o.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrNotExists) {
+			ctx.AbortWithStatus(404)
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	related, err := s.eventRepo.Related(e, RelatedEventsWindow, RelatedEventsLimit)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	previous, next, err := s.eventRepo.Neighbors(e, pag)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	e.Time = e.Time.In(o.Location())
+	localizeEvents(related, o)
+	for _, neighbor := range []*event.Event{previous, next} {
+		if neighbor != nil {
+			neighbor.Time = neighbor.Time.In(o.Location())
+		}
+	}
+
+	data := PageData{
+		User:         u,
+		Organization: o,
+		Event: &event.EventDetails{
+			Event:    *e,
+			Related:  related,
+			Previous: previous,
+			Next:     next,
+			Request:  pag,
+		},
+		Route: "/events/:event_id",
+	}
+
+	HxRespond(200, ctx, "event_details.html", "index.html", data)
+}
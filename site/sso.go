@@ -0,0 +1,412 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/identityprovider"
+	"github.com/slimnate/laser-beam/data/user"
+	"github.com/slimnate/laser-beam/oidc"
+	"github.com/slimnate/laser-beam/validation"
+)
+
+const (
+	ssoCallbackPath          = "/login/sso/callback"
+	ssoUnavailableMessage    = "Unable to reach your identity provider, please try again later"
+	ssoVerificationMessage   = "Unable to verify your login with your identity provider, please try again"
+	ssoUsernameMaxAttempts   = 5
+	ssoUsernameMaxBaseLength = 40
+)
+
+// Get the domain of an email address, or an empty string if it doesn't have one
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
+
+// Create an OIDC client for an organization's identity provider
+func ssoClient(p *identityprovider.IdentityProvider) (*oidc.Client, error) {
+	return oidc.NewClient(p.Issuer, p.ClientID, p.ClientSecret, AbsoluteURL(ssoCallbackPath))
+}
+
+// GET /login/sso
+func (s *SiteController) RenderSSOLogin(ctx *gin.Context) {
+	ctx.HTML(http.StatusOK, "login_sso.html", nil)
+}
+
+// POST /login/sso
+func (s *SiteController) StartSSOLogin(ctx *gin.Context) {
+	email := strings.TrimSpace(ctx.PostForm("email"))
+	pageData := gin.H{"Email": email}
+
+	provider, err := s.identityProviderRepo.GetEnabledByDomain(emailDomain(email))
+	if err != nil {
+		if !errors.Is(err, data.ErrNotExists) {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+		pageData["Error"] = "Single sign-on isn't set up for this email address"
+		HxRespond(404, ctx, "login_sso_form.html", "login_sso.html", pageData)
+		return
+	}
+
+	client, err := ssoClient(provider)
+	if err != nil {
+		log.Printf("[sso] Unable to create client for identity provider %d: %s", provider.ID, err.Error())
+		pageData["Error"] = ssoUnavailableMessage
+		HxRespond(502, ctx, "login_sso_form.html", "login_sso.html", pageData)
+		return
+	}
+
+	// the state token identifies the login when the user comes back, the nonce ties the ID token to this login, and
+	// the verifier ties the code exchange to it
+	state, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+	nonce, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+	verifier, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if _, err := s.identityProviderRepo.CreateLogin(provider.ID, crypto.HashToken(state), nonce, verifier); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	HxRedirect(ctx, client.AuthCodeURL(state, nonce, verifier, email))
+}
+
+// GET /login/sso/callback
+func (s *SiteController) ProcessSSOCallback(ctx *gin.Context) {
+	if e := ctx.Query("error"); e != "" {
+		log.Printf("[sso] Identity provider returned error '%s': %s", e, ctx.Query("error_description"))
+		HxRespond(401, ctx, "login_form.html", "login.html", gin.H{"Error": "Login was cancelled or denied by your identity provider"})
+		return
+	}
+
+	login, err := s.identityProviderRepo.ClaimLogin(crypto.HashToken(ctx.Query("state")))
+	if err != nil {
+		if !errors.Is(err, data.ErrNotExists) {
+			log.Println(err.Error())
+		}
+		HxRespond(401, ctx, "login_form.html", "login.html", gin.H{"Error": expiredChallengeMessage})
+		return
+	}
+
+	provider, err := s.identityProviderRepo.GetByID(login.ProviderID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+	if !provider.Enabled {
+		HxRespond(403, ctx, "login_form.html", "login.html", gin.H{"Error": "Single sign-on has been disabled for your organization"})
+		return
+	}
+
+	client, err := ssoClient(provider)
+	if err != nil {
+		log.Printf("[sso] Unable to create client for identity provider %d: %s", provider.ID, err.Error())
+		HxRespond(502, ctx, "login_form.html", "login.html", gin.H{"Error": ssoUnavailableMessage})
+		return
+	}
+
+	idToken, err := client.Exchange(ctx.Query("code"), login.Verifier)
+	if err != nil {
+		log.Printf("[sso] Code exchange failed for identity provider %d: %s", provider.ID, err.Error())
+		HxRespond(401, ctx, "login_form.html", "login.html", gin.H{"Error": ssoVerificationMessage})
+		return
+	}
+
+	claims, err := client.VerifyIDToken(idToken, login.Nonce, time.Now())
+	if err != nil {
+		log.Printf("[sso] ID token verification failed for identity provider %d: %s", provider.ID, err.Error())
+		HxRespond(401, ctx, "login_form.html", "login.html", gin.H{"Error": ssoVerificationMessage})
+		return
+	}
+
+	u, msg, err := s.resolveSSOUser(ctx, provider, claims)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+	if msg != "" {
+		HxRespond(403, ctx, "login_form.html", "login.html", gin.H{"Error": msg})
+		return
+	}
+
+	if !u.Active {
+		HxRespond(403, ctx, "login_form.html", "login.html", gin.H{"Error": "This account has been deactivated"})
+		return
+	}
+
+	// the identity provider can be configured by an organization admin, so it isn't trusted to check the second factor.
+	// Users without two-factor in organizations that require it are sent to enroll once logged in
+	enabled, err := s.twoFactorRepo.IsEnabled(u.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+	if enabled {
+		s.startTwoFactorChallenge(ctx, u.ID)
+		return
+	}
+
+	s.recordLoginAttempt(u.Username, ctx.ClientIP(), true)
+	s.startSession(ctx, u, "single sign-on through "+provider.Name)
+}
+
+// Find the user to log in as for a verified ID token. Users are found by their link to the provider, then by a verified
+// email address in the provider's organization, and otherwise created if the provider allows it. Returns a message to
+// show the user if they can't be logged in.
+//
+// Nothing proves the organization owns the provider's issuer or domain, so an account is only logged in to through the
+// provider if the admin that configured it could assign the account's role, even if the account is already linked.
+// Otherwise an admin could log in as anyone with more access than themselves through a provider they control
+func (s *SiteController) resolveSSOUser(ctx *gin.Context, p *identityprovider.IdentityProvider, claims *oidc.Claims) (*user.User, string, error) {
+	userID, err := s.identityProviderRepo.GetLinkedUserID(p.ID, claims.Subject)
+	if err == nil {
+		u, err := s.userRepo.GetByIDForOrganization(userID, p.OrganizationID)
+		if err != nil {
+			return nil, "", err
+		}
+		allowed, err := s.canLinkSSOUser(p, u)
+		if err != nil {
+			return nil, "", err
+		}
+		if !allowed {
+			return nil, fmt.Sprintf("Your account can't be logged in to through %s, please ask your administrator for help", p.Name), nil
+		}
+		return u, "", nil
+	}
+	if !errors.Is(err, data.ErrNotExists) {
+		return nil, "", err
+	}
+
+	// accounts are only matched by email addresses the provider vouches for, in the domain it is configured for
+	if !claims.EmailVerified || emailDomain(claims.Email) != p.Domain {
+		return nil, fmt.Sprintf("Your identity provider didn't supply a verified email address at %s", p.Domain), nil
+	}
+
+	u, err := s.userRepo.GetByEmailForOrganization(claims.Email, p.OrganizationID)
+	if err != nil && !errors.Is(err, data.ErrNotExists) {
+		return nil, "", err
+	}
+
+	if u != nil {
+		allowed, err := s.canLinkSSOUser(p, u)
+		if err != nil {
+			return nil, "", err
+		}
+		if !allowed {
+			return nil, fmt.Sprintf("Your account can't be linked to %s, please ask your administrator for help", p.Name), nil
+		}
+	} else {
+		if !p.AutoProvision {
+			return nil, fmt.Sprintf("There is no LaserBeam account for %s, please ask your administrator for an invitation", claims.Email), nil
+		}
+
+		u, err = s.provisionSSOUser(p, claims)
+		if err != nil {
+			return nil, "", err
+		}
+		s.recordAudit(audit.New(p.OrganizationID, audit.ActionUserProvisioned, ctx.ClientIP()).On(audit.TargetUser, u.ID).
+			WithDetails(fmt.Sprintf("created by single sign-on through %s", p.Name)))
+	}
+
+	if _, err := s.identityProviderRepo.Link(p.ID, claims.Subject, u.ID); err != nil {
+		return nil, "", err
+	}
+	s.recordAudit(accountAudit(ctx, u, audit.ActionIdentityLinked).WithDetails(fmt.Sprintf("%s subject %s", p.Name, claims.Subject)))
+
+	return u, "", nil
+}
+
+// Check whether an existing account can be linked to, or logged in to through, an identity provider, which is only
+// allowed if the admin that configured the provider could assign the account's role
+func (s *SiteController) canLinkSSOUser(p *identityprovider.IdentityProvider, u *user.User) (bool, error) {
+	if p.ConfiguredBy == nil {
+		return false, nil
+	}
+
+	admin, err := s.userRepo.GetByIDForOrganization(*p.ConfiguredBy, p.OrganizationID)
+	if errors.Is(err, data.ErrNotExists) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return u.Role.AssignableBy(admin), nil
+}
+
+// Create a user for someone logging in through an identity provider for the first time. The account gets a random
+// password, so it can only be logged in to through the provider unless the user resets their password
+func (s *SiteController) provisionSSOUser(p *identityprovider.IdentityProvider, claims *oidc.Claims) (*user.User, error) {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(claims.Name, " ")
+	}
+
+	password, err := crypto.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := crypto.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	base := ssoUsernameBase(claims)
+	username := base
+	for attempt := 1; ; attempt++ {
+		created, err := s.userRepo.Create(user.UserSecret{
+			User: user.User{
+				Username:       username,
+				FirstName:      firstName,
+				LastName:       lastName,
+				Email:          claims.Email,
+				RoleID:         p.DefaultRoleID,
+				OrganizationID: p.OrganizationID,
+			},
+			Password: hash,
+		})
+		if !errors.Is(err, data.ErrDuplicate) || attempt == ssoUsernameMaxAttempts {
+			return created, err
+		}
+
+		// the username is taken, so try again with a random suffix
+		suffix, err := crypto.GenerateToken()
+		if err != nil {
+			return nil, err
+		}
+		username = fmt.Sprintf("%s-%s", base, suffix[:6])
+	}
+}
+
+// Choose a username for a provisioned user from their preferred username or email address, removing any characters
+// that aren't allowed in usernames
+func ssoUsernameBase(claims *oidc.Claims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, name)
+
+	if len(name) > ssoUsernameMaxBaseLength {
+		name = name[:ssoUsernameMaxBaseLength]
+	}
+	if len(name) < validation.UsernameMinLength {
+		name = "sso-" + name
+	}
+	return name
+}
+
+// Get the identity provider settings of an organization, or empty settings if it hasn't configured a provider
+func (s *SiteController) getIdentityProviderSettings(orgID int64) (*identityprovider.IdentityProvider, error) {
+	p, err := s.identityProviderRepo.GetForOrganization(orgID)
+	if errors.Is(err, data.ErrNotExists) {
+		return &identityprovider.IdentityProvider{}, nil
+	}
+	return p, err
+}
+
+// POST /users/sso
+func (s *SiteController) SaveIdentityProvider(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	existing, err := s.identityProviderRepo.GetForOrganization(org.ID)
+	if err != nil && !errors.Is(err, data.ErrNotExists) {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	provider := identityprovider.IdentityProvider{
+		OrganizationID: org.ID,
+		ConfiguredBy:   &u.ID,
+		Name:           strings.TrimSpace(ctx.PostForm("provider_name")),
+		Issuer:         strings.TrimSpace(ctx.PostForm("issuer")),
+		ClientID:       strings.TrimSpace(ctx.PostForm("client_id")),
+		ClientSecret:   ctx.PostForm("client_secret"),
+		Domain:         strings.ToLower(strings.TrimSpace(ctx.PostForm("domain"))),
+		AutoProvision:  ctx.PostForm("auto_provision") == "on",
+		Enabled:        ctx.PostForm("enabled") == "on",
+	}
+	if existing != nil {
+		provider.ID = existing.ID
+		// the secret is never sent back to the browser, so a blank secret keeps the current one
+		if provider.ClientSecret == "" {
+			provider.ClientSecret = existing.ClientSecret
+		}
+	}
+
+	valid, e := validation.ValidateIdentityProvider(provider)
+
+	r, err := s.getAssignableRole(ctx, u, org)
+	if err != nil {
+		e["DefaultRole"] = err.Error()
+		valid = false
+	} else {
+		provider.DefaultRoleID = r.ID
+	}
+
+	if !valid {
+		s.renderUsersPage(ctx, PageData{User: u, Organization: org, IdentityProvider: &provider, Errors: e})
+		return
+	}
+
+	if _, err := s.identityProviderRepo.Save(provider); err != nil {
+		if errors.Is(err, data.ErrDuplicate) {
+			e["Domain"] = "Another organization already uses this domain for single sign-on"
+			s.renderUsersPage(ctx, PageData{User: u, Organization: org, IdentityProvider: &provider, Errors: e})
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	entry := audit.New(org.ID, audit.ActionIdentityProviderUpdated, ctx.ClientIP()).By(u.ID, u.Username)
+	if existing != nil {
+		entry = entry.On(audit.TargetIdentityProvider, existing.ID).WithChanges(audit.Diff(*existing, provider))
+	} else {
+		entry = entry.WithDetails(fmt.Sprintf("issuer: %s | domain: %s | enabled: %t | auto provision: %t", provider.Issuer, provider.Domain, provider.Enabled, provider.AutoProvision))
+	}
+	s.recordAudit(entry)
+
+	s.renderUsers(ctx, u, org, "Single sign-on settings saved!")
+}
@@ -0,0 +1,448 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/organization"
+	"github.com/slimnate/laser-beam/data/twofactor"
+	"github.com/slimnate/laser-beam/data/user"
+)
+
+const (
+	expiredChallengeMessage = "Your login has expired, please log in again"
+	invalidCodeMessage      = "Invalid authentication code"
+)
+
+// Ask a user that has entered a correct password for their two-factor code. The challenge token is sent back with the
+// code, so the second step can't be reached without passing the first
+func (s *SiteController) startTwoFactorChallenge(ctx *gin.Context, userID int64) {
+	token, err := crypto.GenerateToken()
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if _, err := s.twoFactorRepo.CreateChallenge(userID, crypto.HashToken(token)); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	HxRespond(200, ctx, "login_two_factor_form.html", "login_two_factor.html", gin.H{"Token": token})
+}
+
+// Check a code entered by the user, which can be either a code from their authenticator app or an unused recovery code.
+// Each code can only be used once
+func (s *SiteController) checkTwoFactorCode(tf *twofactor.TwoFactor, code string) (bool, error) {
+	if step, ok := crypto.MatchTOTP(tf.Secret, code, time.Now()); ok {
+		err := s.twoFactorRepo.UseStep(tf.UserID, step)
+		if errors.Is(err, data.ErrUpdateFailed) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	err := s.twoFactorRepo.UseRecoveryCode(tf.UserID, crypto.HashRecoveryCode(code))
+	if errors.Is(err, data.ErrUpdateFailed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// POST /login/two-factor
+func (s *SiteController) ProcessLoginTwoFactor(ctx *gin.Context) {
+	token := ctx.PostForm("token")
+
+	challenge, err := s.twoFactorRepo.GetValidChallenge(crypto.HashToken(token))
+	if err != nil {
+		if !errors.Is(err, data.ErrNotExists) {
+			log.Println(err.Error())
+		}
+		HxRespond(401, ctx, "login_form.html", "login.html", gin.H{"Error": expiredChallengeMessage})
+		return
+	}
+
+	tf, err := s.twoFactorRepo.GetByUserID(challenge.UserID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	u, err := s.userRepo.GetByID(challenge.UserID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	ok, err := s.checkTwoFactorCode(tf, ctx.PostForm("code"))
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if !ok {
+		// wrong codes count towards the account's failed logins, so codes can't be guessed by restarting the login
+		s.recordLoginAttempt(u.Username, ctx.ClientIP(), false)
+		s.recordAudit(accountAudit(ctx, u, audit.ActionLoginFailed).WithDetails("invalid two-factor code"))
+
+		remaining, err := s.twoFactorRepo.RecordFailedAttempt(challenge.ID)
+		if err != nil {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+		if remaining == 0 {
+			HxRespond(401, ctx, "login_form.html", "login.html", gin.H{"Error": "Too many invalid codes, please log in again"})
+			return
+		}
+		HxRespond(401, ctx, "login_two_factor_form.html", "login_two_factor.html", gin.H{"Token": token, "Error": invalidCodeMessage})
+		return
+	}
+
+	// challenges are single use - if another request completed it first, make the user start again
+	if err := s.twoFactorRepo.DeleteChallenge(challenge.ID); err != nil {
+		HxRespond(401, ctx, "login_form.html", "login.html", gin.H{"Error": expiredChallengeMessage})
+		return
+	}
+
+	// the user may have been deactivated since entering their password
+	if !u.Active {
+		HxRespond(403, ctx, "login_form.html", "login.html", gin.H{"Error": "This account has been deactivated"})
+		return
+	}
+
+	s.recordLoginAttempt(u.Username, ctx.ClientIP(), true)
+	s.startSession(ctx, u, "password and two-factor code")
+}
+
+// Render the two-factor settings page. Users that aren't enrolled yet are given a new secret to add to their authenticator app
+func (s *SiteController) renderTwoFactor(ctx *gin.Context, u *user.User, org *organization.Organization, pageData PageData) {
+	pageData.User = u
+	pageData.Organization = org
+	pageData.Route = "/account/two-factor"
+
+	tf, err := s.twoFactorRepo.GetByUserID(u.ID)
+	if err != nil && !errors.Is(err, data.ErrNotExists) {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if tf == nil || !tf.Enabled {
+		secret, err := crypto.GenerateTOTPSecret()
+		if err != nil {
+			ctx.AbortWithStatus(500)
+			return
+		}
+
+		tf, err = s.twoFactorRepo.StartEnrollment(u.ID, secret)
+		if err != nil {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+
+		pageData.TwoFactorURI = crypto.TOTPProvisioningURI(twofactor.Issuer, u.Username, tf.Secret)
+	} else {
+		pageData.RemainingRecoveryCodes, err = s.twoFactorRepo.RemainingRecoveryCodes(u.ID)
+		if err != nil {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+	}
+
+	pageData.TwoFactor = tf
+	HxRespond(200, ctx, "user_two_factor.html", "index.html", pageData)
+}
+
+// Generate a new set of recovery codes, returning the codes to show the user and the hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes, err := crypto.GenerateRecoveryCodes(twofactor.RecoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = crypto.HashRecoveryCode(c)
+	}
+	return codes, hashes, nil
+}
+
+// GET /account/two-factor
+func (s *SiteController) RenderTwoFactor(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	pageData := PageData{}
+	if org.RequireTwoFactor {
+		pageData.AddToast(fmt.Sprintf("%s requires two-factor authentication", org.Name))
+	}
+
+	s.renderTwoFactor(ctx, u, org, pageData)
+}
+
+// POST /account/two-factor
+func (s *SiteController) EnableTwoFactor(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	tf, err := s.twoFactorRepo.GetByUserID(u.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrNotExists) {
+			HxRedirect(ctx, "/account/two-factor")
+			return
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// never show the secret again once enrollment is complete
+	if tf.Enabled {
+		HxRedirect(ctx, "/account/two-factor")
+		return
+	}
+
+	// keep the same secret on errors, so the user doesn't have to scan a new one after a typo
+	pageData := PageData{
+		User:         u,
+		Organization: org,
+		TwoFactor:    tf,
+		TwoFactorURI: crypto.TOTPProvisioningURI(twofactor.Issuer, u.Username, tf.Secret),
+		Route:        "/account/two-factor",
+	}
+
+	msg, err := s.confirmCurrentPassword(ctx, u)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+	if msg != "" {
+		pageData.Errors = map[string]string{"CurrentPassword": msg}
+		HxRespond(200, ctx, "user_two_factor.html", "index.html", pageData)
+		return
+	}
+
+	step, ok := crypto.MatchTOTP(tf.Secret, ctx.PostForm("code"), time.Now())
+	if !ok {
+		pageData.Errors = map[string]string{"Code": invalidCodeMessage}
+		HxRespond(200, ctx, "user_two_factor.html", "index.html", pageData)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if err := s.twoFactorRepo.Enable(u.ID, step, hashes); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(accountAudit(ctx, u, audit.ActionTwoFactorEnabled))
+	s.notifyAccountChange(u, u.Email, "Two-factor authentication was enabled.", ctx.ClientIP())
+
+	pageData = PageData{RecoveryCodes: codes}
+	pageData.AddToast("Two-factor authentication enabled!")
+	s.renderTwoFactor(ctx, u, org, pageData)
+}
+
+// Check the current password and code entered to confirm a change to an enabled two-factor setup, re-rendering the page
+// with an error if either is wrong
+func (s *SiteController) confirmTwoFactorChange(ctx *gin.Context, u *user.User, org *organization.Organization) bool {
+	tf, err := s.twoFactorRepo.GetByUserID(u.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrNotExists) {
+			HxRedirect(ctx, "/account/two-factor")
+			return false
+		}
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return false
+	}
+
+	if !tf.Enabled {
+		HxRedirect(ctx, "/account/two-factor")
+		return false
+	}
+
+	msg, err := s.confirmCurrentPassword(ctx, u)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return false
+	}
+	if msg != "" {
+		s.renderTwoFactor(ctx, u, org, PageData{Errors: map[string]string{"CurrentPassword": msg}})
+		return false
+	}
+
+	ok, err := s.checkTwoFactorCode(tf, ctx.PostForm("code"))
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return false
+	}
+
+	if !ok {
+		s.renderTwoFactor(ctx, u, org, PageData{Errors: map[string]string{"Code": invalidCodeMessage}})
+		return false
+	}
+	return true
+}
+
+// POST /account/two-factor/recovery-codes
+func (s *SiteController) RegenerateRecoveryCodes(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if !s.confirmTwoFactorChange(ctx, u, org) {
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if err := s.twoFactorRepo.ReplaceRecoveryCodes(u.ID, hashes); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(accountAudit(ctx, u, audit.ActionRecoveryCodesGenerated))
+	s.notifyAccountChange(u, u.Email, "New two-factor recovery codes were generated. Your previous recovery codes no longer work.", ctx.ClientIP())
+
+	pageData := PageData{RecoveryCodes: codes}
+	pageData.AddToast("New recovery codes generated")
+	s.renderTwoFactor(ctx, u, org, pageData)
+}
+
+// POST /account/two-factor/disable
+func (s *SiteController) DisableTwoFactor(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if org.RequireTwoFactor {
+		s.renderTwoFactor(ctx, u, org, PageData{Errors: map[string]string{"Code": fmt.Sprintf("%s requires two-factor authentication", org.Name)}})
+		return
+	}
+
+	if !s.confirmTwoFactorChange(ctx, u, org) {
+		return
+	}
+
+	if err := s.twoFactorRepo.Disable(u.ID); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(accountAudit(ctx, u, audit.ActionTwoFactorDisabled))
+	s.notifyAccountChange(u, u.Email, "Two-factor authentication was disabled.", ctx.ClientIP())
+
+	data := PageData{
+		User:         u,
+		Organization: org,
+		Route:        "/account",
+	}
+	data.AddToast("Two-factor authentication disabled")
+
+	HxRespond(http.StatusOK, ctx, "user_display.html", "index.html", data)
+}
+
+// POST /users/require-two-factor
+func (s *SiteController) SetRequireTwoFactor(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	require := ctx.PostForm("require") == "on"
+	if err := s.orgRepo.SetRequireTwoFactor(org.ID, require); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+	if org.RequireTwoFactor != require {
+		s.recordAudit(auditBy(ctx, u, audit.ActionOrganizationUpdated).On(audit.TargetOrganization, org.ID).
+			WithChanges([]audit.Change{{Field: "RequireTwoFactor", Old: strconv.FormatBool(org.RequireTwoFactor), New: strconv.FormatBool(require)}}))
+	}
+	org.RequireTwoFactor = require
+
+	if require {
+		s.renderUsers(ctx, u, org, "Two-factor authentication is now required for all users")
+		return
+	}
+	s.renderUsers(ctx, u, org, "Two-factor authentication is now optional")
+}
+
+// POST /users/:user_id/reset-two-factor
+func (s *SiteController) ResetUserTwoFactor(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	managed, err := s.getManagedUser(ctx, u, org)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	if managed.ID == u.ID {
+		s.renderUsers(ctx, u, org, "Use your account page to manage your own two-factor authentication")
+		return
+	}
+
+	// the account still signs in to its own organization, so only that organization's admins can reset it
+	if !managed.BelongsTo(org.ID) {
+		s.renderUsers(ctx, u, org, "Two-factor authentication of members from other organizations can't be reset, ask their organization's admin instead")
+		return
+	}
+
+	if err := s.twoFactorRepo.Disable(managed.ID); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(audit.New(org.ID, audit.ActionTwoFactorDisabled, ctx.ClientIP()).By(u.ID, u.Username).On(audit.TargetUser, managed.ID).WithDetails("reset by administrator"))
+	s.notifyAccountChange(managed, managed.Email, fmt.Sprintf("Two-factor authentication was reset by %s.", u.FullName()), ctx.ClientIP())
+
+	s.renderUsers(ctx, u, org, fmt.Sprintf("Two-factor authentication reset for %s", managed.FullName()))
+}
@@ -0,0 +1,49 @@
+package site
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/data/usage"
+)
+
+// Number of months before the current one shown in the usage history
+const UsageHistoryMonths = 12
+
+// GET /usage
+func (s *SiteController) RenderUsage(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	current, err := s.usageRepo.Current(org.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	history, err := s.usageRepo.History(org.ID, UsageHistoryMonths)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	pageData := PageData{
+		User:         u,
+		Organization: org,
+		Usage: &usage.Summary{
+			Current:   *current,
+			Quota:     s.limitConfig.EffectiveQuota(org.MonthlyQuota),
+			RateLimit: s.limitConfig.EffectiveRate(org.RateLimit),
+			Burst:     s.limitConfig.Burst,
+			History:   history,
+		},
+		Route: "/usage",
+	}
+
+	HxRespond(200, ctx, "usage.html", "index.html", pageData)
+}
@@ -0,0 +1,378 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slimnate/laser-beam/auth"
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/audit"
+	"github.com/slimnate/laser-beam/data/organization"
+	"github.com/slimnate/laser-beam/data/role"
+	"github.com/slimnate/laser-beam/data/user"
+	"github.com/slimnate/laser-beam/validation"
+)
+
+var errCannotManageSelf = errors.New("you cannot change your own role or status")
+
+// Get the user referenced by the :user_id path param. The user must belong to the supplied organization, and the current user must
+// hold every permission of their role to be allowed to manage them
+func (s *SiteController) getManagedUser(ctx *gin.Context, current *user.User, org *organization.Organization) (*user.User, error) {
+	id, err := strconv.ParseInt(ctx.Param("user_id"), 10, 64)
+	if err != nil {
+		return nil, data.ErrNotExists
+	}
+
+	managed, err := s.userRepo.GetByIDForOrganization(id, org.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !managed.Role.AssignableBy(current) {
+		return nil, errors.New("not authorized to manage this user")
+	}
+
+	return managed, nil
+}
+
+// Get the role with the ID supplied in the `role_id` form field, if it can be assigned to users of the organization by the current user
+func (s *SiteController) getAssignableRole(ctx *gin.Context, current *user.User, org *organization.Organization) (*role.Role, error) {
+	roleID, err := strconv.ParseInt(ctx.PostForm("role_id"), 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid role")
+	}
+
+	r, err := s.roleRepo.GetByIDForOrganization(roleID, org.ID)
+	if err != nil {
+		return nil, errors.New("Invalid role")
+	}
+
+	if !r.AssignableBy(current) {
+		return nil, errors.New("You cannot assign a role with more permissions than your own")
+	}
+
+	return r, nil
+}
+
+// Render the user list with an optional toast message
+func (s *SiteController) renderUsers(ctx *gin.Context, u *user.User, org *organization.Organization, toast string) {
+	data := PageData{
+		User:         u,
+		Organization: org,
+	}
+	if toast != "" {
+		data.AddToast(toast)
+	}
+
+	s.renderUsersPage(ctx, data)
+}
+
+// Render the user list page, filling in the users, pending invitations and (unless already set) password policy of the organization
+func (s *SiteController) renderUsersPage(ctx *gin.Context, data PageData) {
+	users, err := s.userRepo.AllForOrganization(data.Organization.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	invitations, err := s.invitationRepo.AllPendingForOrganization(data.Organization.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if data.PasswordPolicy == nil {
+		data.PasswordPolicy, err = s.policyRepo.GetForOrganization(data.Organization.ID)
+		if err != nil {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+	}
+
+	if data.User.HasPermission(auth.PermissionManageOrganization) {
+		if data.IdentityProvider == nil {
+			data.IdentityProvider, err = s.getIdentityProviderSettings(data.Organization.ID)
+			if err != nil {
+				log.Println(err.Error())
+				ctx.AbortWithStatus(500)
+				return
+			}
+		}
+
+		// roles that can be chosen as the default for users created by single sign-on
+		data.Roles, err = s.roleRepo.AllForOrganization(data.Organization.ID)
+		if err != nil {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+	}
+
+	data.Users = users
+	data.Invitations = invitations
+	data.Route = "/users"
+
+	HxRespond(200, ctx, "users.html", "index.html", data)
+}
+
+// GET /users
+func (s *SiteController) RenderUsers(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.renderUsers(ctx, u, org, "")
+}
+
+// GET /users/new
+func (s *SiteController) RenderInviteUserForm(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	roles, err := s.roleRepo.AllForOrganization(org.ID)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	data := PageData{
+		User:         u,
+		Organization: org,
+		ManagedUser:  &user.User{},
+		Roles:        roles,
+		Route:        "/users/new",
+	}
+
+	HxRespond(200, ctx, "user_invite.html", "index.html", data)
+}
+
+// GET /users/:user_id/edit
+func (s *SiteController) RenderManageUserForm(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	managed, err := s.getManagedUser(ctx, u, org)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	roles, err := s.roleRepo.AllForOrganization(org.ID)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	data := PageData{
+		User:         u,
+		Organization: org,
+		ManagedUser:  managed,
+		Roles:        roles,
+		Route:        "/users/:user_id/edit",
+	}
+
+	HxRespond(200, ctx, "user_manage_form.html", "index.html", data)
+}
+
+// POST /users/:user_id
+func (s *SiteController) UpdateManagedUser(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	managed, err := s.getManagedUser(ctx, u, org)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	roles, err := s.roleRepo.AllForOrganization(org.ID)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	// members from other organizations manage their own details, so only their role is changed here
+	before := *managed
+	if managed.BelongsTo(org.ID) {
+		managed.FirstName = ctx.PostForm("first_name")
+		managed.LastName = ctx.PostForm("last_name")
+		managed.Email = ctx.PostForm("email")
+		managed.Phone = ctx.PostForm("phone")
+	}
+
+	data := PageData{
+		User:         u,
+		Organization: org,
+		ManagedUser:  managed,
+		Roles:        roles,
+		Route:        "/users/:user_id/edit",
+	}
+
+	valid, e := validation.ValidateUserUpdate(managed)
+
+	r, err := s.getAssignableRole(ctx, u, org)
+	if err != nil {
+		e["Role"] = err.Error()
+		valid = false
+	} else if r.ID != managed.RoleID && managed.ID == u.ID {
+		e["Role"] = errCannotManageSelf.Error()
+		valid = false
+	}
+
+	if !valid {
+		data.Errors = e
+		HxRespond(200, ctx, "user_manage_form.html", "index.html", data)
+		return
+	}
+
+	if r.ID != managed.RoleID {
+		if _, err := s.userRepo.UpdateRole(managed.ID, org.ID, r.ID); err != nil {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+	}
+
+	if _, err := s.userRepo.UpdateUserInfo(managed.ID, *managed); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	updated, err := s.userRepo.GetByIDForOrganization(managed.ID, org.ID)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if changes := audit.Diff(before, *updated); len(changes) > 0 {
+		s.recordAudit(auditBy(ctx, u, audit.ActionUserUpdated).On(audit.TargetUser, updated.ID).WithChanges(changes))
+	}
+
+	s.renderUsers(ctx, u, org, fmt.Sprintf("Successfully updated %s!", updated.FullName()))
+}
+
+// POST /users/:user_id/deactivate
+func (s *SiteController) DeactivateUser(ctx *gin.Context) {
+	s.setUserActive(ctx, false)
+}
+
+// POST /users/:user_id/activate
+func (s *SiteController) ActivateUser(ctx *gin.Context) {
+	s.setUserActive(ctx, true)
+}
+
+func (s *SiteController) setUserActive(ctx *gin.Context, active bool) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	managed, err := s.getManagedUser(ctx, u, org)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	if managed.ID == u.ID {
+		s.renderUsers(ctx, u, org, errCannotManageSelf.Error())
+		return
+	}
+
+	if !managed.BelongsTo(org.ID) {
+		s.renderUsers(ctx, u, org, "Members from other organizations can't be deactivated, remove them from the organization instead")
+		return
+	}
+
+	updated, err := s.userRepo.SetActive(managed.ID, active)
+	if err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if active {
+		s.recordAudit(auditBy(ctx, u, audit.ActionUserActivated).On(audit.TargetUser, updated.ID))
+		s.renderUsers(ctx, u, org, fmt.Sprintf("Successfully activated %s!", updated.FullName()))
+		return
+	}
+
+	// deactivated users are logged out immediately
+	if _, err := s.sessionRepo.DeleteAllForUser(managed.ID); err != nil {
+		log.Println("Unable to delete sessions for deactivated user: " + err.Error())
+	}
+
+	s.recordAudit(auditBy(ctx, u, audit.ActionUserDeactivated).On(audit.TargetUser, updated.ID))
+
+	s.renderUsers(ctx, u, org, fmt.Sprintf("Successfully deactivated %s!", updated.FullName()))
+}
+
+// DELETE /users/:user_id. Members from other organizations are removed from the organization, rather than their
+// account being deleted
+func (s *SiteController) DeleteUser(ctx *gin.Context) {
+	u, org, err := s.GetUserOrg(ctx)
+	if err != nil {
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	managed, err := s.getManagedUser(ctx, u, org)
+	if err != nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+
+	if managed.ID == u.ID {
+		s.renderUsers(ctx, u, org, "You cannot delete your own account")
+		return
+	}
+
+	if !managed.BelongsTo(org.ID) {
+		if err := s.membershipRepo.Remove(managed.ID, org.ID); err != nil {
+			log.Println(err.Error())
+			ctx.AbortWithStatus(500)
+			return
+		}
+
+		s.recordAudit(auditBy(ctx, u, audit.ActionMemberRemoved).On(audit.TargetUser, managed.ID).WithDetails(managed.Username))
+
+		s.renderUsers(ctx, u, org, fmt.Sprintf("Successfully removed %s from %s!", managed.FullName(), org.Name))
+		return
+	}
+
+	if _, err := s.sessionRepo.DeleteAllForUser(managed.ID); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	if err := s.userRepo.Delete(managed.ID); err != nil {
+		log.Println(err.Error())
+		ctx.AbortWithStatus(500)
+		return
+	}
+
+	s.recordAudit(auditBy(ctx, u, audit.ActionUserDeleted).On(audit.TargetUser, managed.ID).
+		WithDetails(fmt.Sprintf("%s (%s)", managed.Username, managed.Email)))
+
+	s.renderUsers(ctx, u, org, fmt.Sprintf("Successfully deleted %s!", managed.FullName()))
+}
@@ -5,3 +5,10 @@ document.getElementsByClassName("noscript").item(0).className = "";
 function handleAfterSettle() {
   initFlowbite();
 }
+
+// htmx doesn't swap error responses, so show the message for rejected requests (eg. an expired CSRF token)
+document.body.addEventListener("htmx:responseError", function (evt) {
+  if (evt.detail.xhr.status === 403 && evt.detail.xhr.responseText) {
+    alert(evt.detail.xhr.responseText);
+  }
+});
@@ -0,0 +1,67 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slimnate/laser-beam/data/event"
+)
+
+// Lengths of the event columns, so events that can't be saved are rejected before they are queued
+const (
+	EventTypeMaxLength        = 50
+	EventNameMaxLength        = 250
+	EventApplicationMaxLength = 50
+	EventMessageMaxLength     = 1000
+	EventEnvironmentMaxLength = 50
+	EventReleaseMaxLength     = 100
+)
+
+// Validates an event sent to the API before it is saved. Events sent without a time are given the current time
+func ValidateEvent(e *event.Event) (valid bool, errors map[string]string) {
+	valid = true
+	errors = make(map[string]string)
+
+	e.Type = strings.TrimSpace(e.Type)
+	e.Name = strings.TrimSpace(e.Name)
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if e.Type == "" || len(e.Type) > EventTypeMaxLength {
+		errors["Type"] = fmt.Sprintf("Type is required, and cannot be longer than %d characters", EventTypeMaxLength)
+		valid = false
+	}
+
+	if e.Name == "" || len(e.Name) > EventNameMaxLength {
+		errors["Name"] = fmt.Sprintf("Name is required, and cannot be longer than %d characters", EventNameMaxLength)
+		valid = false
+	}
+
+	lengths := []struct {
+		field string
+		value string
+		max   int
+	}{
+		{"Application", e.Application, EventApplicationMaxLength},
+		{"Message", e.Message, EventMessageMaxLength},
+		{"Environment", e.Environment, EventEnvironmentMaxLength},
+		{"Release", e.Release, EventReleaseMaxLength},
+	}
+	for _, l := range lengths {
+		if len(l.value) > l.max {
+			errors[l.field] = fmt.Sprintf("%s cannot be longer than %d characters", l.field, l.max)
+			valid = false
+		}
+	}
+
+	return
+}
+
+// Implements event.Validator
+type EventValidator struct{}
+
+func (EventValidator) ValidateEvent(e *event.Event) (bool, map[string]string) {
+	return ValidateEvent(e)
+}
@@ -0,0 +1,58 @@
+package validation
+
+import (
+	"net/url"
+	"os"
+	"regexp"
+
+	"github.com/slimnate/laser-beam/data/identityprovider"
+)
+
+const IdentityProviderNameMaxLength = 64
+
+var domainPattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?\.)+[a-zA-Z]{2,}$`)
+
+// Validates the single sign-on settings configured by an organization. The issuer must use https, except for local
+// providers in the dev environment. Anyone can sign up and configure a provider, so outside of dev a local issuer would
+// let them make the server send requests to services only reachable from it. Hosts that resolve to private addresses
+// are refused by the oidc package when it connects
+func ValidateIdentityProvider(p identityprovider.IdentityProvider) (valid bool, errors map[string]string) {
+	valid = true
+	errors = make(map[string]string)
+
+	if p.Name == "" || len(p.Name) > IdentityProviderNameMaxLength {
+		errors["ProviderName"] = "Name is required, and cannot be longer than 64 characters"
+		valid = false
+	}
+
+	issuer, err := url.Parse(p.Issuer)
+	if err != nil || issuer.Host == "" || issuer.RawQuery != "" || issuer.Fragment != "" {
+		errors["Issuer"] = "Issuer must be a valid URL"
+		valid = false
+	} else if issuer.Scheme != "https" && !(issuer.Scheme == "http" && localIssuerAllowed(issuer.Hostname())) {
+		errors["Issuer"] = "Issuer must use https"
+		valid = false
+	}
+
+	if p.ClientID == "" {
+		errors["ClientID"] = "Client ID is required"
+		valid = false
+	}
+
+	if p.ClientSecret == "" {
+		errors["ClientSecret"] = "Client secret is required"
+		valid = false
+	}
+
+	if !domainPattern.MatchString(p.Domain) {
+		errors["Domain"] = "Domain must be a valid email domain, eg. example.com"
+		valid = false
+	}
+
+	return
+}
+
+// Check whether a plain http issuer on `host` is allowed, which is only for providers on the local machine in dev
+func localIssuerAllowed(host string) bool {
+	return os.Getenv("APP_ENV") == "dev" && (host == "localhost" || host == "127.0.0.1")
+}
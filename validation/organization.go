@@ -0,0 +1,66 @@
+package validation
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slimnate/laser-beam/data"
+	"github.com/slimnate/laser-beam/data/event"
+	"github.com/slimnate/laser-beam/data/organization"
+)
+
+const (
+	OrganizationNameMaxLength = 100
+	MaxRetentionDays          = 3650
+	MaxPageSize               = 100
+)
+
+// Validates the details and settings of an organization before it is created or updated. Unset settings are filled in
+// with their defaults
+func ValidateOrganization(org *organization.Organization) (valid bool, errors map[string]string) {
+	valid = true
+	errors = make(map[string]string)
+
+	*org = org.WithDefaults()
+
+	org.Name = strings.TrimSpace(org.Name)
+	if org.Name == "" || len(org.Name) > OrganizationNameMaxLength {
+		errors["OrganizationName"] = fmt.Sprintf("Organization name is required, and cannot be longer than %d characters", OrganizationNameMaxLength)
+		valid = false
+	}
+
+	// time.LoadLocation treats "Local" as the server's time zone, which isn't meaningful to users
+	if _, err := time.LoadLocation(org.Timezone); err != nil || org.Timezone == "Local" {
+		errors["Timezone"] = "Time zone must be a valid IANA time zone, eg. America/Chicago"
+		valid = false
+	}
+
+	if org.RetentionDays < 0 || org.RetentionDays > MaxRetentionDays {
+		errors["RetentionDays"] = fmt.Sprintf("Retention must be between 0 (keep forever) and %d days", MaxRetentionDays)
+		valid = false
+	}
+
+	if org.DefaultPageSize < 1 || org.DefaultPageSize > MaxPageSize {
+		errors["DefaultPageSize"] = fmt.Sprintf("Page size must be between 1 and %d", MaxPageSize)
+		valid = false
+	}
+
+	if org.DefaultFilter != "" {
+		f, err := data.FilterOptionsFromString(org.DefaultFilter)
+		if err != nil || f.Value == "" || !slices.Contains(event.FilterColumns, f.Key) {
+			errors["DefaultFilter"] = fmt.Sprintf("Default filter must be in the format column:value, where column is one of %s", strings.Join(event.FilterColumns, ", "))
+			valid = false
+		}
+	}
+
+	return
+}
+
+// Implements organization.Validator
+type OrganizationValidator struct{}
+
+func (OrganizationValidator) ValidateOrganization(org *organization.Organization) (bool, map[string]string) {
+	return ValidateOrganization(org)
+}
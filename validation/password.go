@@ -0,0 +1,97 @@
+package validation
+
+import (
+	"bufio"
+	_ "embed"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/slimnate/laser-beam/crypto"
+	"github.com/slimnate/laser-beam/data/passwordpolicy"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var (
+	commonPasswords     map[string]struct{}
+	commonPasswordsOnce sync.Once
+)
+
+// Returns true if the password is in the embedded list of common passwords, ignoring case
+func isCommonPassword(password string) bool {
+	commonPasswordsOnce.Do(func() {
+		commonPasswords = make(map[string]struct{})
+		scanner := bufio.NewScanner(strings.NewReader(commonPasswordList))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			commonPasswords[strings.ToLower(line)] = struct{}{}
+		}
+	})
+
+	_, ok := commonPasswords[strings.ToLower(password)]
+	return ok
+}
+
+// Get descriptions of the character classes required by the policy that the password doesn't contain
+func missingCharacterClasses(password string, policy passwordpolicy.PasswordPolicy) []string {
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	var missing []string
+	if policy.RequireUppercase && !upper {
+		missing = append(missing, "an uppercase letter")
+	}
+	if policy.RequireLowercase && !lower {
+		missing = append(missing, "a lowercase letter")
+	}
+	if policy.RequireDigit && !digit {
+		missing = append(missing, "a number")
+	}
+	if policy.RequireSymbol && !symbol {
+		missing = append(missing, "a symbol")
+	}
+	return missing
+}
+
+// Join items into a readable list, eg. "a, b and c"
+func joinList(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
+
+// Returns true if the password matches any of the supplied password hashes. Hashes are compared concurrently, since
+// each bcrypt comparison is deliberately slow
+func PasswordReused(password string, hashes []string) bool {
+	results := make(chan bool, len(hashes))
+	for _, h := range hashes {
+		go func(h string) {
+			results <- crypto.TestMatch(password, h)
+		}(h)
+	}
+
+	reused := false
+	for range hashes {
+		if <-results {
+			reused = true
+		}
+	}
+	return reused
+}
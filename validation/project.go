@@ -0,0 +1,54 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/slimnate/laser-beam/data/project"
+)
+
+const ProjectNameMaxLength = 50
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Validates the details and settings of an application before it is created or updated. Unset settings are filled in
+// with their defaults, and the slug is generated from the name if it is empty
+func ValidateProject(p *project.Project) (valid bool, errors map[string]string) {
+	valid = true
+	errors = make(map[string]string)
+
+	p.Name = strings.TrimSpace(p.Name)
+	p.Slug = strings.TrimSpace(p.Slug)
+	*p = p.WithDefaults()
+
+	if p.Name == "" || len(p.Name) > ProjectNameMaxLength {
+		errors["Name"] = fmt.Sprintf("Application name is required, and cannot be longer than %d characters", ProjectNameMaxLength)
+		valid = false
+	}
+
+	if len(p.Slug) > ProjectNameMaxLength || !slugPattern.MatchString(p.Slug) {
+		errors["Slug"] = "Slug may only contain lowercase letters and numbers, separated by single '-' characters"
+		valid = false
+	}
+
+	if !slices.Contains(project.Platforms, p.Platform) {
+		errors["Platform"] = fmt.Sprintf("Platform must be one of %s", strings.Join(project.Platforms, ", "))
+		valid = false
+	}
+
+	if p.RetentionDays < 0 || p.RetentionDays > MaxRetentionDays {
+		errors["RetentionDays"] = fmt.Sprintf("Retention must be between 0 (use the organization's) and %d days", MaxRetentionDays)
+		valid = false
+	}
+
+	return
+}
+
+// Implements project.Validator
+type ProjectValidator struct{}
+
+func (ProjectValidator) ValidateProject(p *project.Project) (bool, map[string]string) {
+	return ValidateProject(p)
+}
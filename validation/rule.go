@@ -0,0 +1,77 @@
+package validation
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/slimnate/laser-beam/data/rule"
+)
+
+// Longest value each rewritable event field can hold
+var rewriteFieldMaxLengths = map[string]int{
+	"type":        50,
+	"name":        250,
+	"message":     1000,
+	"environment": 50,
+	"release":     100,
+}
+
+// Fields that events can't be sent without, so can't be rewritten to an empty value
+var requiredRewriteFields = []string{"type", "name"}
+
+// Validates an ingestion rule before it is created. Settings that don't apply to the rule's action are cleared
+func ValidateRule(r *rule.Rule) (valid bool, errors map[string]string) {
+	valid = true
+	errors = make(map[string]string)
+
+	r.Type = strings.TrimSpace(r.Type)
+	r.NamePattern = strings.TrimSpace(r.NamePattern)
+	r.Tag = strings.TrimSpace(r.Tag)
+
+	if len(r.Type) > rewriteFieldMaxLengths["type"] {
+		errors["Type"] = fmt.Sprintf("Type cannot be longer than %d characters", rewriteFieldMaxLengths["type"])
+		valid = false
+	}
+
+	if len(r.NamePattern) > rewriteFieldMaxLengths["name"] {
+		errors["NamePattern"] = fmt.Sprintf("Name pattern cannot be longer than %d characters", rewriteFieldMaxLengths["name"])
+		valid = false
+	}
+
+	if r.Tag != "" {
+		key, value, _ := strings.Cut(r.Tag, ":")
+		if value == "" || !slices.Contains(rule.TagKeys, key) || len(value) > rewriteFieldMaxLengths[key] {
+			errors["Tag"] = fmt.Sprintf("Tag must be in the format key:value, where key is one of %s", strings.Join(rule.TagKeys, ", "))
+			valid = false
+		}
+	}
+
+	switch r.Action {
+	case rule.ActionSample:
+		if r.SampleRate < 1 || r.SampleRate > 99 {
+			errors["SampleRate"] = "Sample rate must be between 1 and 99 percent"
+			valid = false
+		}
+		r.Field, r.Value = "", ""
+	case rule.ActionDrop:
+		r.SampleRate, r.Field, r.Value = 0, "", ""
+	case rule.ActionRewrite:
+		if !slices.Contains(rule.RewriteFields, r.Field) {
+			errors["Field"] = fmt.Sprintf("Field must be one of %s", strings.Join(rule.RewriteFields, ", "))
+			valid = false
+		} else if len(r.Value) > rewriteFieldMaxLengths[r.Field] {
+			errors["Value"] = fmt.Sprintf("Value cannot be longer than %d characters", rewriteFieldMaxLengths[r.Field])
+			valid = false
+		} else if r.Value == "" && slices.Contains(requiredRewriteFields, r.Field) {
+			errors["Value"] = fmt.Sprintf("Value is required when rewriting the %s", r.Field)
+			valid = false
+		}
+		r.SampleRate = 0
+	default:
+		errors["Action"] = fmt.Sprintf("Action must be one of %s", strings.Join(rule.Actions, ", "))
+		valid = false
+	}
+
+	return
+}
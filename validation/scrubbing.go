@@ -0,0 +1,43 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/slimnate/laser-beam/data/scrubbing"
+)
+
+// Validates the scrubbing policy of an organization before it is saved. Blank custom patterns are removed
+func ValidateScrubbingPolicy(p *scrubbing.ScrubbingPolicy) (valid bool, errors map[string]string) {
+	valid = true
+	errors = make(map[string]string)
+
+	var patterns []string
+	for _, pattern := range p.CustomPatterns {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	p.CustomPatterns = patterns
+
+	if len(p.CustomPatterns) > scrubbing.MaxCustomPatterns {
+		errors["CustomPatterns"] = fmt.Sprintf("No more than %d custom patterns can be used", scrubbing.MaxCustomPatterns)
+		return false, errors
+	}
+
+	for _, pattern := range p.CustomPatterns {
+		if len(pattern) > scrubbing.MaxCustomPatternLength {
+			errors["CustomPatterns"] = fmt.Sprintf("Custom patterns cannot be longer than %d characters", scrubbing.MaxCustomPatternLength)
+			valid = false
+			break
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			errors["CustomPatterns"] = fmt.Sprintf("Invalid pattern '%s': %s", pattern, err.Error())
+			valid = false
+			break
+		}
+	}
+
+	return
+}
@@ -2,19 +2,60 @@ package validation
 
 import (
 	"fmt"
+	"log"
 	"net/mail"
+	"regexp"
 
 	"github.com/nyaruka/phonenumbers"
+	"github.com/slimnate/laser-beam/data/passwordpolicy"
 	"github.com/slimnate/laser-beam/data/user"
 )
 
 const (
+	UsernameMinLength  = 3
+	UsernameMaxLength  = 50
 	FirstNameMinLength = 3
 	LastNameMinLength  = 3
-	PasswordMinLength  = 4
 	PasswordMaxLength  = 64
 )
 
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Validates a new user before creating it, checking the username and password in addition to the fields checked by ValidateUserUpdate.
+// The password must meet the supplied policy
+func ValidateNewUser(u *user.UserSecret, confirmPassword string, policy passwordpolicy.PasswordPolicy) (valid bool, errors map[string]string) {
+	valid, errors = ValidateUserUpdate(&u.User)
+
+	if len(u.Username) < UsernameMinLength || len(u.Username) > UsernameMaxLength {
+		errors["Username"] = fmt.Sprintf("Username must be between %d and %d characters", UsernameMinLength, UsernameMaxLength)
+		valid = false
+	} else if !usernamePattern.MatchString(u.Username) {
+		errors["Username"] = "Username may only contain letters, numbers, '.', '-' and '_'"
+		valid = false
+	}
+
+	passwordValid, passwordErrors := ValidatePasswordUpdate(u.Password, confirmPassword, policy)
+	if !passwordValid {
+		errors["Password"] = passwordErrors["Password"]
+		valid = false
+	}
+
+	return
+}
+
+// Validates the email address an invitation will be sent to
+func ValidateInvitation(email string) (valid bool, errors map[string]string) {
+	valid = true
+	errors = make(map[string]string)
+
+	if _, err := mail.ParseAddress(email); err != nil {
+		errors["Email"] = "Invalid email address format"
+		valid = false
+	}
+
+	return
+}
+
 // Validates the following properties on the user object:
 // - FirstName
 // - LastName
@@ -54,7 +95,9 @@ func ValidateUserUpdate(u *user.User) (valid bool, errors map[string]string) {
 	return
 }
 
-func ValidatePasswordUpdate(password string, confirmPassword string) (valid bool, errors map[string]string) {
+// Validates a new password against the supplied policy. Password history is checked separately with PasswordReused,
+// since it needs the user's previous password hashes
+func ValidatePasswordUpdate(password string, confirmPassword string, policy passwordpolicy.PasswordPolicy) (valid bool, errors map[string]string) {
 	valid = true
 	errors = make(map[string]string)
 	if password != confirmPassword {
@@ -63,8 +106,8 @@ func ValidatePasswordUpdate(password string, confirmPassword string) (valid bool
 		return
 	}
 
-	if len(password) < PasswordMinLength {
-		errors["Password"] = fmt.Sprintf("Password must be at least %d characters long", PasswordMinLength)
+	if len(password) < policy.MinLength {
+		errors["Password"] = fmt.Sprintf("Password must be at least %d characters long", policy.MinLength)
 		valid = false
 		return
 	}
@@ -75,7 +118,60 @@ func ValidatePasswordUpdate(password string, confirmPassword string) (valid bool
 		return
 	}
 
-	//TODO: password requirements (eg. digits, special chars, blacklisted words, etc.)
+	if missing := missingCharacterClasses(password, policy); len(missing) > 0 {
+		errors["Password"] = "Password must contain " + joinList(missing)
+		valid = false
+		return
+	}
+
+	if policy.RejectCommon && isCommonPassword(password) {
+		errors["Password"] = "This password is too common, please choose a different one"
+		valid = false
+		return
+	}
 
 	return
 }
+
+// Validates the settings of a password policy configured by an organization
+func ValidatePasswordPolicy(p passwordpolicy.PasswordPolicy) (valid bool, errors map[string]string) {
+	valid = true
+	errors = make(map[string]string)
+
+	if p.MinLength < passwordpolicy.MinLengthFloor || p.MinLength > passwordpolicy.MinLengthCeiling {
+		errors["MinLength"] = fmt.Sprintf("Minimum length must be between %d and %d", passwordpolicy.MinLengthFloor, passwordpolicy.MinLengthCeiling)
+		valid = false
+	}
+
+	if p.HistoryCount < 0 || p.HistoryCount > passwordpolicy.MaxHistoryCount {
+		errors["HistoryCount"] = fmt.Sprintf("Password history must be between 0 and %d", passwordpolicy.MaxHistoryCount)
+		valid = false
+	}
+
+	if p.MaxAgeDays < 0 || p.MaxAgeDays > passwordpolicy.MaxPasswordAge {
+		errors["MaxAgeDays"] = fmt.Sprintf("Maximum age must be between 0 and %d days", passwordpolicy.MaxPasswordAge)
+		valid = false
+	}
+
+	return
+}
+
+// Implements user.Validator
+type UserValidator struct {
+	Policies *passwordpolicy.PasswordPolicyRepository
+}
+
+func (v UserValidator) ValidateNewUser(u *user.UserSecret) (bool, map[string]string) {
+	policy, err := v.Policies.GetForOrganization(u.OrganizationID)
+	if err != nil {
+		// fall back to the default policy rather than failing the request
+		log.Println("Unable to get password policy: " + err.Error())
+		p := passwordpolicy.Default(u.OrganizationID)
+		policy = &p
+	}
+	return ValidateNewUser(u, u.Password, *policy)
+}
+
+func (UserValidator) ValidateUserUpdate(u *user.User) (bool, map[string]string) {
+	return ValidateUserUpdate(u)
+}